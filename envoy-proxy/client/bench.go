@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runBenchmark 压测模式：多个并发客户端以请求-响应方式发送 PING，统计吞吐与延迟分布。
+// 用于评估游戏服务器读取与处理协程配置（UDP_READERS/UDP_WORKERS）的处理能力。
+// port 为0时每个客户端经目录服务查询 BENCH_SERVER_ID 的地址
+func runBenchmark(host string, port, total, concurrency int, message string) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	perClient := total / concurrency
+
+	var (
+		wg        sync.WaitGroup
+		failures  atomic.Uint64
+		mu        sync.Mutex
+		latencies = make([]time.Duration, 0, perClient*concurrency)
+	)
+
+	target := benchServerID()
+	if port > 0 {
+		target = fmt.Sprintf("%s:%d", benchHost(host), port)
+	}
+	log.Printf("🏁 压测开始: %s，%d 个客户端，每个 %d 个请求，消息 %q", target, concurrency, perClient, message)
+	start := time.Now()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			client, err := newBenchClient(host, port)
+			if err != nil {
+				log.Printf("❌ 客户端 %d 查询服务器失败: %v", id, err)
+				failures.Add(uint64(perClient))
+				return
+			}
+			if err := client.Connect(); err != nil {
+				log.Printf("❌ 客户端 %d 连接失败: %v", id, err)
+				failures.Add(uint64(perClient))
+				return
+			}
+			defer client.Close()
+			if err := client.Authenticate(benchServerID()); err != nil {
+				log.Printf("❌ 客户端 %d 认证失败: %v", id, err)
+				failures.Add(uint64(perClient))
+				return
+			}
+
+			local := make([]time.Duration, 0, perClient)
+			for j := 0; j < perClient; j++ {
+				t := time.Now()
+				if _, err := client.SendMessage(message); err != nil {
+					failures.Add(1)
+					continue
+				}
+				local = append(local, time.Since(t))
+			}
+
+			mu.Lock()
+			latencies = append(latencies, local...)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(float64(len(latencies)-1)*p)]
+	}
+
+	log.Printf("📈 压测完成: 成功 %d，失败 %d，耗时 %v，吞吐 %.0f 包/秒",
+		len(latencies), failures.Load(), elapsed.Round(time.Millisecond), float64(len(latencies))/elapsed.Seconds())
+	log.Printf("⏱️ 延迟: p50=%v p90=%v p99=%v max=%v",
+		percentile(0.5), percentile(0.9), percentile(0.99), percentile(1))
+}
+
+// benchConfig 从环境变量读取压测配置，BENCH_PACKETS 未设置时返回 false
+func benchConfig() (port, total, concurrency int, message string, ok bool) {
+	total, err := strconv.Atoi(os.Getenv("BENCH_PACKETS"))
+	if err != nil || total <= 0 {
+		return 0, 0, 0, "", false
+	}
+
+	if p, err := strconv.Atoi(os.Getenv("BENCH_PORT")); err == nil {
+		port = p
+	}
+	concurrency = 16
+	if c, err := strconv.Atoi(os.Getenv("BENCH_CONCURRENCY")); err == nil && c > 0 {
+		concurrency = c
+	}
+	message = os.Getenv("BENCH_MESSAGE")
+	if message == "" {
+		message = "PING"
+	}
+	return port, total, concurrency, message, true
+}
+
+// newBenchClient 创建压测客户端。指定 BENCH_PORT 时直连该端口，否则经目录服务查询，每个客户端得到各自的票据
+func newBenchClient(host string, port int) (*UDPClient, error) {
+	serverID := benchServerID()
+	if port > 0 {
+		return NewUDPClient(benchHost(host), port, serverID), nil
+	}
+	route, err := resolveServer(directoryURL(), serverID)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = route.Host
+	}
+	client := NewUDPClient(host, route.Port, serverID)
+	client.Ticket = route.Ticket
+	return client, nil
+}
+
+// benchHost 直连时的目标地址，SERVER_HOST 未设置时为 localhost
+func benchHost(host string) string {
+	if host == "" {
+		return "localhost"
+	}
+	return host
+}
+
+// benchServerID 压测目标的服务器ID，经目录服务查询与签发票据时使用
+func benchServerID() string {
+	if id := os.Getenv("BENCH_SERVER_ID"); id != "" {
+		return id
+	}
+	return "game-server-1"
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDirectoryURL 控制平面目录服务的默认地址
+	defaultDirectoryURL = "http://localhost:8090"
+	directoryTimeout    = 5 * time.Second
+)
+
+var directoryClient = &http.Client{Timeout: directoryTimeout}
+
+// serverRoute 目录服务返回的战斗服连接信息，列表查询时只有 ServerID 与端口
+type serverRoute struct {
+	ServerID string `json:"server_id"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	// QUICPort 开启边缘加密时的 HTTP/3 CONNECT-UDP 端口。测试客户端不实现 CONNECT-UDP，始终使用明文端口
+	QUICPort int `json:"quic_port"`
+	// Ticket 目录服务为本次会话签发的票据，服务未配置票据密钥时为空
+	Ticket    []byte    `json:"ticket"`
+	SessionID uint64    `json:"session_id,string"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// directoryURL 目录服务地址，DIRECTORY_URL 未设置时使用默认值
+func directoryURL() string {
+	if v := os.Getenv("DIRECTORY_URL"); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return defaultDirectoryURL
+}
+
+// listServers 查询当前可连接的战斗服
+func listServers(base string) ([]serverRoute, error) {
+	var resp struct {
+		Servers []serverRoute `json:"servers"`
+	}
+	if err := getJSON(base+"/v1/servers", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Servers, nil
+}
+
+// resolveServer 查询战斗服的连接地址，每次查询得到一张新会话的票据
+func resolveServer(base, serverID string) (*serverRoute, error) {
+	var route serverRoute
+	if err := getJSON(base+"/v1/servers/"+url.PathEscape(serverID), &route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+// chooseServer 按菜单序号或服务器ID选择战斗服
+func chooseServer(servers []serverRoute, choice string) (string, bool) {
+	if i, err := strconv.Atoi(choice); err == nil && i >= 1 && i <= len(servers) {
+		return servers[i-1].ServerID, true
+	}
+	for _, s := range servers {
+		if s.ServerID == choice {
+			return s.ServerID, true
+		}
+	}
+	return "", false
+}
+
+// getJSON 请求目录服务。PLAYER_TOKEN 为登录服务签发的玩家凭证，优先使用；
+// DIRECTORY_TOKEN 是可信后端的共享令牌，只用于本地测试
+func getJSON(u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("无效的目录服务地址: %v", err)
+	}
+	token := os.Getenv("PLAYER_TOKEN")
+	if token == "" {
+		token = os.Getenv("DIRECTORY_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := directoryClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求目录服务失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("目录服务返回状态 %d: %s", resp.StatusCode, e.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("解析目录服务响应失败: %v", err)
+	}
+	return nil
+}
@@ -1,3 +1,7 @@
 module test-client
 
-go 1.21
\ No newline at end of file
+go 1.21
+
+require gameproto v0.0.0
+
+replace gameproto => ../gameproto
@@ -1,20 +1,19 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
-)
 
-// 游戏服务器映射表
-var serverPorts = map[string]int{
-	"1": 10000, // game-server-1
-	"2": 10001, // game-server-2
-	"3": 10002, // game-server-3
-}
+	"gameproto"
+)
 
 // UDPClient UDP客户端结构体
 type UDPClient struct {
@@ -22,14 +21,44 @@ type UDPClient struct {
 	ServerPort   int
 	TargetServer string
 	Conn         *net.UDPConn
+	SessionID    uint64 // 随机生成，服务器据此区分同一地址上的不同会话
+	Ticket       []byte // 目录服务签发的票据，优先于 TICKET/TICKET_KEYS
+	seq          uint32
+	channels     map[uint8]*gameproto.Channel // 可靠通道，按需创建
+	// MaxDatagramSize 接收数据报的最大长度；PathMTU 到服务器的路径MTU，超过限制的消息拆分为分片发送
+	MaxDatagramSize int
+	PathMTU         int
+	reassembly      *gameproto.Reassembler
+	truncated       uint64
+}
+
+// reliableChannels 需要可靠送达的消息类型及其通道，其余消息（如位置同步）保持不可靠发送
+var reliableChannels = map[gameproto.MessageType]uint8{
+	gameproto.TypeBattle: gameproto.ChannelBattle,
 }
 
+const (
+	// responseTimeout 等待响应的总时长，可靠请求在此期间按重传超时重发
+	responseTimeout = 5 * time.Second
+
+	// defaultMaxDatagramSize 与Envoy max_rx_datagram_size 的默认值一致
+	defaultMaxDatagramSize = 1500
+)
+
 // NewUDPClient 创建新的UDP客户端
 func NewUDPClient(host string, port int, targetServer string) *UDPClient {
+	var id [8]byte
+	rand.Read(id[:])
+
 	return &UDPClient{
-		ServerHost:   host,
-		ServerPort:   port,
-		TargetServer: targetServer,
+		ServerHost:      host,
+		ServerPort:      port,
+		TargetServer:    targetServer,
+		SessionID:       binary.BigEndian.Uint64(id[:]),
+		channels:        make(map[uint8]*gameproto.Channel),
+		MaxDatagramSize: defaultMaxDatagramSize,
+		PathMTU:         gameproto.DefaultMTU,
+		reassembly:      gameproto.NewReassembler(gameproto.MaxPayloadSize, responseTimeout),
 	}
 }
 
@@ -48,37 +77,174 @@ func (c *UDPClient) Connect() error {
 
 	c.Conn = conn
 	log.Printf("✅ 已连接到Envoy代理: %s", serverAddr)
-	log.Printf("🎯 目标游戏服务器: game-server-%s", c.TargetServer)
+	log.Printf("🎯 目标游戏服务器: %s", c.TargetServer)
 	return nil
 }
 
-// SendMessage 发送消息到服务器
+// messageFrame 将输入的命令转换为帧：首个单词映射为消息类型，其余部分作为负载；未知命令整体作为回显负载
+func messageFrame(message string) *gameproto.Frame {
+	command, args, _ := strings.Cut(message, " ")
+	switch strings.ToUpper(command) {
+	case "PING":
+		return &gameproto.Frame{Type: gameproto.TypePing}
+	case "BATTLE":
+		return &gameproto.Frame{Type: gameproto.TypeBattle, Payload: []byte(args)}
+	case "STATUS":
+		return &gameproto.Frame{Type: gameproto.TypeStatus}
+	default:
+		return &gameproto.Frame{Type: gameproto.TypeEcho, Payload: []byte(message)}
+	}
+}
+
+// SendMessage 发送消息到服务器。BATTLE 等关键消息经可靠通道发送，丢包时自动重传，服务器保证只处理一次
 func (c *UDPClient) SendMessage(message string) (string, error) {
 	if c.Conn == nil {
 		return "", fmt.Errorf("未连接到服务器")
 	}
 
-	// 在消息中添加目标服务器信息
-	enhancedMessage := fmt.Sprintf("[SERVER:%s] %s", c.TargetServer, message)
+	return c.exchange(messageFrame(message))
+}
+
+// exchange 发送一个请求帧并等待序列号相同的响应
+func (c *UDPClient) exchange(req *gameproto.Frame) (string, error) {
+	c.seq++
+	req.Seq = c.seq
+	req.SessionID = c.SessionID
 
-	// 发送消息
-	_, err := c.Conn.Write([]byte(enhancedMessage))
+	// 超过数据报上限的消息拆分为分片，可靠消息的每个分片分别经可靠通道发送
+	frames, err := gameproto.Fragment(req, min(c.MaxDatagramSize, gameproto.MaxDatagramForMTU(c.PathMTU)))
 	if err != nil {
-		return "", fmt.Errorf("发送消息失败: %v", err)
+		return "", fmt.Errorf("拆分消息失败: %v", err)
+	}
+	for _, f := range frames {
+		if id, ok := reliableChannels[req.Type]; ok {
+			if err := c.channel(id).Send(f, time.Now()); err != nil {
+				return "", fmt.Errorf("可靠通道不可用: %v", err)
+			}
+		}
+
+		// 发送消息
+		if err := c.writeFrame(f); err != nil {
+			return "", fmt.Errorf("发送消息失败: %v", err)
+		}
 	}
 
-	// 设置读取超时
-	c.Conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// 接收响应，跳过之前超时请求的迟到响应；多读1字节用于识别被截断的数据报
+	deadline := time.Now().Add(responseTimeout)
+	buffer := make([]byte, c.MaxDatagramSize+1)
+	for {
+		readDeadline := deadline
+		if next, ok := c.nextRetransmit(); ok && next.Before(readDeadline) {
+			readDeadline = next
+		}
+		c.Conn.SetReadDeadline(readDeadline)
 
-	// 接收响应
-	buffer := make([]byte, 1024)
-	n, _, err := c.Conn.ReadFromUDP(buffer)
-	if err != nil {
-		return "", fmt.Errorf("接收响应失败: %v", err)
+		n, _, err := c.Conn.ReadFromUDP(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && time.Now().Before(deadline) {
+				c.retransmit()
+				continue
+			}
+			return "", fmt.Errorf("接收响应失败: %v", err)
+		}
+		if n > c.MaxDatagramSize {
+			c.truncated++
+			log.Printf("⚠️ 丢弃超过 %d 字节的响应（累计 %d）", c.MaxDatagramSize, c.truncated)
+			continue
+		}
+
+		resp, err := gameproto.Decode(buffer[:n])
+		if err != nil {
+			return "", fmt.Errorf("解析响应失败: %v", err)
+		}
+
+		frames := []*gameproto.Frame{resp}
+		if resp.Reliable != nil {
+			frames = c.receiveReliable(resp)
+		}
+		for _, f := range frames {
+			if f.Type == gameproto.TypeFragment {
+				if f, err = c.reassembly.Add(f, time.Now()); err != nil || f == nil {
+					if err != nil {
+						log.Printf("⚠️ 丢弃无效分片: %v", err)
+					}
+					continue
+				}
+			}
+			if f.Type == gameproto.TypeShutdown {
+				log.Printf("📢 %s", f.Payload)
+				continue
+			}
+			if f.Seq != req.Seq {
+				log.Printf("⚠️ 忽略过期响应: seq=%d (期望 %d)", f.Seq, req.Seq)
+				continue
+			}
+			if f.Type == gameproto.TypeError {
+				return "", errors.New(string(f.Payload))
+			}
+			return fmt.Sprintf("[%s] %s", f.Type, f.Payload), nil
+		}
+	}
+}
+
+// channel 返回可靠通道，不存在时创建
+func (c *UDPClient) channel(id uint8) *gameproto.Channel {
+	ch, ok := c.channels[id]
+	if !ok {
+		ch = gameproto.NewChannel(id, gameproto.DefaultReliableOptions)
+		c.channels[id] = ch
 	}
+	return ch
+}
 
-	response := string(buffer[:n])
-	return response, nil
+// receiveReliable 处理服务器发来的可靠帧，返回按序交付的帧；收到数据帧后立即回复确认
+func (c *UDPClient) receiveReliable(f *gameproto.Frame) []*gameproto.Frame {
+	ch := c.channel(f.Reliable.Channel)
+	deliver, _ := ch.Receive(f)
+	if f.Type != gameproto.TypeAck && f.Reliable.Seq != 0 {
+		if err := c.writeFrame(ch.AckFrame(c.SessionID)); err != nil {
+			log.Printf("⚠️ 发送确认失败: %v", err)
+		}
+	}
+	return deliver
+}
+
+// nextRetransmit 所有可靠通道中最早的重传时间
+func (c *UDPClient) nextRetransmit() (time.Time, bool) {
+	var next time.Time
+	for _, ch := range c.channels {
+		if t, ok := ch.NextDue(); ok && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// retransmit 重发到期未确认的可靠帧
+func (c *UDPClient) retransmit() {
+	now := time.Now()
+	for _, ch := range c.channels {
+		resend, dropped := ch.Due(now)
+		for _, f := range dropped {
+			log.Printf("❌ 可靠消息 seq=%d 重试耗尽，已放弃", f.Seq)
+		}
+		for _, f := range resend {
+			log.Printf("🔁 重传可靠消息: %s seq=%d", f.Type, f.Seq)
+			if err := c.writeFrame(f); err != nil {
+				log.Printf("⚠️ 重传失败: %v", err)
+			}
+		}
+	}
+}
+
+func (c *UDPClient) writeFrame(f *gameproto.Frame) error {
+	data, err := gameproto.Encode(f)
+	if err != nil {
+		return fmt.Errorf("编码消息失败: %v", err)
+	}
+	_, err = c.Conn.Write(data)
+	return err
 }
 
 // TestConnection 测试连接
@@ -123,32 +289,63 @@ func (c *UDPClient) Close() {
 }
 
 func main() {
-	// 从环境变量获取配置
+	// SERVER_HOST 覆盖目录服务返回的地址，如在容器网络内直接访问 envoy-proxy
 	host := os.Getenv("SERVER_HOST")
-	if host == "" {
-		host = "localhost"
+
+	// 压测模式，不进入交互流程
+	if port, total, concurrency, message, ok := benchConfig(); ok {
+		runBenchmark(host, port, total, concurrency, message)
+		return
+	}
+
+	// 从目录服务获取可连接的游戏服务器
+	directory := directoryURL()
+	servers, err := listServers(directory)
+	if err != nil {
+		log.Fatalf("❌ 查询游戏服务器列表失败: %v", err)
+	}
+	if len(servers) == 0 {
+		log.Fatalf("❌ 目录服务 %s 中没有可连接的游戏服务器", directory)
 	}
 
 	// 显示服务器选择菜单
 	fmt.Println("🚀 Envoy UDP代理测试客户端")
 	fmt.Println("================================")
 	fmt.Println("请选择要连接的游戏服务器:")
-	fmt.Println("1. game-server-1 (端口: 10000)")
-	fmt.Println("2. game-server-2 (端口: 10001)")
-	fmt.Println("3. game-server-3 (端口: 10002)")
-	fmt.Print("请输入选择 (1-3): ")
+	for i, s := range servers {
+		fmt.Printf("%d. %s (端口: %d)\n", i+1, s.ServerID, s.Port)
+	}
+	fmt.Printf("请输入选择 (1-%d 或服务器ID): ", len(servers))
 
-	var serverChoice string
-	fmt.Scanln(&serverChoice)
+	var choice string
+	fmt.Scanln(&choice)
 
 	// 验证服务器选择
-	port, exists := serverPorts[serverChoice]
+	serverID, exists := chooseServer(servers, choice)
 	if !exists {
-		log.Fatalf("❌ 无效的服务器选择: %s", serverChoice)
+		log.Fatalf("❌ 无效的服务器选择: %s", choice)
+	}
+
+	// 查询连接地址与票据，列表可能已过时，以本次查询为准
+	route, err := resolveServer(directory, serverID)
+	if err != nil {
+		log.Fatalf("❌ 查询游戏服务器 %s 失败: %v", serverID, err)
+	}
+	if host == "" {
+		host = route.Host
 	}
 
 	// 创建UDP客户端
-	client := NewUDPClient(host, port, serverChoice)
+	client := NewUDPClient(host, route.Port, serverID)
+	client.Ticket = route.Ticket
+
+	// 数据报长度上限与路径MTU，需与控制平面、游戏服务器的配置一致
+	if n, err := strconv.Atoi(os.Getenv("MAX_DATAGRAM_SIZE")); err == nil && n >= gameproto.MaxDatagramForMTU(gameproto.MinMTU) {
+		client.MaxDatagramSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("PATH_MTU")); err == nil && n >= gameproto.MinMTU {
+		client.PathMTU = n
+	}
 
 	// 连接到服务器
 	if err := client.Connect(); err != nil {
@@ -156,9 +353,17 @@ func main() {
 	}
 	defer client.Close()
 
+	// 游戏服务器启用票据校验时，先出示票据建立会话
+	if err := client.Authenticate(serverID); err != nil {
+		log.Fatalf("❌ 认证失败: %v", err)
+	}
+
 	log.Printf("🚀 UDP客户端启动成功")
-	log.Printf("📡 Envoy代理地址: %s:%d", host, port)
-	log.Printf("🎯 目标游戏服务器: game-server-%s", serverChoice)
+	log.Printf("📡 Envoy代理地址: %s:%d", host, route.Port)
+	if route.QUICPort != 0 {
+		log.Printf("🔓 战斗服另有加密端口 %d（HTTP/3 CONNECT-UDP），测试客户端不支持，使用明文端口", route.QUICPort)
+	}
+	log.Printf("🎯 目标游戏服务器: %s", serverID)
 	log.Printf("💡 支持的命令: PING, BATTLE, STATUS, 或任意消息")
 	log.Printf("⏹️  输入 'quit' 或 'exit' 退出\n")
 
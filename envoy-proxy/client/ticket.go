@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gameproto"
+)
+
+const (
+	// ticketTTL 模拟匹配服务签发的票据有效期
+	ticketTTL = 5 * time.Minute
+	// helloAttempts HELLO 未收到 WELCOME 时的重试次数
+	helloAttempts = 3
+)
+
+// ticketFor 获取连接 serverID 所需的票据：TICKET 为匹配服务签发的base64票据；
+// 未设置时若配置了 TICKET_KEYS，则模拟匹配服务为本次会话签发。都未设置时返回 nil，不进行认证
+func ticketFor(serverID string, sessionID uint64) ([]byte, error) {
+	if v := os.Getenv("TICKET"); v != "" {
+		ticket, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("TICKET 不是有效的base64: %v", err)
+		}
+		return ticket, nil
+	}
+	if v := os.Getenv("TICKET_KEYS"); v != "" {
+		keys, err := gameproto.ParseTicketKeys(v)
+		if err != nil {
+			return nil, err
+		}
+		return keys.Issue(serverID, sessionID, ticketTTL, time.Now())
+	}
+	return nil, nil
+}
+
+// Authenticate 以 HELLO 帧出示票据建立会话，会话ID改为票据绑定的会话ID；没有票据时直接返回
+func (c *UDPClient) Authenticate(serverID string) error {
+	ticket := c.Ticket
+	if ticket == nil {
+		var err error
+		if ticket, err = ticketFor(serverID, c.SessionID); err != nil || ticket == nil {
+			return err
+		}
+	}
+	t, _, err := gameproto.DecodeTicket(ticket)
+	if err != nil {
+		return err
+	}
+	if t.ServerID != serverID {
+		log.Printf("⚠️ 票据签发给 %s，目标为 %s", t.ServerID, serverID)
+	}
+	c.SessionID = t.SessionID
+
+	for i := 1; ; i++ {
+		response, err := c.exchange(&gameproto.Frame{Type: gameproto.TypeHello, Payload: ticket})
+		if err == nil {
+			log.Printf("🔑 会话已认证: %s", response)
+			return nil
+		}
+		if i == helloAttempts {
+			return fmt.Errorf("服务器未接受票据: %v", err)
+		}
+		log.Printf("🔁 未收到 WELCOME，重发 HELLO (%d/%d)", i, helloAttempts)
+	}
+}
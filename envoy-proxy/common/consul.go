@@ -0,0 +1,70 @@
+// Package common 收纳控制平面与游戏服务器共用的基础设施代码：Consul客户端与错误归类、
+// 结构化日志、链路追踪初始化与注册链路上下文的传递。
+package common
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+var (
+	// ErrConsulAccessDenied ACL令牌无效或权限不足
+	ErrConsulAccessDenied = errors.New("Consul拒绝访问，请检查 CONSUL_HTTP_TOKEN 是否有效及其ACL权限")
+	// ErrConsulTLS 与Consul的TLS握手或证书校验失败
+	ErrConsulTLS = errors.New("与Consul的TLS握手失败，请检查 CONSUL_CACERT、CONSUL_CLIENT_CERT/CONSUL_CLIENT_KEY 与 CONSUL_TLS_SERVER_NAME")
+)
+
+// NewConsulClient 创建Consul客户端。addr 可带 http:// 或 https:// 前缀，https 时启用TLS；
+// ACL令牌与TLS参数沿用Consul CLI的环境变量：CONSUL_HTTP_TOKEN / CONSUL_HTTP_TOKEN_FILE、
+// CONSUL_CACERT、CONSUL_CLIENT_CERT / CONSUL_CLIENT_KEY、CONSUL_TLS_SERVER_NAME、CONSUL_HTTP_SSL
+func NewConsulClient(addr string) (*consulapi.Client, error) {
+	config := consulapi.DefaultConfig()
+	config.Address = addr
+
+	if (config.TLSConfig.CertFile == "") != (config.TLSConfig.KeyFile == "") {
+		return nil, fmt.Errorf("CONSUL_CLIENT_CERT 与 CONSUL_CLIENT_KEY 必须同时设置")
+	}
+	if config.TLSConfig.CertFile != "" && strings.HasPrefix(addr, "http://") {
+		return nil, fmt.Errorf("已配置Consul客户端证书，但地址 %s 使用 http，请改为 https://", addr)
+	}
+
+	client, err := consulapi.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("创建Consul客户端失败: %v", err)
+	}
+	return client, nil
+}
+
+// ConsulAccessError 将ACL拒绝（401/403）与证书校验失败转换为明确的配置错误，
+// 可用 IsConsulConfigError 判断；其他错误原样返回
+func ConsulAccessError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var statusErr consulapi.StatusError
+	if errors.As(err, &statusErr) && (statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden) {
+		return fmt.Errorf("%w: %v", ErrConsulAccessDenied, err)
+	}
+	// 部分Consul版本以500返回ACL错误，只能按内容识别
+	if msg := err.Error(); strings.Contains(msg, "Permission denied") || strings.Contains(msg, "ACL not found") {
+		return fmt.Errorf("%w: %v", ErrConsulAccessDenied, err)
+	}
+
+	var verifyErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	if errors.As(err, &verifyErr) || errors.As(err, &alertErr) || strings.Contains(err.Error(), "tls: ") {
+		return fmt.Errorf("%w: %v", ErrConsulTLS, err)
+	}
+	return err
+}
+
+// IsConsulConfigError 错误是否由令牌或TLS配置引起，重试无法恢复
+func IsConsulConfigError(err error) bool {
+	return errors.Is(err, ErrConsulAccessDenied) || errors.Is(err, ErrConsulTLS)
+}
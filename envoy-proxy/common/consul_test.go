@@ -0,0 +1,77 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestConsulAccessError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   error // nil 表示原样返回
+		config bool
+	}{
+		{"401", consulapi.StatusError{Code: http.StatusUnauthorized, Body: "token expired"}, ErrConsulAccessDenied, true},
+		{"403", consulapi.StatusError{Code: http.StatusForbidden, Body: "forbidden"}, ErrConsulAccessDenied, true},
+		{"包装后的403", fmt.Errorf("注册服务失败: %w", consulapi.StatusError{Code: http.StatusForbidden}), ErrConsulAccessDenied, true},
+		{"500 Permission denied", errors.New(`Unexpected response code: 500 (Permission denied: token with AccessorID '...' lacks permission)`), ErrConsulAccessDenied, true},
+		{"ACL not found", errors.New("Unexpected response code: 403 (ACL not found)"), ErrConsulAccessDenied, true},
+		{"证书校验失败", &url.Error{Op: "Put", URL: "https://consul:8501", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}, ErrConsulTLS, true},
+		{"TLS告警", &url.Error{Op: "Get", URL: "https://consul:8501", Err: tls.AlertError(42)}, ErrConsulTLS, true},
+		{"按内容识别TLS错误", errors.New("remote error: tls: bad certificate"), ErrConsulTLS, true},
+		{"404", consulapi.StatusError{Code: http.StatusNotFound}, nil, false},
+		{"500", consulapi.StatusError{Code: http.StatusInternalServerError, Body: "rpc error"}, nil, false},
+		{"连接被拒绝", errors.New("dial tcp 127.0.0.1:8500: connect: connection refused"), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ConsulAccessError(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("ConsulAccessError = %v, want 原样返回", got)
+				}
+			} else if !errors.Is(got, tt.want) {
+				t.Errorf("ConsulAccessError = %v, want %v", got, tt.want)
+			}
+			if IsConsulConfigError(got) != tt.config {
+				t.Errorf("IsConsulConfigError = %v, want %v", !tt.config, tt.config)
+			}
+		})
+	}
+	if ConsulAccessError(nil) != nil || IsConsulConfigError(nil) {
+		t.Error("nil 错误被转换")
+	}
+}
+
+func TestNewConsulClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"默认", "127.0.0.1:8500", nil, false},
+		{"https 不带客户端证书", "https://consul:8501", nil, false},
+		{"只配置客户端证书", "https://consul:8501", map[string]string{"CONSUL_CLIENT_CERT": "client.crt"}, true},
+		{"客户端证书配合 http 地址", "http://consul:8500", map[string]string{"CONSUL_CLIENT_CERT": "client.crt", "CONSUL_CLIENT_KEY": "client.key"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"CONSUL_CLIENT_CERT", "CONSUL_CLIENT_KEY", "CONSUL_CACERT", "CONSUL_HTTP_SSL"} {
+				t.Setenv(k, tt.env[k])
+			}
+			_, err := NewConsulClient(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewConsulClient(%q) err = %v, wantErr %v", tt.addr, err, tt.wantErr)
+			}
+		})
+	}
+}
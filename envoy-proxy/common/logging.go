@@ -0,0 +1,26 @@
+package common
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// NewLogger 创建结构化日志记录器。LOG_LEVEL 取 debug/info/warn/error (默认: info)，
+// LOG_FORMAT 取 json/text (默认: json，便于 Alloy/Loki 按字段解析)。
+// 设为默认记录器后，标准库 log 的输出也会以 info 级别经由同一处理器输出
+func NewLogger(component string) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	return slog.New(handler).With("component", component)
+}
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestNewLoggerLevel(t *testing.T) {
+	tests := []struct {
+		level string
+		want  slog.Level
+	}{
+		{"", slog.LevelInfo},
+		{"debug", slog.LevelDebug},
+		{"WARN", slog.LevelWarn},
+		{"error", slog.LevelError},
+		{"verbose", slog.LevelInfo}, // 无效值忽略
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			t.Setenv("LOG_LEVEL", tt.level)
+			l := NewLogger("test")
+			if !l.Enabled(context.Background(), tt.want) || l.Enabled(context.Background(), tt.want-1) {
+				t.Errorf("LOG_LEVEL=%q 的最低级别不是 %v", tt.level, tt.want)
+			}
+		})
+	}
+}
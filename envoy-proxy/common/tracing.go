@@ -0,0 +1,77 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MetaTraceParent 游戏服务器注册时写入服务元数据的 W3C traceparent，
+// 控制平面据此把配置下发过程关联到注册所在的链路
+const MetaTraceParent = "traceparent"
+
+// SetupTracing 按 OTEL_TRACES_EXPORTER 初始化链路追踪：otlp 经 gRPC 发送到
+// OTEL_EXPORTER_OTLP_ENDPOINT 指定的采集器，stdout 打印到标准输出，为空或 none 时不采集。
+// 返回的函数在退出前调用，确保缓冲中的 span 发送完毕
+func SetupTracing(ctx context.Context, serviceName string, attrs ...attribute.KeyValue) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch mode := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); mode {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracegrpc.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("未知的链路追踪导出方式: %s", mode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建链路追踪导出器失败: %v", err)
+	}
+
+	// OTEL_SERVICE_NAME、OTEL_RESOURCE_ATTRIBUTES 可覆盖默认的服务名与属性
+	res, err := sdkresource.New(ctx,
+		sdkresource.WithAttributes(append(attrs, attribute.String("service.name", serviceName))...),
+		sdkresource.WithFromEnv(),
+		sdkresource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("创建链路追踪资源失败: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// InjectTraceParent 把当前链路上下文写入服务元数据，供游戏服务器注册时使用
+func InjectTraceParent(ctx context.Context, meta map[string]string) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if tp := carrier.Get(MetaTraceParent); tp != "" {
+		meta[MetaTraceParent] = tp
+	}
+}
+
+// RegistrationContext 从服务元数据中提取注册时的链路上下文，没有时返回无效的 SpanContext
+func RegistrationContext(meta map[string]string) trace.SpanContext {
+	carrier := propagation.MapCarrier{MetaTraceParent: meta[MetaTraceParent]}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+	return trace.SpanContextFromContext(ctx)
+}
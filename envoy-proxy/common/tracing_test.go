@@ -0,0 +1,66 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTraceParentRoundTrip 游戏服务器注入的 traceparent 能被控制平面还原为同一链路
+func TestTraceParentRoundTrip(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		wantValid bool
+	}{
+		{"带链路上下文", trace.ContextWithSpanContext(context.Background(), sc), true},
+		{"没有链路上下文", context.Background(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := map[string]string{"protocol": "udp"}
+			InjectTraceParent(tt.ctx, meta)
+			if _, ok := meta[MetaTraceParent]; ok != tt.wantValid {
+				t.Fatalf("meta = %v", meta)
+			}
+			got := RegistrationContext(meta)
+			if got.IsValid() != tt.wantValid || (tt.wantValid && (got.TraceID() != traceID || got.SpanID() != spanID || !got.IsRemote())) {
+				t.Errorf("RegistrationContext = %+v", got)
+			}
+		})
+	}
+}
+
+func TestSetupTracing(t *testing.T) {
+	tests := []struct {
+		exporter string
+		wantErr  bool
+	}{
+		{"", false},
+		{"none", false},
+		{"STDOUT", false},
+		{"zipkin", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exporter, func(t *testing.T) {
+			t.Setenv("OTEL_TRACES_EXPORTER", tt.exporter)
+			shutdown, err := SetupTracing(context.Background(), "test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if err := shutdown(context.Background()); err != nil {
+					t.Errorf("shutdown: %v", err)
+				}
+			}
+		})
+	}
+}
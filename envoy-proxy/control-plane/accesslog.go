@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	fileaccesslog "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/file/v3"
+	grpcaccesslog "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
+	udpproxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/udp/udp_proxy/v3"
+	tracing "github.com/envoyproxy/go-control-plane/envoy/type/tracing/v3"
+)
+
+// AccessLogMode UDP会话访问日志输出方式
+type AccessLogMode string
+
+const (
+	// AccessLogNone 不生成访问日志
+	AccessLogNone AccessLogMode = "none"
+	// AccessLogFile 由Envoy以JSON格式写入文件（默认/dev/stdout），再由Alloy采集
+	AccessLogFile AccessLogMode = "file"
+	// AccessLogGRPC 通过gRPC ALS发送到控制平面，由控制平面转发到Loki
+	AccessLogGRPC AccessLogMode = "grpc"
+)
+
+// 访问日志类型，写入日志的 log_type 字段
+const (
+	accessLogTypeSession = "session" // 单个客户端会话结束（或定期刷新）时记录
+	accessLogTypeProxy   = "proxy"   // 监听器上udp_proxy过滤器销毁时记录汇总
+)
+
+// parseAccessLogMode 解析访问日志输出方式，空字符串视为none
+func parseAccessLogMode(s string) (AccessLogMode, error) {
+	switch mode := AccessLogMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return AccessLogNone, nil
+	case AccessLogNone, AccessLogFile, AccessLogGRPC:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("未知的访问日志输出方式: %s", s)
+	}
+}
+
+// AccessLogOptions UDP监听器访问日志配置
+type AccessLogOptions struct {
+	Mode AccessLogMode
+	// Path file 模式下的日志文件路径
+	Path string
+	// GRPCCluster grpc 模式下Envoy连接ALS使用的集群名，需在bootstrap中静态定义
+	GRPCCluster string
+	// FlushInterval 长会话的定期刷新间隔，为0时仅在会话结束时记录
+	FlushInterval time.Duration
+	// LokiPushURL grpc 模式下ALS转发的Loki推送地址，为空时打印到标准输出
+	LokiPushURL string
+}
+
+// udp_proxy 写入统计的动态元数据命名空间：会话日志为 udp.proxy.session，监听器汇总日志为 udp.proxy.proxy
+const (
+	sessionMetadataNamespace = "udp.proxy.session"
+	proxyMetadataNamespace   = "udp.proxy.proxy"
+)
+
+// sessionStatFields 会话日志中取自动态元数据的统计字段，file 与 grpc 两种模式输出相同的字段
+var sessionStatFields = []string{
+	"bytes_sent", "bytes_received",
+	"datagrams_sent", "datagrams_received",
+	"errors_sent", "errors_received",
+}
+
+// proxyStatFields 汇总日志中取自动态元数据的统计字段
+var proxyStatFields = append(append([]string(nil), sessionStatFields...),
+	"no_route", "session_total", "idle_timeout", "session_overflow")
+
+// sessionLogFormat 会话日志字段
+var sessionLogFormat = withMetadataFields(map[string]interface{}{
+	"start_time":        "%START_TIME%",
+	"duration_ms":       "%DURATION%",
+	"downstream_remote": "%DOWNSTREAM_REMOTE_ADDRESS%",
+	"downstream_local":  "%DOWNSTREAM_LOCAL_ADDRESS%",
+	"upstream_host":     "%UPSTREAM_HOST%",
+	"upstream_cluster":  "%UPSTREAM_CLUSTER%",
+}, sessionMetadataNamespace, sessionStatFields)
+
+// proxyLogFormat 监听器汇总日志字段
+var proxyLogFormat = withMetadataFields(map[string]interface{}{
+	"start_time":       "%START_TIME%",
+	"downstream_local": "%DOWNSTREAM_LOCAL_ADDRESS%",
+}, proxyMetadataNamespace, proxyStatFields)
+
+// withMetadataFields 为每个统计字段添加 %DYNAMIC_METADATA(namespace:field)% 格式
+func withMetadataFields(format map[string]interface{}, namespace string, fields []string) map[string]interface{} {
+	for _, field := range fields {
+		format[field] = fmt.Sprintf("%%DYNAMIC_METADATA(%s:%s)%%", namespace, field)
+	}
+	return format
+}
+
+// accessLogTags 每条日志附带的固定字段，用于在Loki中按战斗服检索
+func accessLogTags(logType, listenerName, serviceID string) map[string]string {
+	return map[string]string{
+		"log_type":   logType,
+		"listener":   listenerName,
+		"service_id": serviceID,
+	}
+}
+
+// applyAccessLogs 按配置为udp_proxy附加会话日志与汇总日志
+func (cp *ControlPlane) applyAccessLogs(cfg *udpproxy.UdpProxyConfig, listenerName, serviceID string) error {
+	opts := cp.opts.AccessLog
+	if opts.Mode == "" || opts.Mode == AccessLogNone {
+		return nil
+	}
+
+	sessionLog, err := buildAccessLog(opts, sessionLogFormat, accessLogTags(accessLogTypeSession, listenerName, serviceID))
+	if err != nil {
+		return err
+	}
+	proxyLog, err := buildAccessLog(opts, proxyLogFormat, accessLogTags(accessLogTypeProxy, listenerName, serviceID))
+	if err != nil {
+		return err
+	}
+
+	cfg.AccessLog = []*accesslog.AccessLog{sessionLog}
+	cfg.ProxyAccessLog = []*accesslog.AccessLog{proxyLog}
+	if opts.FlushInterval > 0 {
+		cfg.AccessLogOptions = &udpproxy.UdpProxyConfig_UdpAccessLogOptions{
+			AccessLogFlushInterval: durationpb.New(opts.FlushInterval),
+		}
+	}
+	return nil
+}
+
+// buildAccessLog 构建单个访问日志配置。file 模式输出JSON行，grpc 模式通过自定义标签携带固定字段
+func buildAccessLog(opts AccessLogOptions, format map[string]interface{}, tags map[string]string) (*accesslog.AccessLog, error) {
+	switch opts.Mode {
+	case AccessLogFile:
+		fields := make(map[string]interface{}, len(format)+len(tags))
+		for k, v := range format {
+			fields[k] = v
+		}
+		for k, v := range tags {
+			fields[k] = v
+		}
+		jsonFormat, err := structpb.NewStruct(fields)
+		if err != nil {
+			return nil, fmt.Errorf("构建访问日志JSON格式失败: %v", err)
+		}
+
+		path := opts.Path
+		if path == "" {
+			path = "/dev/stdout"
+		}
+		typed, err := anypb.New(&fileaccesslog.FileAccessLog{
+			Path: path,
+			AccessLogFormat: &fileaccesslog.FileAccessLog_LogFormat{
+				LogFormat: &core.SubstitutionFormatString{
+					Format: &core.SubstitutionFormatString_JsonFormat{JsonFormat: jsonFormat},
+				},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("创建文件访问日志失败: %v", err)
+		}
+		return &accesslog.AccessLog{
+			Name:       "envoy.access_loggers.file",
+			ConfigType: &accesslog.AccessLog_TypedConfig{TypedConfig: typed},
+		}, nil
+
+	case AccessLogGRPC:
+		customTags := make([]*tracing.CustomTag, 0, len(tags))
+		for k, v := range tags {
+			customTags = append(customTags, &tracing.CustomTag{
+				Tag:  k,
+				Type: &tracing.CustomTag_Literal_{Literal: &tracing.CustomTag_Literal{Value: v}},
+			})
+		}
+
+		cluster := opts.GRPCCluster
+		if cluster == "" {
+			cluster = "xds_control_plane"
+		}
+		typed, err := anypb.New(&grpcaccesslog.TcpGrpcAccessLogConfig{
+			CommonConfig: &grpcaccesslog.CommonGrpcAccessLogConfig{
+				LogName: tags["log_type"],
+				GrpcService: &core.GrpcService{
+					TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: cluster},
+					},
+				},
+				TransportApiVersion: core.ApiVersion_V3,
+				CustomTags:          customTags,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("创建gRPC访问日志失败: %v", err)
+		}
+		return &accesslog.AccessLog{
+			Name:       "envoy.access_loggers.tcp_grpc",
+			ConfigType: &accesslog.AccessLog_TypedConfig{TypedConfig: typed},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("未知的访问日志输出方式: %s", opts.Mode)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	accesslogdata "github.com/envoyproxy/go-control-plane/envoy/data/accesslog/v3"
+	accesslogservice "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+)
+
+const (
+	alsBatchSize     = 500
+	alsFlushInterval = 2 * time.Second
+	lokiPushTimeout  = 5 * time.Second
+)
+
+// LogEntry 一条待推送的访问日志
+type LogEntry struct {
+	Timestamp time.Time
+	Labels    map[string]string // Loki流标签，只放低基数字段
+	Line      string            // JSON格式的日志正文
+}
+
+// LogSink 访问日志下游，生产环境为Loki，测试时可替换为内存实现
+type LogSink interface {
+	Push(ctx context.Context, entries []LogEntry) error
+}
+
+// LokiSink 通过 /loki/api/v1/push 推送日志
+type LokiSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewLokiSink 创建Loki推送器，pushURL 形如 http://loki:3100/loki/api/v1/push
+func NewLokiSink(pushURL string) *LokiSink {
+	return &LokiSink{
+		url:    pushURL,
+		client: &http.Client{Timeout: lokiPushTimeout},
+	}
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+// Push 按标签集合分组后一次性推送
+func (s *LokiSink) Push(ctx context.Context, entries []LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	streams := make(map[string]*lokiStream)
+	var order []string
+	for _, e := range entries {
+		key := labelKey(e.Labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: e.Labels}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.Timestamp.UnixNano(), 10), e.Line})
+	}
+
+	req := lokiPushRequest{Streams: make([]lokiStream, 0, len(order))}
+	for _, key := range order {
+		req.Streams = append(req.Streams, *streams[key])
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("序列化Loki推送请求失败: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建Loki推送请求失败: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("推送日志到Loki失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Loki返回错误状态 %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// labelKey 生成标签集合的稳定键
+func labelKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// stdoutSink 未配置Loki时将访问日志原样打印到标准输出，便于本地调试
+type stdoutSink struct{}
+
+func (stdoutSink) Push(_ context.Context, entries []LogEntry) error {
+	for _, e := range entries {
+		fmt.Println(e.Line)
+	}
+	return nil
+}
+
+// AccessLogServer 接收Envoy gRPC访问日志(ALS)并批量转发到 LogSink
+type AccessLogServer struct {
+	accesslogservice.UnimplementedAccessLogServiceServer
+
+	sink LogSink
+
+	mu      sync.Mutex
+	pending []LogEntry
+	flushCh chan struct{}
+}
+
+// NewAccessLogServer 创建ALS服务
+func NewAccessLogServer(sink LogSink) *AccessLogServer {
+	if sink == nil {
+		sink = stdoutSink{}
+	}
+	return &AccessLogServer{
+		sink:    sink,
+		flushCh: make(chan struct{}, 1),
+	}
+}
+
+// Run 定期或在积压达到批量大小时推送日志，ctx 结束时做最后一次推送
+func (s *AccessLogServer) Run(ctx context.Context) {
+	ticker := time.NewTicker(alsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), lokiPushTimeout)
+			s.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			s.flush(ctx)
+		case <-s.flushCh:
+			s.flush(ctx)
+		}
+	}
+}
+
+// flush 推送当前积压的日志，失败时丢弃该批次以免无限堆积
+func (s *AccessLogServer) flush(ctx context.Context) {
+	s.mu.Lock()
+	entries := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+	if err := s.sink.Push(ctx, entries); err != nil {
+		slog.Warn("推送访问日志失败", "entries", len(entries), "error", err)
+	}
+}
+
+func (s *AccessLogServer) enqueue(entries []LogEntry) {
+	s.mu.Lock()
+	s.pending = append(s.pending, entries...)
+	full := len(s.pending) >= alsBatchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// StreamAccessLogs 实现 AccessLogService。只有首条消息携带 identifier
+func (s *AccessLogServer) StreamAccessLogs(stream accesslogservice.AccessLogService_StreamAccessLogsServer) error {
+	var nodeID string
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if id := msg.GetIdentifier(); id != nil {
+			nodeID = id.GetNode().GetId()
+		}
+
+		tcpLogs := msg.GetTcpLogs()
+		if tcpLogs == nil {
+			continue
+		}
+
+		entries := make([]LogEntry, 0, len(tcpLogs.GetLogEntry()))
+		for _, e := range tcpLogs.GetLogEntry() {
+			entry, err := toLogEntry(nodeID, e)
+			if err != nil {
+				slog.Warn("转换访问日志失败", "node_id", nodeID, "error", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		s.enqueue(entries)
+	}
+}
+
+// toLogEntry 将ALS条目转换为JSON日志行。log_type/listener/service_id 来自控制平面下发的自定义标签
+func toLogEntry(nodeID string, e *accesslogdata.TCPAccessLogEntry) (LogEntry, error) {
+	common := e.GetCommonProperties()
+	tags := common.GetCustomTags()
+
+	ts := time.Now()
+	if common.GetStartTime() != nil {
+		ts = common.GetStartTime().AsTime()
+	}
+
+	fields := map[string]interface{}{
+		"timestamp":         ts.Format(time.RFC3339Nano),
+		"start_time":        ts.Format(time.RFC3339Nano),
+		"node_id":           nodeID,
+		"log_type":          tags["log_type"],
+		"listener":          tags["listener"],
+		"service_id":        tags["service_id"],
+		"downstream_remote": formatAddress(common.GetDownstreamRemoteAddress()),
+		"downstream_local":  formatAddress(common.GetDownstreamLocalAddress()),
+		"upstream_host":     formatAddress(common.GetUpstreamRemoteAddress()),
+		"upstream_cluster":  common.GetUpstreamCluster(),
+		"intermediate":      common.GetIntermediateLogEntry(),
+		"duration_ms":       nil,
+	}
+	if common.GetDuration() != nil {
+		fields["duration_ms"] = common.GetDuration().AsDuration().Milliseconds()
+	}
+
+	// 与 file 模式相同，统计字段取自 udp_proxy 写入的动态元数据，缺失时为 null
+	namespace, statFields := sessionMetadataNamespace, sessionStatFields
+	if tags["log_type"] == accessLogTypeProxy {
+		namespace, statFields = proxyMetadataNamespace, proxyStatFields
+	}
+	stats := common.GetMetadata().GetFilterMetadata()[namespace].GetFields()
+	for _, field := range statFields {
+		fields[field] = nil
+		if v, ok := stats[field]; ok {
+			fields[field] = v.AsInterface()
+		}
+	}
+	// 元数据缺失时退回到连接属性中的字节数
+	if _, ok := stats["bytes_sent"]; !ok && e.GetConnectionProperties() != nil {
+		fields["bytes_sent"] = e.GetConnectionProperties().GetSentBytes()
+		fields["bytes_received"] = e.GetConnectionProperties().GetReceivedBytes()
+	}
+
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return LogEntry{}, err
+	}
+
+	return LogEntry{
+		Timestamp: ts,
+		Labels: map[string]string{
+			"job":        "envoy-udp",
+			"node_id":    nodeID,
+			"log_type":   tags["log_type"],
+			"service_id": tags["service_id"],
+		},
+		Line: string(line),
+	}, nil
+}
+
+// formatAddress 将Envoy地址格式化为 host:port
+func formatAddress(addr *core.Address) string {
+	sa := addr.GetSocketAddress()
+	if sa == nil {
+		return ""
+	}
+	return net.JoinHostPort(sa.GetAddress(), strconv.FormatUint(uint64(sa.GetPortValue()), 10))
+}
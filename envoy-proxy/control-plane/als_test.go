@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	accesslogdata "github.com/envoyproxy/go-control-plane/envoy/data/accesslog/v3"
+	accesslogservice "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeSink 记录每次推送的批次，err 非空时推送失败
+type fakeSink struct {
+	mu      sync.Mutex
+	batches [][]LogEntry
+	err     error
+	pushed  chan struct{}
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{pushed: make(chan struct{}, 16)}
+}
+
+func (s *fakeSink) Push(_ context.Context, entries []LogEntry) error {
+	s.mu.Lock()
+	s.batches = append(s.batches, entries)
+	s.mu.Unlock()
+	s.pushed <- struct{}{}
+	return s.err
+}
+
+func (s *fakeSink) snapshot() [][]LogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]LogEntry(nil), s.batches...)
+}
+
+// fakeALSStream 依次返回预置的消息，之后返回 io.EOF
+type fakeALSStream struct {
+	accesslogservice.AccessLogService_StreamAccessLogsServer
+	msgs []*accesslogservice.StreamAccessLogsMessage
+}
+
+func (s *fakeALSStream) Recv() (*accesslogservice.StreamAccessLogsMessage, error) {
+	if len(s.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return msg, nil
+}
+
+func tcpLogsMessage(nodeID string, n int, tags map[string]string) *accesslogservice.StreamAccessLogsMessage {
+	entries := make([]*accesslogdata.TCPAccessLogEntry, n)
+	for i := range entries {
+		entries[i] = &accesslogdata.TCPAccessLogEntry{
+			CommonProperties: &accesslogdata.AccessLogCommon{
+				StartTime:  timestamppb.New(time.Unix(1700000000, int64(i))),
+				CustomTags: tags,
+				DownstreamRemoteAddress: &core.Address{Address: &core.Address_SocketAddress{
+					SocketAddress: &core.SocketAddress{Address: "203.0.113.7", PortSpecifier: &core.SocketAddress_PortValue{PortValue: 40000}},
+				}},
+			},
+		}
+	}
+	msg := &accesslogservice.StreamAccessLogsMessage{
+		LogEntries: &accesslogservice.StreamAccessLogsMessage_TcpLogs{
+			TcpLogs: &accesslogservice.StreamAccessLogsMessage_TCPAccessLogEntries{LogEntry: entries},
+		},
+	}
+	if nodeID != "" {
+		msg.Identifier = &accesslogservice.StreamAccessLogsMessage_Identifier{Node: &core.Node{Id: nodeID}}
+	}
+	return msg
+}
+
+func waitPushed(t *testing.T, sink *fakeSink, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-sink.pushed:
+	case <-time.After(timeout):
+		t.Fatalf("%v 内没有推送日志", timeout)
+	}
+}
+
+func TestStreamAccessLogsIdentifierAndLabels(t *testing.T) {
+	s := NewAccessLogServer(newFakeSink())
+	tags := map[string]string{"log_type": accessLogTypeSession, "listener": "battle_1", "service_id": "battle-1"}
+	stream := &fakeALSStream{msgs: []*accesslogservice.StreamAccessLogsMessage{
+		tcpLogsMessage("proxy-1", 2, tags),
+		{},                          // 无日志的消息被跳过
+		tcpLogsMessage("", 1, tags), // 后续消息不带 identifier，沿用首条消息的节点
+	}}
+
+	if err := s.StreamAccessLogs(stream); err != nil {
+		t.Fatalf("StreamAccessLogs: %v", err)
+	}
+	if len(s.pending) != 3 {
+		t.Fatalf("pending = %d, want 3", len(s.pending))
+	}
+	for i, e := range s.pending {
+		if e.Labels["node_id"] != "proxy-1" || e.Labels["service_id"] != "battle-1" || e.Labels["log_type"] != accessLogTypeSession {
+			t.Errorf("entry %d labels = %v", i, e.Labels)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(e.Line), &fields); err != nil {
+			t.Fatalf("entry %d line 不是JSON: %v", i, err)
+		}
+		if fields["downstream_remote"] != "203.0.113.7:40000" || fields["listener"] != "battle_1" {
+			t.Errorf("entry %d fields = %v", i, fields)
+		}
+	}
+}
+
+func TestAccessLogServerFlushesFullBatch(t *testing.T) {
+	sink := newFakeSink()
+	s := NewAccessLogServer(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	stream := &fakeALSStream{msgs: []*accesslogservice.StreamAccessLogsMessage{tcpLogsMessage("proxy-1", alsBatchSize, nil)}}
+	if err := s.StreamAccessLogs(stream); err != nil {
+		t.Fatalf("StreamAccessLogs: %v", err)
+	}
+
+	// 积压达到批量大小时立即推送，不等待定时器
+	waitPushed(t, sink, alsFlushInterval/2)
+	batches := sink.snapshot()
+	if len(batches) != 1 || len(batches[0]) != alsBatchSize {
+		t.Fatalf("batches = %d, want 1 batch of %d", len(batches), alsBatchSize)
+	}
+}
+
+func TestAccessLogServerFlushesOnShutdown(t *testing.T) {
+	sink := newFakeSink()
+	s := NewAccessLogServer(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	s.enqueue([]LogEntry{{Line: "a"}, {Line: "b"}})
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run 在 ctx 结束后没有返回")
+	}
+
+	batches := sink.snapshot()
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("batches = %v, want 1 batch of 2", batches)
+	}
+}
+
+func TestAccessLogServerDropsFailedBatch(t *testing.T) {
+	sink := newFakeSink()
+	sink.err = errors.New("loki unavailable")
+	s := NewAccessLogServer(sink)
+
+	s.enqueue([]LogEntry{{Line: "a"}})
+	s.flush(context.Background())
+	s.flush(context.Background())
+
+	if batches := sink.snapshot(); len(batches) != 1 {
+		t.Fatalf("推送失败的批次被重复推送: %d 次", len(batches))
+	}
+	if len(s.pending) != 0 {
+		t.Fatalf("pending = %d, want 0", len(s.pending))
+	}
+}
+
+func TestLokiSinkPushGroupsStreams(t *testing.T) {
+	var got lokiPushRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("method = %s, content-type = %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("解析推送请求失败: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	a := map[string]string{"job": "envoy-udp", "service_id": "battle-1"}
+	b := map[string]string{"job": "envoy-udp", "service_id": "battle-2"}
+	// 键相同、map 不同的标签集合归入同一个流
+	a2 := map[string]string{"service_id": "battle-1", "job": "envoy-udp"}
+	entries := []LogEntry{
+		{Timestamp: time.Unix(0, 1), Labels: a, Line: "a1"},
+		{Timestamp: time.Unix(0, 2), Labels: b, Line: "b1"},
+		{Timestamp: time.Unix(0, 3), Labels: a2, Line: "a2"},
+	}
+
+	if err := NewLokiSink(srv.URL).Push(context.Background(), entries); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	want := []lokiStream{
+		{Stream: a, Values: [][2]string{{"1", "a1"}, {"3", "a2"}}},
+		{Stream: b, Values: [][2]string{{"2", "b1"}}},
+	}
+	if len(got.Streams) != len(want) {
+		t.Fatalf("streams = %+v, want %+v", got.Streams, want)
+	}
+	for i := range want {
+		if labelKey(got.Streams[i].Stream) != labelKey(want[i].Stream) {
+			t.Errorf("stream %d labels = %v, want %v", i, got.Streams[i].Stream, want[i].Stream)
+		}
+		if len(got.Streams[i].Values) != len(want[i].Values) {
+			t.Fatalf("stream %d values = %v, want %v", i, got.Streams[i].Values, want[i].Values)
+		}
+		for j := range want[i].Values {
+			if got.Streams[i].Values[j] != want[i].Values[j] {
+				t.Errorf("stream %d value %d = %v, want %v", i, j, got.Streams[i].Values[j], want[i].Values[j])
+			}
+		}
+	}
+}
+
+func TestLokiSinkPushErrors(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "entry out of order", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	sink := NewLokiSink(srv.URL)
+
+	if err := sink.Push(context.Background(), nil); err != nil || requests != 0 {
+		t.Fatalf("空批次: err = %v, requests = %d", err, requests)
+	}
+
+	err := sink.Push(context.Background(), []LogEntry{{Labels: map[string]string{"job": "envoy-udp"}, Line: "x"}})
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "entry out of order") {
+		t.Fatalf("err = %v, want 包含状态码与响应内容", err)
+	}
+}
+
+func metadataStruct(t *testing.T, fields map[string]interface{}) *structpb.Struct {
+	t.Helper()
+	s, err := structpb.NewStruct(fields)
+	if err != nil {
+		t.Fatalf("NewStruct: %v", err)
+	}
+	return s
+}
+
+// TestToLogEntryFields grpc 模式与 file 模式输出相同的字段，统计值取自 udp_proxy 的动态元数据
+func TestToLogEntryFields(t *testing.T) {
+	sessionStats := map[string]interface{}{
+		"bytes_sent": 1200, "bytes_received": 800,
+		"datagrams_sent": 12, "datagrams_received": 10,
+		"errors_sent": 1, "errors_received": 2,
+	}
+	proxyStats := map[string]interface{}{
+		"bytes_sent": 5000, "bytes_received": 4000,
+		"datagrams_sent": 50, "datagrams_received": 40,
+		"errors_sent": 0, "errors_received": 3,
+		"no_route": 4, "session_total": 7, "idle_timeout": 5, "session_overflow": 6,
+	}
+
+	tests := []struct {
+		name       string
+		logType    string
+		metadata   map[string]*structpb.Struct
+		conn       *accesslogdata.ConnectionProperties
+		fileFormat map[string]interface{}
+		want       map[string]interface{}
+	}{
+		{
+			name:       "会话日志",
+			logType:    accessLogTypeSession,
+			metadata:   map[string]*structpb.Struct{sessionMetadataNamespace: metadataStruct(t, sessionStats)},
+			conn:       &accesslogdata.ConnectionProperties{SentBytes: 1, ReceivedBytes: 1},
+			fileFormat: sessionLogFormat,
+			want:       sessionStats,
+		},
+		{
+			name:    "汇总日志",
+			logType: accessLogTypeProxy,
+			metadata: map[string]*structpb.Struct{
+				sessionMetadataNamespace: metadataStruct(t, sessionStats),
+				proxyMetadataNamespace:   metadataStruct(t, proxyStats),
+			},
+			fileFormat: proxyLogFormat,
+			want:       proxyStats,
+		},
+		{
+			name:       "缺少元数据",
+			logType:    accessLogTypeSession,
+			conn:       &accesslogdata.ConnectionProperties{SentBytes: 30, ReceivedBytes: 20},
+			fileFormat: sessionLogFormat,
+			want: map[string]interface{}{
+				"bytes_sent": 30, "bytes_received": 20,
+				"datagrams_sent": nil, "datagrams_received": nil,
+				"errors_sent": nil, "errors_received": nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := accessLogTags(tt.logType, "battle_1", "battle-1")
+			entry, err := toLogEntry("proxy-1", &accesslogdata.TCPAccessLogEntry{
+				CommonProperties: &accesslogdata.AccessLogCommon{
+					StartTime:  timestamppb.New(time.Unix(1700000000, 0)),
+					CustomTags: tags,
+					Metadata:   &core.Metadata{FilterMetadata: tt.metadata},
+				},
+				ConnectionProperties: tt.conn,
+			})
+			if err != nil {
+				t.Fatalf("toLogEntry: %v", err)
+			}
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(entry.Line), &fields); err != nil {
+				t.Fatalf("line 不是JSON: %v", err)
+			}
+
+			for key := range tt.fileFormat {
+				if _, ok := fields[key]; !ok {
+					t.Errorf("缺少 file 模式的字段 %s", key)
+				}
+			}
+			for key := range tags {
+				if _, ok := fields[key]; !ok {
+					t.Errorf("缺少标签字段 %s", key)
+				}
+			}
+			for key, want := range tt.want {
+				if want != nil {
+					want = float64(want.(int))
+				}
+				if fields[key] != want {
+					t.Errorf("%s = %v, want %v", key, fields[key], want)
+				}
+			}
+		})
+	}
+}
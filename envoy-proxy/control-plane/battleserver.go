@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Consul 元数据键
+const (
+	metaExternalPort = "envoy_external_port"
+	metaProtocol     = "protocol"
+	// metaStandbyFor 热备实例声明其保护的主实例ServiceID，需与主实例使用相同的 envoy_external_port
+	metaStandbyFor = "standby_for"
+)
+
+// upstreamHost 集群中的一个上游端点
+type upstreamHost struct {
+	ServiceID string
+	Address   string
+	Port      int
+	// Draining 实例处于Consul维护模式（游戏服务器正在优雅关闭），端点保留但不再接收新会话
+	Draining bool
+}
+
+// battleServer 一个对外端口对应的战斗服，包含主实例与按顺序排列的热备实例
+type battleServer struct {
+	// ServiceID 主实例的ServiceID，主实例不可用时仍沿用，保证集群与监听器名称不变
+	ServiceID    string
+	ExternalPort int
+	Primary      upstreamHost
+	Standbys     []upstreamHost
+	// Meta 生效实例（主实例或接管的热备）的Consul元数据
+	Meta map[string]string
+	// PromotedFrom 主实例缺失时接管的热备ServiceID
+	PromotedFrom string
+}
+
+// parseUDPService 校验Consul服务是否为可代理的UDP战斗服，返回其外部端口
+func parseUDPService(service *consulapi.ServiceEntry) (int, bool) {
+	// 从元数据中获取外部端口
+	externalPortStr, ok := service.Service.Meta[metaExternalPort]
+	if !ok {
+		slog.Warn("服务未指定envoy_external_port元数据，跳过", "server_id", service.Service.ID)
+		return 0, false
+	}
+
+	externalPort, err := strconv.Atoi(externalPortStr)
+	if err != nil {
+		slog.Warn("服务的外部端口格式错误，跳过", "server_id", service.Service.ID, "external_port", externalPortStr)
+		return 0, false
+	}
+
+	// 检查协议是否为UDP
+	protocol, ok := service.Service.Meta[metaProtocol]
+	if !ok || strings.ToLower(protocol) != "udp" {
+		slog.Warn("服务协议非UDP，跳过", "server_id", service.Service.ID, "protocol", protocol)
+		return 0, false
+	}
+
+	return externalPort, true
+}
+
+func hostOf(service *consulapi.ServiceEntry) upstreamHost {
+	return upstreamHost{
+		ServiceID: service.Service.ID,
+		Address:   service.Service.Address,
+		Port:      service.Service.Port,
+		Draining:  inMaintenance(service.Checks),
+	}
+}
+
+// inMaintenance 实例或所在节点是否处于维护模式
+func inMaintenance(checks consulapi.HealthChecks) bool {
+	for _, check := range checks {
+		if isMaintenanceCheck(check) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMaintenanceCheck(check *consulapi.HealthCheck) bool {
+	return check.CheckID == consulapi.NodeMaint || strings.HasPrefix(check.CheckID, consulapi.ServiceMaintPrefix)
+}
+
+// servingServices 挑出需要下发给Envoy的实例：所有检查均通过的实例，以及除维护模式外检查均通过的排空实例。
+// 游戏服务器关闭时先进入维护模式再等待会话结束，若像其他不健康实例一样从快照中移除，
+// Envoy会随集群与监听器一起断开正在排空的会话
+func servingServices(entries []*consulapi.ServiceEntry) []*consulapi.ServiceEntry {
+	var services []*consulapi.ServiceEntry
+	for _, entry := range entries {
+		passing := true
+		for _, check := range entry.Checks {
+			if !isMaintenanceCheck(check) && check.Status != consulapi.HealthPassing {
+				passing = false
+				break
+			}
+		}
+		if passing {
+			services = append(services, entry)
+		}
+	}
+	return services
+}
+
+// groupBattleServers 将健康或排空中的Consul实例按主/热备分组。
+// 带 standby_for 的实例不单独生成监听器，而是作为主实例集群中的低优先级端点；
+// 主实例不健康（被 servingServices 过滤掉）时由ServiceID最小的热备接管；排空中的主实例仍是主实例，其余热备保持低优先级。
+func groupBattleServers(services []*consulapi.ServiceEntry) []battleServer {
+	var primaries []*consulapi.ServiceEntry
+	standbys := make(map[string][]*consulapi.ServiceEntry)
+	externalPorts := make(map[string]int)
+
+	for _, service := range services {
+		externalPort, ok := parseUDPService(service)
+		if !ok {
+			continue
+		}
+		externalPorts[service.Service.ID] = externalPort
+
+		if primaryID := service.Service.Meta[metaStandbyFor]; primaryID != "" {
+			standbys[primaryID] = append(standbys[primaryID], service)
+			continue
+		}
+		primaries = append(primaries, service)
+	}
+
+	for _, list := range standbys {
+		sort.Slice(list, func(i, j int) bool { return list[i].Service.ID < list[j].Service.ID })
+	}
+
+	var servers []battleServer
+	for _, primary := range primaries {
+		id := primary.Service.ID
+		server := battleServer{
+			ServiceID:    id,
+			ExternalPort: externalPorts[id],
+			Primary:      hostOf(primary),
+			Meta:         primary.Service.Meta,
+		}
+		for _, standby := range standbys[id] {
+			if externalPorts[standby.Service.ID] != server.ExternalPort {
+				slog.Warn("热备的外部端口与主实例不一致，以主实例为准", "server_id", id, "external_port", server.ExternalPort,
+					"standby_id", standby.Service.ID, "standby_external_port", externalPorts[standby.Service.ID])
+			}
+			server.Standbys = append(server.Standbys, hostOf(standby))
+		}
+		delete(standbys, id)
+		servers = append(servers, server)
+	}
+
+	// 剩下的热备对应的主实例不在健康列表中，由第一个热备接管
+	orphanIDs := make([]string, 0, len(standbys))
+	for primaryID := range standbys {
+		orphanIDs = append(orphanIDs, primaryID)
+	}
+	sort.Strings(orphanIDs)
+
+	for _, primaryID := range orphanIDs {
+		list := standbys[primaryID]
+		promoted := list[0]
+		server := battleServer{
+			ServiceID:    primaryID,
+			ExternalPort: externalPorts[promoted.Service.ID],
+			Primary:      hostOf(promoted),
+			Meta:         promoted.Service.Meta,
+			PromotedFrom: promoted.Service.ID,
+		}
+		for _, standby := range list[1:] {
+			server.Standbys = append(server.Standbys, hostOf(standby))
+		}
+		slog.Info("主实例不可用，由热备接管外部端口", "server_id", primaryID, "external_port", server.ExternalPort, "standby_id", promoted.Service.ID)
+		servers = append(servers, server)
+	}
+
+	return servers
+}
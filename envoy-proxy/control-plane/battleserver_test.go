@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// standbyOf 声明为 primaryID 热备的实例
+func standbyOf(id, externalPort, primaryID string) *consulapi.ServiceEntry {
+	return battleEntry(id, "10.0.1.1", 9000, externalPort, map[string]string{metaStandbyFor: primaryID})
+}
+
+// describeServers 把分组结果写成 "ServiceID:外部端口=主端点[<-接管的热备]+热备,..." 便于比较
+func describeServers(servers []battleServer) []string {
+	var out []string
+	for _, s := range servers {
+		d := fmt.Sprintf("%s:%d=%s", s.ServiceID, s.ExternalPort, s.Primary.ServiceID)
+		if s.PromotedFrom != "" {
+			d += "<-" + s.PromotedFrom
+		}
+		for _, h := range s.Standbys {
+			d += "+" + h.ServiceID
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func TestGroupBattleServers(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []*consulapi.ServiceEntry
+		want     []string
+	}{
+		{
+			name: "没有热备",
+			services: []*consulapi.ServiceEntry{
+				battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil),
+				battleEntry("battle-2", "10.0.0.2", 9000, "10001", nil),
+			},
+			want: []string{"battle-1:10000=battle-1", "battle-2:10001=battle-2"},
+		},
+		{
+			name: "按 standby_for 归入主实例，不单独生成战斗服",
+			services: []*consulapi.ServiceEntry{
+				standbyOf("battle-1-standby", "10000", "battle-1"),
+				battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil),
+				battleEntry("battle-2", "10.0.0.2", 9000, "10001", nil),
+			},
+			want: []string{"battle-1:10000=battle-1+battle-1-standby", "battle-2:10001=battle-2"},
+		},
+		{
+			name: "多个热备按ServiceID排序",
+			services: []*consulapi.ServiceEntry{
+				standbyOf("battle-1-c", "10000", "battle-1"),
+				standbyOf("battle-1-a", "10000", "battle-1"),
+				battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil),
+				standbyOf("battle-1-b", "10000", "battle-1"),
+			},
+			want: []string{"battle-1:10000=battle-1+battle-1-a+battle-1-b+battle-1-c"},
+		},
+		{
+			name: "主实例缺失时ServiceID最小的热备接管，沿用主实例ServiceID",
+			services: []*consulapi.ServiceEntry{
+				standbyOf("battle-1-c", "10000", "battle-1"),
+				standbyOf("battle-1-a", "10000", "battle-1"),
+				standbyOf("battle-1-b", "10000", "battle-1"),
+			},
+			want: []string{"battle-1:10000=battle-1-a<-battle-1-a+battle-1-b+battle-1-c"},
+		},
+		{
+			name: "从未注册过的主实例的热备同样接管",
+			services: []*consulapi.ServiceEntry{
+				battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil),
+				standbyOf("battle-9-standby", "10009", "battle-9"),
+			},
+			want: []string{"battle-1:10000=battle-1", "battle-9:10009=battle-9-standby<-battle-9-standby"},
+		},
+		{
+			name: "多个孤立热备组按主实例ServiceID排序",
+			services: []*consulapi.ServiceEntry{
+				standbyOf("battle-3-standby", "10002", "battle-3"),
+				standbyOf("battle-2-standby", "10001", "battle-2"),
+			},
+			want: []string{"battle-2:10001=battle-2-standby<-battle-2-standby", "battle-3:10002=battle-3-standby<-battle-3-standby"},
+		},
+		{
+			name: "热备外部端口与主实例不一致时以主实例为准",
+			services: []*consulapi.ServiceEntry{
+				battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil),
+				standbyOf("battle-1-standby", "10005", "battle-1"),
+			},
+			want: []string{"battle-1:10000=battle-1+battle-1-standby"},
+		},
+		{
+			name: "跳过非UDP与缺少外部端口的实例",
+			services: []*consulapi.ServiceEntry{
+				battleEntry("battle-1", "10.0.0.1", 9000, "10000", map[string]string{metaProtocol: "tcp"}),
+				battleEntry("battle-2", "10.0.0.2", 9000, "abc", nil),
+				standbyOf("battle-3-standby", "10002", "battle-3"),
+			},
+			want: []string{"battle-3:10002=battle-3-standby<-battle-3-standby"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeServers(groupBattleServers(tt.services))
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("servers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGroupBattleServersPromotedMeta 接管的热备使用自身的元数据与地址，排空状态随实例保留
+func TestGroupBattleServersPromotedMeta(t *testing.T) {
+	standby := withChecks(standbyOf("battle-1-a", "10000", "battle-1"),
+		healthCheck(consulapi.ServiceMaintPrefix+"battle-1-a", consulapi.HealthCritical))
+	standby.Service.Meta["region"] = "cn-east"
+	servers := groupBattleServers([]*consulapi.ServiceEntry{standby})
+	if len(servers) != 1 {
+		t.Fatalf("servers = %+v", servers)
+	}
+	s := servers[0]
+	if s.Meta["region"] != "cn-east" || s.Primary.Address != "10.0.1.1" || !s.Primary.Draining {
+		t.Errorf("server = %+v", s)
+	}
+}
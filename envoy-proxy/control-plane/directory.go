@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gameproto"
+)
+
+const defaultDirectoryTicketTTL = time.Minute
+
+// DirectoryOptions 目录服务配置
+type DirectoryOptions struct {
+	// PublicHost 返回给玩家的Envoy地址，为空时使用请求的Host，即玩家经同一地址访问目录服务与Envoy
+	PublicHost string
+	// TicketKeys 签发票据的密钥，需与游戏服务器的 TICKET_KEYS 一致；为空时只返回地址
+	TicketKeys *gameproto.TicketKeys
+	// TicketTTL 票据有效期，玩家需在此期间发出 HELLO
+	TicketTTL time.Duration
+	// Token 可信后端（如匹配服务）使用的共享令牌，请求携带 "Authorization: Bearer <Token>" 即可通过认证。
+	// 该令牌不区分玩家，不能下发给客户端
+	Token string
+	// PlayerKeys 校验玩家凭证（gameproto.TicketKeys.IssuePlayerToken）的密钥，由登录服务签发凭证。
+	// 配置后玩家可以携带自己的凭证直接查询，目录服务按玩家认证每个请求。
+	// 签发票据时 Token 与 PlayerKeys 至少配置一个，否则任何人都能为任意战斗服领取有效票据
+	PlayerKeys *gameproto.TicketKeys
+}
+
+// directoryRoute 路由表中的一个战斗服，与下发给Envoy的监听器一致
+type directoryRoute struct {
+	ServerID string `json:"server_id"`
+	Port     int    `json:"port"`
+	// QUICPort 开启边缘加密时对应的QUIC端口
+	QUICPort int `json:"quic_port,omitempty"`
+}
+
+// directoryEntry 查询单个战斗服的响应
+type directoryEntry struct {
+	directoryRoute
+	Host string `json:"host"`
+	// SessionID 票据绑定的会话ID，以字符串输出避免超出JSON数值精度
+	SessionID uint64    `json:"session_id,string,omitempty"`
+	Ticket    []byte    `json:"ticket,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Directory 按最近一次构建的快照回答玩家"某个战斗服从哪里连接"，并为每次查询签发新会话的票据
+type Directory struct {
+	opts DirectoryOptions
+
+	mu     sync.RWMutex
+	routes map[string]directoryRoute
+
+	found        atomic.Uint64
+	notFound     atomic.Uint64
+	issued       atomic.Uint64
+	unauthorized atomic.Uint64
+	players      atomic.Uint64 // 以玩家凭证认证通过的请求数
+}
+
+// playerIDKey 请求上下文中以玩家凭证认证的玩家ID，可信后端的请求没有该值
+type playerIDKey struct{}
+
+// NewDirectory 创建目录服务
+func NewDirectory(opts DirectoryOptions) *Directory {
+	if opts.TicketTTL <= 0 {
+		opts.TicketTTL = defaultDirectoryTicketTTL
+	}
+	return &Directory{
+		opts:   opts,
+		routes: make(map[string]directoryRoute),
+	}
+}
+
+// SetRoutes 用已下发给Envoy的快照中的战斗服替换路由表
+func (d *Directory) SetRoutes(routes []directoryRoute) {
+	m := make(map[string]directoryRoute, len(routes))
+	for _, r := range routes {
+		m[r.ServerID] = r
+	}
+	d.mu.Lock()
+	d.routes = m
+	d.mu.Unlock()
+}
+
+func (d *Directory) route(serverID string) (directoryRoute, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	r, ok := d.routes[serverID]
+	return r, ok
+}
+
+// Handler 目录服务的HTTP接口，配置了 Token 或 PlayerKeys 时均需认证：
+//
+//	GET /v1/servers        当前可连接的战斗服列表
+//	GET /v1/servers/{id}   战斗服的地址、端口与新签发的票据
+func (d *Directory) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/servers", d.handleList)
+	mux.HandleFunc("GET /v1/servers/{id}", d.handleLookup)
+	if d.opts.Token == "" && d.opts.PlayerKeys == nil {
+		return mux
+	}
+	return d.authorize(mux)
+}
+
+// authorize 校验 Bearer 令牌：可信后端的共享令牌按常量时间比较避免逐字节猜测，
+// 其余按玩家凭证校验签名与有效期，通过后把玩家ID放入请求上下文
+func (d *Directory) authorize(next http.Handler) http.Handler {
+	want := []byte(d.opts.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		switch {
+		case !ok:
+			d.reject(w, r, errors.New("缺少 Bearer 令牌"))
+		case len(want) > 0 && subtle.ConstantTimeCompare([]byte(token), want) == 1:
+			next.ServeHTTP(w, r)
+		case d.opts.PlayerKeys == nil:
+			d.reject(w, r, errors.New("令牌错误"))
+		default:
+			player, err := d.opts.PlayerKeys.VerifyPlayerToken(token, time.Now())
+			if err != nil {
+				d.reject(w, r, err)
+				return
+			}
+			d.players.Add(1)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), playerIDKey{}, player.PlayerID)))
+		}
+	})
+}
+
+// reject 回复 401，按2的幂次记录日志，避免扫描刷屏
+func (d *Directory) reject(w http.ResponseWriter, r *http.Request, err error) {
+	if n := d.unauthorized.Add(1); n&(n-1) == 0 {
+		slog.Warn("拒绝未认证的目录请求", "remote_addr", r.RemoteAddr, "path", r.URL.Path, "rejected", n, "error", err)
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="directory"`)
+	writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "需要有效的目录服务令牌或玩家凭证"})
+}
+
+func (d *Directory) handleList(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	routes := make([]directoryRoute, 0, len(d.routes))
+	for _, route := range d.routes {
+		routes = append(routes, route)
+	}
+	d.mu.RUnlock()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ServerID < routes[j].ServerID })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"servers": routes})
+}
+
+func (d *Directory) handleLookup(w http.ResponseWriter, r *http.Request) {
+	serverID := r.PathValue("id")
+	route, ok := d.route(serverID)
+	if !ok {
+		d.notFound.Add(1)
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("战斗服 %s 不存在或当前不可用", serverID)})
+		return
+	}
+	d.found.Add(1)
+
+	entry := directoryEntry{directoryRoute: route, Host: d.publicHost(r)}
+	if d.opts.TicketKeys != nil {
+		now := time.Now()
+		entry.SessionID = newSessionID()
+		ticket, err := d.opts.TicketKeys.Issue(serverID, entry.SessionID, d.opts.TicketTTL, now)
+		if err != nil {
+			slog.Error("签发票据失败", "server_id", serverID, "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "签发票据失败"})
+			return
+		}
+		entry.Ticket = ticket
+		entry.ExpiresAt = now.Add(d.opts.TicketTTL)
+		d.issued.Add(1)
+	}
+
+	playerID, _ := r.Context().Value(playerIDKey{}).(string)
+	slog.Debug("目录查询", "server_id", serverID, "remote_addr", r.RemoteAddr, "player_id", playerID, "external_port", route.Port)
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// publicHost 返回给玩家的Envoy地址
+func (d *Directory) publicHost(r *http.Request) string {
+	if d.opts.PublicHost != "" {
+		return d.opts.PublicHost
+	}
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
+
+// newSessionID 随机生成会话ID，票据与会话ID绑定，每次查询都是一个新会话
+func newSessionID() uint64 {
+	var id [8]byte
+	rand.Read(id[:])
+	return binary.BigEndian.Uint64(id[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("写入目录响应失败", "error", err)
+	}
+}
+
+func (d *Directory) writeMetrics(w io.Writer) {
+	d.mu.RLock()
+	routes := len(d.routes)
+	d.mu.RUnlock()
+
+	fmt.Fprintln(w, "# HELP directory_servers 目录服务中可连接的战斗服数")
+	fmt.Fprintln(w, "# TYPE directory_servers gauge")
+	fmt.Fprintf(w, "directory_servers %d\n", routes)
+	fmt.Fprintln(w, "# HELP directory_lookups_total 目录服务按ID查询战斗服的次数")
+	fmt.Fprintln(w, "# TYPE directory_lookups_total counter")
+	fmt.Fprintf(w, "directory_lookups_total{result=\"found\"} %d\n", d.found.Load())
+	fmt.Fprintf(w, "directory_lookups_total{result=\"not_found\"} %d\n", d.notFound.Load())
+	fmt.Fprintln(w, "# HELP directory_tickets_issued_total 目录服务签发的票据数")
+	fmt.Fprintln(w, "# TYPE directory_tickets_issued_total counter")
+	fmt.Fprintf(w, "directory_tickets_issued_total %d\n", d.issued.Load())
+	fmt.Fprintln(w, "# HELP directory_unauthorized_total 目录服务拒绝的未认证请求数")
+	fmt.Fprintln(w, "# TYPE directory_unauthorized_total counter")
+	fmt.Fprintf(w, "directory_unauthorized_total %d\n", d.unauthorized.Load())
+	fmt.Fprintln(w, "# HELP directory_player_requests_total 目录服务以玩家凭证认证通过的请求数")
+	fmt.Fprintln(w, "# TYPE directory_player_requests_total counter")
+	fmt.Fprintf(w, "directory_player_requests_total %d\n", d.players.Load())
+}
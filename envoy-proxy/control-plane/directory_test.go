@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	consulapi "github.com/hashicorp/consul/api"
+
+	"gameproto"
+)
+
+func TestDirectoryAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		path   string
+		want   int
+	}{
+		{"未配置令牌", "", "", "/v1/servers", http.StatusOK},
+		{"缺少令牌", "s3cret", "", "/v1/servers", http.StatusUnauthorized},
+		{"令牌错误", "s3cret", "Bearer wrong", "/v1/servers", http.StatusUnauthorized},
+		{"令牌前缀", "s3cret", "Bearer s3cre", "/v1/servers", http.StatusUnauthorized},
+		{"非Bearer认证", "s3cret", "Basic s3cret", "/v1/servers", http.StatusUnauthorized},
+		{"列表", "s3cret", "Bearer s3cret", "/v1/servers", http.StatusOK},
+		{"查询签发票据需要令牌", "s3cret", "", "/v1/servers/battle-1", http.StatusUnauthorized},
+		{"查询", "s3cret", "Bearer s3cret", "/v1/servers/battle-1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDirectory(DirectoryOptions{Token: tt.token})
+			d.SetRoutes([]directoryRoute{{ServerID: "battle-1", Port: 10000}})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			d.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusUnauthorized && (d.unauthorized.Load() != 1 || d.found.Load() != 0) {
+				t.Errorf("unauthorized = %d, found = %d", d.unauthorized.Load(), d.found.Load())
+			}
+		})
+	}
+}
+
+// TestDirectoryPlayerAuth 玩家携带登录服务签发的凭证逐个请求认证，可信后端仍可使用共享令牌
+func TestDirectoryPlayerAuth(t *testing.T) {
+	now := time.Now()
+	playerKeys, err := gameproto.ParseTicketKeys("p1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)))
+	if err != nil {
+		t.Fatalf("ParseTicketKeys: %v", err)
+	}
+	otherKeys, err := gameproto.ParseTicketKeys("p1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)))
+	if err != nil {
+		t.Fatalf("ParseTicketKeys: %v", err)
+	}
+	ticketKeys, err := gameproto.ParseTicketKeys("k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatalf("ParseTicketKeys: %v", err)
+	}
+	playerToken := func(k *gameproto.TicketKeys, ttl time.Duration) string {
+		s, err := k.IssuePlayerToken("player-42", ttl, now)
+		if err != nil {
+			t.Fatalf("IssuePlayerToken: %v", err)
+		}
+		return s
+	}
+	ticket, err := ticketKeys.Issue("battle-1", 1, time.Minute, now)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		header      string
+		want        int
+		wantPlayers uint64
+	}{
+		{"玩家凭证", "", "Bearer " + playerToken(playerKeys, time.Hour), http.StatusOK, 1},
+		{"同时配置后端令牌时玩家凭证仍有效", "s3cret", "Bearer " + playerToken(playerKeys, time.Hour), http.StatusOK, 1},
+		{"后端令牌", "s3cret", "Bearer s3cret", http.StatusOK, 0},
+		{"未配置后端令牌时任意字符串无效", "", "Bearer s3cret", http.StatusUnauthorized, 0},
+		{"缺少凭证", "", "", http.StatusUnauthorized, 0},
+		{"凭证已过期", "", "Bearer " + playerToken(playerKeys, -time.Second), http.StatusUnauthorized, 0},
+		{"其他密钥签发的凭证", "", "Bearer " + playerToken(otherKeys, time.Hour), http.StatusUnauthorized, 0},
+		{"会话票据不能当作凭证", "", "Bearer " + base64.RawURLEncoding.EncodeToString(ticket), http.StatusUnauthorized, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDirectory(DirectoryOptions{Token: tt.token, PlayerKeys: playerKeys, TicketKeys: ticketKeys})
+			d.SetRoutes([]directoryRoute{{ServerID: "battle-1", Port: 10000}})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/servers/battle-1", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			d.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if d.players.Load() != tt.wantPlayers {
+				t.Errorf("players = %d, want %d", d.players.Load(), tt.wantPlayers)
+			}
+			wantIssued := uint64(0)
+			if tt.want == http.StatusOK {
+				wantIssued = 1
+			}
+			if d.issued.Load() != wantIssued {
+				t.Errorf("issued = %d, want %d", d.issued.Load(), wantIssued)
+			}
+		})
+	}
+}
+
+func TestDirectoryLookup(t *testing.T) {
+	keys, err := gameproto.ParseTicketKeys("k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatalf("ParseTicketKeys: %v", err)
+	}
+	d := NewDirectory(DirectoryOptions{TicketKeys: keys, TicketTTL: time.Minute, Token: "t"})
+	d.SetRoutes([]directoryRoute{{ServerID: "battle-2", Port: 10001}, {ServerID: "battle-1", Port: 10000, QUICPort: 11000}})
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer t")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+
+	var list struct {
+		Servers []directoryRoute `json:"servers"`
+	}
+	if code := get("/v1/servers", &list); code != http.StatusOK || len(list.Servers) != 2 || list.Servers[0].ServerID != "battle-1" {
+		t.Fatalf("list = %d %+v", code, list)
+	}
+
+	var entry directoryEntry
+	if code := get("/v1/servers/battle-1", &entry); code != http.StatusOK {
+		t.Fatalf("lookup status = %d", code)
+	}
+	if entry.Port != 10000 || entry.QUICPort != 11000 || entry.Host != "127.0.0.1" {
+		t.Errorf("entry = %+v", entry)
+	}
+	ticket, err := keys.Verify(entry.Ticket, time.Now())
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if ticket.ServerID != "battle-1" || ticket.SessionID != entry.SessionID || entry.SessionID == 0 {
+		t.Errorf("ticket = %+v, session_id = %d", ticket, entry.SessionID)
+	}
+
+	var again directoryEntry
+	get("/v1/servers/battle-1", &again)
+	if again.SessionID == entry.SessionID {
+		t.Error("每次查询应签发新会话的票据")
+	}
+	if code := get("/v1/servers/battle-9", nil); code != http.StatusNotFound {
+		t.Errorf("unknown server status = %d", code)
+	}
+	if d.found.Load() != 2 || d.notFound.Load() != 1 || d.issued.Load() != 2 {
+		t.Errorf("found = %d notFound = %d issued = %d", d.found.Load(), d.notFound.Load(), d.issued.Load())
+	}
+}
+
+func battleEntry(id, address string, port int, externalPort string, meta map[string]string) *consulapi.ServiceEntry {
+	m := map[string]string{metaExternalPort: externalPort, metaProtocol: "udp"}
+	for k, v := range meta {
+		m[k] = v
+	}
+	return &consulapi.ServiceEntry{
+		Node:    &consulapi.Node{Node: "node-1"},
+		Service: &consulapi.AgentService{ID: id, Service: "game-server", Address: address, Port: port, Meta: m},
+	}
+}
+
+// TestDirectoryRoutesFollowSnapshot 目录只在快照设置成功后更新，且不包含所有端点都不健康的战斗服
+func TestDirectoryRoutesFollowSnapshot(t *testing.T) {
+	t.Setenv("ENVOY_NODE_ID", "")
+	entries := []*consulapi.ServiceEntry{
+		battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil),
+		battleEntry("battle-2", "10.0.0.2", 9000, "10001", nil),
+		battleEntry("battle-2-standby", "10.0.0.3", 9000, "10001", map[string]string{metaStandbyFor: "battle-2"}),
+		battleEntry("battle-3", "10.0.0.4", 9000, "10002", nil),
+	}
+	cp, err := NewControlPlane(fakeConsul(t, entries), 0, ListenerOptions{}, XDSTLSOptions{})
+	if err != nil {
+		t.Fatalf("NewControlPlane: %v", err)
+	}
+	defer cp.cancel()
+	cp.directory = NewDirectory(DirectoryOptions{})
+	cp.health = NewUDPHealthChecker(HealthCheckOptions{Interval: time.Second}, nil)
+
+	// battle-2 主实例被剔除但热备健康，battle-3 唯一的端点被剔除
+	cp.health.SetTargets([]upstreamHost{{ServiceID: "battle-1"}, {ServiceID: "battle-2"}, {ServiceID: "battle-2-standby"}, {ServiceID: "battle-3"}})
+	cp.health.ReportEnvoyEjections("proxy-1", map[string]bool{"battle-2": true, "battle-3": true})
+
+	_, routes, err := cp.buildSnapshot(cp.ctx, entries)
+	if err != nil {
+		t.Fatalf("buildSnapshot: %v", err)
+	}
+	if len(routes) != 2 || len(cp.directory.routes) != 0 {
+		t.Fatalf("routes = %+v, directory = %d：构建快照时不应更新目录", routes, len(cp.directory.routes))
+	}
+
+	cp.updateEnvoyConfig()
+
+	var ids []string
+	for id := range cp.directory.routes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, " ") != "battle-1 battle-2" || cp.directory.routes["battle-2"].Port != 10001 {
+		t.Errorf("routes = %v, want [battle-1 battle-2]", ids)
+	}
+	// Envoy 仍为不健康的战斗服保留监听器，恢复后无需重新下发
+	snapshot, err := cp.cache.GetSnapshot("proxy-1")
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if n := len(snapshot.GetResources(resource.ListenerType)); n != 3 {
+		t.Errorf("listeners = %d, want 3", n)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/anypb"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	router "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	quic "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/quic/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	cache_types "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// EdgeTLSMode 玩家与Envoy之间的加密方式，Envoy与战斗服之间始终为明文UDP
+type EdgeTLSMode string
+
+const (
+	// EdgeTLSNone 只生成明文UDP监听器
+	EdgeTLSNone EdgeTLSMode = "none"
+	// EdgeTLSQUIC 额外生成QUIC监听器：客户端经HTTP/3 CONNECT-UDP（RFC 9298）建立加密隧道，
+	// Envoy终止隧道后把其中的数据报以明文UDP转发给战斗服
+	EdgeTLSQUIC EdgeTLSMode = "quic"
+)
+
+const (
+	defaultEdgeSecretName  = "edge_cert"
+	defaultEdgeQUICOffset  = 1000
+	defaultEdgeSDSCluster  = "xds_control_plane"
+	defaultEdgeTLSInterval = 30 * time.Second
+)
+
+// parseEdgeTLSMode 解析边缘加密方式，空字符串视为none
+func parseEdgeTLSMode(s string) (EdgeTLSMode, error) {
+	switch mode := EdgeTLSMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return EdgeTLSNone, nil
+	case EdgeTLSNone, EdgeTLSQUIC:
+		return mode, nil
+	case "dtls":
+		return "", fmt.Errorf("Envoy不支持终止DTLS，请使用 quic")
+	default:
+		return "", fmt.Errorf("未知的边缘加密方式: %s", s)
+	}
+}
+
+// EdgeTLSOptions 面向玩家的加密监听器配置
+type EdgeTLSOptions struct {
+	Mode EdgeTLSMode
+	// CertFile/KeyFile 边缘证书与私钥，由控制平面读取后经SDS下发，文件更新后无需重启Envoy
+	CertFile string
+	KeyFile  string
+	// SecretName SDS中证书的名称
+	SecretName string
+	// SDSCluster Envoy拉取SDS使用的集群名，需在bootstrap中静态定义
+	SDSCluster string
+	// PortOffset QUIC监听器端口相对明文端口的偏移，如外部端口10000对应QUIC端口11000
+	PortOffset int
+	// ReloadInterval 检查证书文件是否更新的间隔
+	ReloadInterval time.Duration
+}
+
+// secretStore 从磁盘加载边缘证书并生成SDS资源，证书文件更新后重新加载并触发配置重建
+type secretStore struct {
+	opts     EdgeTLSOptions
+	onChange func()
+
+	mu      sync.Mutex
+	secret  *tlsv3.Secret
+	modTime time.Time
+}
+
+// newSecretStore 加载边缘证书，文件无法加载或证书与私钥不匹配时返回错误
+func newSecretStore(opts EdgeTLSOptions, onChange func()) (*secretStore, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, fmt.Errorf("边缘加密方式 %s 需要配置证书与私钥 (EDGE_TLS_CERT/EDGE_TLS_KEY)", opts.Mode)
+	}
+	if opts.SecretName == "" {
+		opts.SecretName = defaultEdgeSecretName
+	}
+	if opts.SDSCluster == "" {
+		opts.SDSCluster = defaultEdgeSDSCluster
+	}
+	if opts.PortOffset == 0 {
+		opts.PortOffset = defaultEdgeQUICOffset
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = defaultEdgeTLSInterval
+	}
+
+	s := &secretStore{opts: opts, onChange: onChange}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run 定期检查证书文件，直到 ctx 结束
+func (s *secretStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := latestModTime(s.opts.CertFile, s.opts.KeyFile)
+			if err != nil {
+				slog.Warn("检查边缘证书文件失败，继续使用当前证书", "error", err)
+				continue
+			}
+			s.mu.Lock()
+			changed := modTime.After(s.modTime)
+			s.mu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := s.load(); err != nil {
+				slog.Warn("重新加载边缘证书失败，继续使用当前证书", "error", err)
+				continue
+			}
+			slog.Info("已重新加载边缘证书，下发新的SDS资源", "secret", s.opts.SecretName)
+			s.onChange()
+		}
+	}
+}
+
+// load 读取证书与私钥并校验二者匹配，避免把无法使用的证书下发给Envoy
+func (s *secretStore) load() error {
+	modTime, err := latestModTime(s.opts.CertFile, s.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("读取边缘证书文件失败: %v", err)
+	}
+	certPEM, err := os.ReadFile(s.opts.CertFile)
+	if err != nil {
+		return fmt.Errorf("读取边缘证书失败: %v", err)
+	}
+	keyPEM, err := os.ReadFile(s.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("读取边缘私钥失败: %v", err)
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("边缘证书与私钥无效: %v", err)
+	}
+
+	secret := &tlsv3.Secret{
+		Name: s.opts.SecretName,
+		Type: &tlsv3.Secret_TlsCertificate{
+			TlsCertificate: &tlsv3.TlsCertificate{
+				CertificateChain: &core.DataSource{Specifier: &core.DataSource_InlineBytes{InlineBytes: certPEM}},
+				PrivateKey:       &core.DataSource{Specifier: &core.DataSource_InlineBytes{InlineBytes: keyPEM}},
+			},
+		},
+	}
+
+	s.mu.Lock()
+	s.secret = secret
+	s.modTime = modTime
+	s.mu.Unlock()
+	return nil
+}
+
+// resources 当前的SDS资源
+func (s *secretStore) resources() []cache_types.Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return []cache_types.Resource{s.secret}
+}
+
+// sdsConfig Envoy通过 SDSCluster 拉取证书的配置源
+func (s *secretStore) sdsConfig() *core.ConfigSource {
+	return &core.ConfigSource{
+		ResourceApiVersion: core.ApiVersion_V3,
+		ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
+			ApiConfigSource: &core.ApiConfigSource{
+				ApiType:             core.ApiConfigSource_GRPC,
+				TransportApiVersion: core.ApiVersion_V3,
+				GrpcServices: []*core.GrpcService{{
+					TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+						EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: s.opts.SDSCluster},
+					},
+				}},
+			},
+		},
+	}
+}
+
+// quicPort 明文端口对应的QUIC端口
+func (s *secretStore) quicPort(port uint32) uint32 {
+	return uint32(int(port) + s.opts.PortOffset)
+}
+
+// createQUICListener 创建终止CONNECT-UDP隧道的QUIC监听器，隧道中的数据报转发到与明文监听器相同的集群
+func (s *secretStore) createQUICListener(name string, port uint32, clusterName, serviceID string) (*listener.Listener, error) {
+	routerConfig, err := anypb.New(&router.Router{})
+	if err != nil {
+		return nil, fmt.Errorf("创建router过滤器失败: %v", err)
+	}
+
+	connectUDP := "CONNECT-UDP"
+	manager, err := anypb.New(&hcm.HttpConnectionManager{
+		CodecType:  hcm.HttpConnectionManager_HTTP3,
+		StatPrefix: battleStatPrefix(serviceID, port),
+		Http3ProtocolOptions: &core.Http3ProtocolOptions{
+			AllowExtendedConnect: true,
+		},
+		UpgradeConfigs: []*hcm.HttpConnectionManager_UpgradeConfig{{UpgradeType: connectUDP}},
+		RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{
+			RouteConfig: &route.RouteConfiguration{
+				Name: name,
+				VirtualHosts: []*route.VirtualHost{{
+					Name:    serviceID,
+					Domains: []string{"*"},
+					Routes: []*route.Route{{
+						Match: &route.RouteMatch{
+							PathSpecifier: &route.RouteMatch_ConnectMatcher_{ConnectMatcher: &route.RouteMatch_ConnectMatcher{}},
+						},
+						Action: &route.Route_Route{
+							Route: &route.RouteAction{
+								ClusterSpecifier: &route.RouteAction_Cluster{Cluster: clusterName},
+								UpgradeConfigs: []*route.RouteAction_UpgradeConfig{{
+									UpgradeType:   connectUDP,
+									ConnectConfig: &route.RouteAction_UpgradeConfig_ConnectConfig{},
+								}},
+							},
+						},
+					}},
+				}},
+			},
+		},
+		HttpFilters: []*hcm.HttpFilter{{
+			Name:       "envoy.filters.http.router",
+			ConfigType: &hcm.HttpFilter_TypedConfig{TypedConfig: routerConfig},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建HTTP/3连接管理器失败: %v", err)
+	}
+
+	transport, err := anypb.New(&quic.QuicDownstreamTransport{
+		DownstreamTlsContext: &tlsv3.DownstreamTlsContext{
+			CommonTlsContext: &tlsv3.CommonTlsContext{
+				AlpnProtocols: []string{"h3"},
+				TlsCertificateSdsSecretConfigs: []*tlsv3.SdsSecretConfig{{
+					Name:      s.opts.SecretName,
+					SdsConfig: s.sdsConfig(),
+				}},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建QUIC传输套接字失败: %v", err)
+	}
+
+	return &listener.Listener{
+		Name: name,
+		Address: &core.Address{
+			Address: &core.Address_SocketAddress{
+				SocketAddress: &core.SocketAddress{
+					Protocol: core.SocketAddress_UDP,
+					Address:  "0.0.0.0",
+					PortSpecifier: &core.SocketAddress_PortValue{
+						PortValue: port,
+					},
+				},
+			},
+		},
+		UdpListenerConfig: &listener.UdpListenerConfig{
+			QuicOptions: &listener.QuicProtocolOptions{},
+		},
+		FilterChains: []*listener.FilterChain{{
+			TransportSocket: &core.TransportSocket{
+				Name:       "envoy.transport_sockets.quic",
+				ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: transport},
+			},
+			Filters: []*listener.Filter{{
+				Name:       "envoy.filters.network.http_connection_manager",
+				ConfigType: &listener.Filter_TypedConfig{TypedConfig: manager},
+			}},
+		}},
+	}, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	quic "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/quic/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+)
+
+func TestParseEdgeTLSMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    EdgeTLSMode
+		wantErr bool
+	}{
+		{"", EdgeTLSNone, false},
+		{"none", EdgeTLSNone, false},
+		{"quic", EdgeTLSQUIC, false},
+		{" QUIC ", EdgeTLSQUIC, false},
+		{"dtls", "", true},
+		{"tls", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseEdgeTLSMode(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseEdgeTLSMode(%q) = %q, %v, want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// edgeCertFiles 在临时目录中写入边缘证书与私钥
+func edgeCertFiles(t *testing.T, c testCert) EdgeTLSOptions {
+	t.Helper()
+	dir := t.TempDir()
+	opts := EdgeTLSOptions{
+		Mode:     EdgeTLSQUIC,
+		CertFile: filepath.Join(dir, "edge.crt"),
+		KeyFile:  filepath.Join(dir, "edge.key"),
+	}
+	writeFile(t, opts.CertFile, c.certPEM, time.Now().Add(-time.Minute))
+	writeFile(t, opts.KeyFile, c.keyPEM, time.Now().Add(-time.Minute))
+	return opts
+}
+
+// servedSecret 当前SDS资源中的证书链
+func servedSecret(s *secretStore) []byte {
+	secret := s.resources()[0].(*tlsv3.Secret)
+	return secret.GetTlsCertificate().GetCertificateChain().GetInlineBytes()
+}
+
+func TestNewSecretStore(t *testing.T) {
+	good := newTestCert(t, "edge.example.com")
+	other := newTestCert(t, "other")
+
+	tests := []struct {
+		name    string
+		modify  func(o *EdgeTLSOptions)
+		wantErr bool
+	}{
+		{"有效", func(*EdgeTLSOptions) {}, false},
+		{"未配置私钥", func(o *EdgeTLSOptions) { o.KeyFile = "" }, true},
+		{"证书文件不存在", func(o *EdgeTLSOptions) { o.CertFile += ".missing" }, true},
+		{"证书与私钥不匹配", func(o *EdgeTLSOptions) { writeFile(t, o.KeyFile, other.keyPEM, time.Now()) }, true},
+		{"证书只写了一半", func(o *EdgeTLSOptions) { writeFile(t, o.CertFile, good.certPEM[:len(good.certPEM)/2], time.Now()) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := edgeCertFiles(t, good)
+			tt.modify(&opts)
+			s, err := newSecretStore(opts, func() {})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(servedSecret(s), good.certPEM) {
+				t.Error("SDS资源不是配置的证书")
+			}
+			if s.opts.SecretName != defaultEdgeSecretName || s.opts.SDSCluster != defaultEdgeSDSCluster || s.quicPort(10000) != 11000 {
+				t.Errorf("默认值 = %+v", s.opts)
+			}
+		})
+	}
+}
+
+// TestSecretStoreRotation 证书轮换：文件写完整后重新加载并触发配置重建，半写入或不匹配时保留当前证书
+func TestSecretStoreRotation(t *testing.T) {
+	first := newTestCert(t, "edge.example.com")
+	second := newTestCert(t, "edge.example.com")
+	opts := edgeCertFiles(t, first)
+	opts.ReloadInterval = 5 * time.Millisecond
+
+	changed := make(chan struct{}, 16)
+	s, err := newSecretStore(opts, func() { changed <- struct{}{} })
+	if err != nil {
+		t.Fatalf("newSecretStore: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	noChange := func(msg string) {
+		t.Helper()
+		select {
+		case <-changed:
+			t.Fatal(msg)
+		case <-time.After(10 * opts.ReloadInterval):
+		}
+		if !bytes.Equal(servedSecret(s), first.certPEM) {
+			t.Fatal(msg)
+		}
+	}
+
+	now := time.Now()
+	writeFile(t, opts.CertFile, second.certPEM[:len(second.certPEM)/2], now)
+	noChange("加载了只写了一半的证书")
+
+	writeFile(t, opts.CertFile, second.certPEM, now.Add(time.Second))
+	noChange("加载了与私钥不匹配的证书")
+
+	writeFile(t, opts.KeyFile, second.keyPEM, now.Add(2*time.Second))
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("私钥写入后未触发配置重建")
+	}
+	if !bytes.Equal(servedSecret(s), second.certPEM) {
+		t.Error("SDS资源未更新为新证书")
+	}
+
+	// 文件未再变化时不重复触发
+	select {
+	case <-changed:
+		t.Error("证书未变化却触发了配置重建")
+	case <-time.After(10 * opts.ReloadInterval):
+	}
+}
+
+func TestCreateQUICListener(t *testing.T) {
+	opts := edgeCertFiles(t, newTestCert(t, "edge.example.com"))
+	opts.SecretName, opts.SDSCluster = "battle_cert", "sds_cluster"
+	s, err := newSecretStore(opts, func() {})
+	if err != nil {
+		t.Fatalf("newSecretStore: %v", err)
+	}
+
+	l, err := s.createQUICListener("listener_11000_quic", 11000, "cluster_battle-1_10000", "battle-1")
+	if err != nil {
+		t.Fatalf("createQUICListener: %v", err)
+	}
+	sa := l.GetAddress().GetSocketAddress()
+	if sa.GetProtocol() != core.SocketAddress_UDP || sa.GetPortValue() != 11000 || l.GetUdpListenerConfig().GetQuicOptions() == nil {
+		t.Errorf("address = %v, udp config = %v", sa, l.GetUdpListenerConfig())
+	}
+	if len(l.FilterChains) != 1 {
+		t.Fatalf("filter chains = %d", len(l.FilterChains))
+	}
+	chain := l.FilterChains[0]
+
+	var transport quic.QuicDownstreamTransport
+	if err := chain.GetTransportSocket().GetTypedConfig().UnmarshalTo(&transport); err != nil {
+		t.Fatalf("transport socket: %v", err)
+	}
+	common := transport.GetDownstreamTlsContext().GetCommonTlsContext()
+	sds := common.GetTlsCertificateSdsSecretConfigs()
+	if len(sds) != 1 || sds[0].Name != "battle_cert" ||
+		sds[0].GetSdsConfig().GetApiConfigSource().GetGrpcServices()[0].GetEnvoyGrpc().GetClusterName() != "sds_cluster" {
+		t.Errorf("sds = %v", sds)
+	}
+	if len(common.AlpnProtocols) != 1 || common.AlpnProtocols[0] != "h3" {
+		t.Errorf("alpn = %v", common.AlpnProtocols)
+	}
+
+	var manager hcm.HttpConnectionManager
+	if err := chain.Filters[0].GetTypedConfig().UnmarshalTo(&manager); err != nil {
+		t.Fatalf("http connection manager: %v", err)
+	}
+	if manager.CodecType != hcm.HttpConnectionManager_HTTP3 || !manager.GetHttp3ProtocolOptions().GetAllowExtendedConnect() {
+		t.Errorf("codec = %v, http3 options = %v", manager.CodecType, manager.GetHttp3ProtocolOptions())
+	}
+	if manager.StatPrefix != battleStatPrefix("battle-1", 11000) {
+		t.Errorf("stat prefix = %s", manager.StatPrefix)
+	}
+	routes := manager.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()
+	if len(routes) != 1 || routes[0].GetMatch().GetConnectMatcher() == nil {
+		t.Fatalf("routes = %v", routes)
+	}
+	action := routes[0].GetRoute()
+	if action.GetCluster() != "cluster_battle-1_10000" || len(action.UpgradeConfigs) != 1 ||
+		action.UpgradeConfigs[0].UpgradeType != "CONNECT-UDP" || action.UpgradeConfigs[0].ConnectConfig == nil {
+		t.Errorf("route action = %v", action)
+	}
+}
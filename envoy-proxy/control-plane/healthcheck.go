@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+// Envoy 没有原生的UDP主动健康检查，控制平面按 GameServer.processMessage 的协议自行探测：
+// 发送 PING，期望收到以 PONG 开头的响应；结果通过集群端点的 health_status 下发给所有Envoy节点。
+var (
+	udpHealthCheckSend    = []byte("PING")
+	udpHealthCheckReceive = []byte("PONG")
+)
+
+// HealthCheckOptions UDP主动健康检查配置
+type HealthCheckOptions struct {
+	// Interval 探测间隔，为0时关闭主动健康检查
+	Interval           time.Duration
+	Timeout            time.Duration
+	UnhealthyThreshold int
+	HealthyThreshold   int
+}
+
+// hostHealth 单个上游端点的探测状态
+type hostHealth struct {
+	host         upstreamHost
+	healthy      bool
+	successes    int             // 连续成功次数
+	failures     int             // 连续失败次数
+	envoyEjected map[string]bool // nodeID -> 是否被该Envoy节点的异常检测剔除
+}
+
+// unhealthy 探测失败或任一Envoy节点报告异常剔除时视为不健康
+func (h *hostHealth) unhealthy() bool {
+	if !h.healthy {
+		return true
+	}
+	for _, ejected := range h.envoyEjected {
+		if ejected {
+			return true
+		}
+	}
+	return false
+}
+
+// UDPHealthChecker 对所有战斗服端点做 PING/PONG 探测，并汇总Envoy上报的异常剔除
+type UDPHealthChecker struct {
+	opts     HealthCheckOptions
+	onChange func() // 任一端点健康状态变化时调用，用于触发快照重建
+
+	mu    sync.RWMutex
+	hosts map[string]*hostHealth // ServiceID -> 状态
+}
+
+// NewUDPHealthChecker 创建健康检查器
+func NewUDPHealthChecker(opts HealthCheckOptions, onChange func()) *UDPHealthChecker {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.UnhealthyThreshold <= 0 {
+		opts.UnhealthyThreshold = 3
+	}
+	if opts.HealthyThreshold <= 0 {
+		opts.HealthyThreshold = 2
+	}
+	return &UDPHealthChecker{
+		opts:     opts,
+		onChange: onChange,
+		hosts:    make(map[string]*hostHealth),
+	}
+}
+
+// SetTargets 更新探测目标。新端点初始视为健康，避免刚注册的实例在首次探测前被剔除
+func (hc *UDPHealthChecker) SetTargets(hosts []upstreamHost) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	seen := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		seen[h.ServiceID] = true
+		if state, ok := hc.hosts[h.ServiceID]; ok {
+			state.host = h
+			continue
+		}
+		hc.hosts[h.ServiceID] = &hostHealth{host: h, healthy: true, envoyEjected: make(map[string]bool)}
+	}
+	for id := range hc.hosts {
+		if !seen[id] {
+			delete(hc.hosts, id)
+		}
+	}
+}
+
+// Status 返回下发给Envoy的端点健康状态，未知端点返回 UNKNOWN
+func (hc *UDPHealthChecker) Status(serviceID string) core.HealthStatus {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	state, ok := hc.hosts[serviceID]
+	if !ok {
+		return core.HealthStatus_UNKNOWN
+	}
+	if state.unhealthy() {
+		return core.HealthStatus_UNHEALTHY
+	}
+	return core.HealthStatus_HEALTHY
+}
+
+// ReportEnvoyEjections 记录某个Envoy节点报告的异常剔除端点，状态变化时触发快照重建
+func (hc *UDPHealthChecker) ReportEnvoyEjections(nodeID string, ejected map[string]bool) {
+	changed := false
+
+	hc.mu.Lock()
+	for id, state := range hc.hosts {
+		before := state.unhealthy()
+		state.envoyEjected[nodeID] = ejected[id]
+		if state.unhealthy() != before {
+			changed = true
+			slog.Info("Envoy节点报告端点异常剔除状态变化", "node_id", nodeID, "server_id", id, "ejected", ejected[id])
+		}
+	}
+	hc.mu.Unlock()
+
+	if changed && hc.onChange != nil {
+		hc.onChange()
+	}
+}
+
+// Run 定期探测所有端点，直到 ctx 结束
+func (hc *UDPHealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(hc.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			hc.checkAll(ctx)
+		}
+	}
+}
+
+func (hc *UDPHealthChecker) checkAll(ctx context.Context) {
+	hc.mu.RLock()
+	targets := make([]upstreamHost, 0, len(hc.hosts))
+	for _, state := range hc.hosts {
+		targets = append(targets, state.host)
+	}
+	hc.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	var changedMu sync.Mutex
+	changed := false
+	for _, h := range targets {
+		wg.Add(1)
+		go func(h upstreamHost) {
+			defer wg.Done()
+			err := probeUDP(ctx, h, hc.opts.Timeout)
+			if hc.record(h.ServiceID, err) {
+				changedMu.Lock()
+				changed = true
+				changedMu.Unlock()
+			}
+		}(h)
+	}
+	wg.Wait()
+
+	if changed && hc.onChange != nil {
+		hc.onChange()
+	}
+}
+
+// record 记录一次探测结果，返回对外的健康状态是否发生变化
+func (hc *UDPHealthChecker) record(serviceID string, probeErr error) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	state, ok := hc.hosts[serviceID]
+	if !ok {
+		return false
+	}
+	before := state.unhealthy()
+
+	if probeErr == nil {
+		state.failures = 0
+		state.successes++
+		if !state.healthy && state.successes >= hc.opts.HealthyThreshold {
+			state.healthy = true
+			slog.Info("端点UDP健康检查恢复", "server_id", serviceID)
+		}
+	} else {
+		state.successes = 0
+		state.failures++
+		if state.healthy && state.failures >= hc.opts.UnhealthyThreshold {
+			state.healthy = false
+			slog.Warn("端点UDP健康检查连续失败", "server_id", serviceID, "failures", state.failures, "error", probeErr)
+		}
+	}
+
+	return state.unhealthy() != before
+}
+
+// probeUDP 向端点发送一次 PING 并等待 PONG
+func probeUDP(ctx context.Context, h upstreamHost, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(h.Address, strconv.Itoa(h.Port)))
+	if err != nil {
+		return fmt.Errorf("连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(udpHealthCheckSend); err != nil {
+		return fmt.Errorf("发送探测失败: %v", err)
+	}
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("等待响应失败: %v", err)
+	}
+	if !bytes.HasPrefix(buf[:n], udpHealthCheckReceive) {
+		return fmt.Errorf("响应不匹配: %q", buf[:n])
+	}
+	return nil
+}
+
+// writeMetrics 输出端点健康状态指标
+func (hc *UDPHealthChecker) writeMetrics(w io.Writer) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	ids := make([]string, 0, len(hc.hosts))
+	for id := range hc.hosts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Fprintln(w, "# HELP battle_upstream_healthy 战斗服端点健康状态（UDP探测与Envoy异常检测综合结果）")
+	fmt.Fprintln(w, "# TYPE battle_upstream_healthy gauge")
+	for _, id := range ids {
+		healthy := 1
+		if hc.hosts[id].unhealthy() {
+			healthy = 0
+		}
+		fmt.Fprintf(w, "battle_upstream_healthy{service_id=%q} %d\n", id, healthy)
+	}
+}
+
+// envoyClustersResponse /clusters?format=json 的响应中与主机健康相关的部分
+type envoyClustersResponse struct {
+	ClusterStatuses []struct {
+		Name         string `json:"name"`
+		HostStatuses []struct {
+			Hostname     string `json:"hostname"`
+			HealthStatus struct {
+				FailedOutlierCheck bool `json:"failed_outlier_check"`
+			} `json:"health_status"`
+		} `json:"host_statuses"`
+	} `json:"cluster_statuses"`
+}
+
+// scrapeEjections 从Envoy admin的 /clusters 中读取被异常检测剔除的端点，端点以 hostname(ServiceID) 标识
+func scrapeEjections(ctx context.Context, client *http.Client, addr string) (map[string]bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/clusters?format=json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin接口返回状态 %d", resp.StatusCode)
+	}
+
+	var clusters envoyClustersResponse
+	if err := json.NewDecoder(resp.Body).Decode(&clusters); err != nil {
+		return nil, fmt.Errorf("解析集群状态失败: %v", err)
+	}
+
+	ejected := make(map[string]bool)
+	for _, c := range clusters.ClusterStatuses {
+		for _, h := range c.HostStatuses {
+			if h.Hostname != "" && h.HealthStatus.FailedOutlierCheck {
+				ejected[h.Hostname] = true
+			}
+		}
+	}
+	return ejected, nil
+}
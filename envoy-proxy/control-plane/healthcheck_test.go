@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+func TestUDPHealthCheckerRecord(t *testing.T) {
+	probeErr := errors.New("等待响应失败")
+	tests := []struct {
+		name        string
+		results     []error // 依次记录的探测结果
+		wantChanged []bool  // 每次记录后对外状态是否变化
+		wantStatus  core.HealthStatus
+	}{
+		{
+			name:        "连续失败达到阈值才判为不健康",
+			results:     []error{probeErr, probeErr, probeErr, probeErr},
+			wantChanged: []bool{false, false, true, false},
+			wantStatus:  core.HealthStatus_UNHEALTHY,
+		},
+		{
+			name:        "成功打断连续失败",
+			results:     []error{probeErr, probeErr, nil, probeErr, probeErr},
+			wantChanged: []bool{false, false, false, false, false},
+			wantStatus:  core.HealthStatus_HEALTHY,
+		},
+		{
+			name:        "连续成功达到阈值才恢复",
+			results:     []error{probeErr, probeErr, probeErr, nil, nil},
+			wantChanged: []bool{false, false, true, false, true},
+			wantStatus:  core.HealthStatus_HEALTHY,
+		},
+		{
+			name:        "恢复过程中再次失败重新计数",
+			results:     []error{probeErr, probeErr, probeErr, nil, probeErr, nil},
+			wantChanged: []bool{false, false, true, false, false, false},
+			wantStatus:  core.HealthStatus_UNHEALTHY,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := NewUDPHealthChecker(HealthCheckOptions{Interval: time.Second}, nil)
+			hc.SetTargets([]upstreamHost{{ServiceID: "battle-1"}})
+			for i, err := range tt.results {
+				if got := hc.record("battle-1", err); got != tt.wantChanged[i] {
+					t.Errorf("record %d changed = %v, want %v", i, got, tt.wantChanged[i])
+				}
+			}
+			if got := hc.Status("battle-1"); got != tt.wantStatus {
+				t.Errorf("status = %v, want %v", got, tt.wantStatus)
+			}
+		})
+	}
+
+	// 已移除的端点不记录结果
+	hc := NewUDPHealthChecker(HealthCheckOptions{Interval: time.Second}, nil)
+	if hc.record("battle-9", probeErr) || hc.Status("battle-9") != core.HealthStatus_UNKNOWN {
+		t.Error("未知端点的探测结果被记录")
+	}
+}
+
+func TestReportEnvoyEjections(t *testing.T) {
+	type report struct {
+		nodeID  string
+		ejected map[string]bool
+	}
+	tests := []struct {
+		name         string
+		reports      []report
+		wantChanges  int
+		wantStatuses map[string]core.HealthStatus
+	}{
+		{
+			name:         "单个节点剔除即视为不健康",
+			reports:      []report{{"proxy-1", map[string]bool{"battle-1": true}}},
+			wantChanges:  1,
+			wantStatuses: map[string]core.HealthStatus{"battle-1": core.HealthStatus_UNHEALTHY, "battle-2": core.HealthStatus_HEALTHY},
+		},
+		{
+			name: "重复报告相同状态不触发重建",
+			reports: []report{
+				{"proxy-1", map[string]bool{"battle-1": true}},
+				{"proxy-1", map[string]bool{"battle-1": true}},
+			},
+			wantChanges:  1,
+			wantStatuses: map[string]core.HealthStatus{"battle-1": core.HealthStatus_UNHEALTHY},
+		},
+		{
+			name: "其他节点仍剔除时保持不健康",
+			reports: []report{
+				{"proxy-1", map[string]bool{"battle-1": true}},
+				{"proxy-2", map[string]bool{"battle-1": true}},
+				{"proxy-1", map[string]bool{}},
+			},
+			wantChanges:  1,
+			wantStatuses: map[string]core.HealthStatus{"battle-1": core.HealthStatus_UNHEALTHY},
+		},
+		{
+			name: "所有节点恢复后重新健康",
+			reports: []report{
+				{"proxy-1", map[string]bool{"battle-1": true}},
+				{"proxy-2", map[string]bool{"battle-1": true}},
+				{"proxy-1", map[string]bool{}},
+				{"proxy-2", map[string]bool{}},
+			},
+			wantChanges:  2,
+			wantStatuses: map[string]core.HealthStatus{"battle-1": core.HealthStatus_HEALTHY},
+		},
+		{
+			name:         "忽略未知端点",
+			reports:      []report{{"proxy-1", map[string]bool{"battle-9": true}}},
+			wantChanges:  0,
+			wantStatuses: map[string]core.HealthStatus{"battle-9": core.HealthStatus_UNKNOWN},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := 0
+			hc := NewUDPHealthChecker(HealthCheckOptions{Interval: time.Second}, func() { changes++ })
+			hc.SetTargets([]upstreamHost{{ServiceID: "battle-1"}, {ServiceID: "battle-2"}})
+			for _, r := range tt.reports {
+				hc.ReportEnvoyEjections(r.nodeID, r.ejected)
+			}
+			if changes != tt.wantChanges {
+				t.Errorf("onChange = %d, want %d", changes, tt.wantChanges)
+			}
+			for id, want := range tt.wantStatuses {
+				if got := hc.Status(id); got != want {
+					t.Errorf("%s: status = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+
+	// 探测失败与Envoy剔除叠加：剔除解除后仍按探测结果判为不健康
+	hc := NewUDPHealthChecker(HealthCheckOptions{Interval: time.Second, UnhealthyThreshold: 1}, nil)
+	hc.SetTargets([]upstreamHost{{ServiceID: "battle-1"}})
+	hc.ReportEnvoyEjections("proxy-1", map[string]bool{"battle-1": true})
+	if hc.record("battle-1", errors.New("超时")) {
+		t.Error("已被剔除的端点探测失败不应改变对外状态")
+	}
+	hc.ReportEnvoyEjections("proxy-1", map[string]bool{})
+	if hc.Status("battle-1") != core.HealthStatus_UNHEALTHY {
+		t.Error("剔除解除后忽略了探测失败")
+	}
+}
+
+func TestScrapeEjections(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{
+			name:   "只收集异常检测剔除的主机",
+			status: http.StatusOK,
+			body: `{"cluster_statuses":[
+				{"name":"cluster_battle-1_9000","host_statuses":[
+					{"hostname":"battle-1","health_status":{"failed_outlier_check":true,"eds_health_status":"HEALTHY"}},
+					{"hostname":"battle-1-standby","health_status":{"eds_health_status":"HEALTHY"}}]},
+				{"name":"cluster_battle-2_9000","host_statuses":[
+					{"hostname":"battle-2","health_status":{"failed_outlier_check":false}}]},
+				{"name":"xds_control_plane","host_statuses":[
+					{"health_status":{"failed_outlier_check":true}}]}]}`,
+			want: map[string]bool{"battle-1": true},
+		},
+		{
+			name:   "没有集群",
+			status: http.StatusOK,
+			body:   `{}`,
+			want:   map[string]bool{},
+		},
+		{
+			name:    "admin接口返回错误状态",
+			status:  http.StatusServiceUnavailable,
+			wantErr: true,
+		},
+		{
+			name:    "响应不是JSON",
+			status:  http.StatusOK,
+			body:    "cluster_battle-1_9000::10.0.0.1:9000::health_flags::/failed_outlier_check",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/clusters" || r.URL.Query().Get("format") != "json" {
+					t.Errorf("请求 %s", r.URL)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			got, err := scrapeEjections(context.Background(), srv.Client(), srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ejected = %v, want %v", got, tt.want)
+			}
+			for id := range tt.want {
+				if !got[id] {
+					t.Errorf("ejected = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeUDP(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   []byte // nil 表示不回复
+		wantErr bool
+	}{
+		{"PONG", []byte("PONG battle-1"), false},
+		{"响应不匹配", []byte("ERROR"), true},
+		{"无响应超时", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatalf("ListenUDP: %v", err)
+			}
+			defer conn.Close()
+			go func() {
+				buf := make([]byte, 1500)
+				n, addr, err := conn.ReadFromUDP(buf)
+				if err != nil || string(buf[:n]) != "PING" || tt.reply == nil {
+					return
+				}
+				conn.WriteToUDP(tt.reply, addr)
+			}()
+
+			h := upstreamHost{ServiceID: "battle-1", Address: "127.0.0.1", Port: conn.LocalAddr().(*net.UDPAddr).Port}
+			if err := probeUDP(context.Background(), h, 200*time.Millisecond); (err != nil) != tt.wantErr {
+				t.Errorf("probeUDP err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,30 +3,39 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"common"
+	"gameproto"
+
 	consulapi "github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
+	accesslogservice "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
 	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
 	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
 	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
-	"github.com/envoyproxy/go-control-plane/pkg/test/v3"
 
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
@@ -44,6 +53,43 @@ const (
 	grpcMaxConcurrentStreams = 1000000
 )
 
+// SourceAddressMode 向游戏服务器透传客户端源地址的方式
+type SourceAddressMode string
+
+const (
+	// SourceAddressNone 不透传，游戏服务器看到的是Envoy地址
+	SourceAddressNone SourceAddressMode = "none"
+	// SourceAddressTransparent 透明模式(use_original_src_ip)，需要Envoy具备CAP_NET_ADMIN且回程路由经过Envoy
+	SourceAddressTransparent SourceAddressMode = "transparent"
+)
+
+// parseSourceAddressMode 解析源地址透传方式，空字符串视为none
+func parseSourceAddressMode(s string) (SourceAddressMode, error) {
+	switch mode := SourceAddressMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return SourceAddressNone, nil
+	case SourceAddressNone, SourceAddressTransparent:
+		return mode, nil
+	case "proxy_protocol":
+		// upstream_proxy_protocol 传输套接字只作用于TCP连接，udp_proxy 转发的数据报不会带上协议头
+		return "", fmt.Errorf("Envoy的udp_proxy不会为上游数据报附加PROXY协议头，请使用 transparent")
+	default:
+		return "", fmt.Errorf("未知的源地址透传方式: %s", s)
+	}
+}
+
+// ListenerOptions 生成监听器与集群时使用的可选配置
+type ListenerOptions struct {
+	SourceAddressMode SourceAddressMode
+	AccessLog         AccessLogOptions
+	HealthCheck       HealthCheckOptions
+	// MaxDatagramSize Envoy上下游套接字接收数据报的最大长度(max_rx_datagram_size)，0 表示使用Envoy默认值1500，
+	// 需与游戏服务器的 MAX_DATAGRAM_SIZE 一致，超过该长度的数据报会被Envoy截断
+	MaxDatagramSize int
+	// EdgeTLS 面向玩家的加密监听器，Mode 为none时不生成
+	EdgeTLS EdgeTLSOptions
+}
+
 // ControlPlane 控制平面结构体
 type ControlPlane struct {
 	cache   cache.SnapshotCache
@@ -52,16 +98,32 @@ type ControlPlane struct {
 	ctx     context.Context
 	cancel  context.CancelFunc
 	xdsPort uint
+	opts    ListenerOptions
+	als     *AccessLogServer  // 仅在访问日志为grpc模式时创建
+	stats   *StatsAggregator  // 配置了Envoy admin地址时创建
+	health  *UDPHealthChecker // 开启主动健康检查时创建
+	tracker *configTracker
+	certs   *certReloader   // xDS启用TLS时创建
+	auth    *nodeAuthorizer // 要求 node.id 与客户端证书绑定时创建
+	secrets *secretStore    // 开启边缘加密时创建，经SDS下发边缘证书
+	// directory 配置了目录服务端口时创建，每次快照设置成功后更新其路由表
+	directory *Directory
+	// seen 上次发现的服务ID -> 注册标识，用于识别新注册的战斗服，仅在配置更新协程中访问
+	seen map[string]string
+	// refreshCh 健康状态等非Consul事件触发的配置重建请求
+	refreshCh chan struct{}
+	// snapshotSeq 快照序号，保证同一秒内多次重建的快照版本也各不相同
+	snapshotSeq atomic.Uint64
+	// uncheckedStandbys 未开启主动健康检查时构建带热备集群的次数，用于限频告警
+	uncheckedStandbys atomic.Uint64
 }
 
 // NewControlPlane 创建新的控制平面实例
-func NewControlPlane(consulAddr string, xdsPort uint) (*ControlPlane, error) {
-	// 初始化Consul客户端
-	consulConfig := consulapi.DefaultConfig()
-	consulConfig.Address = consulAddr
-	consulClient, err := consulapi.NewClient(consulConfig)
+func NewControlPlane(consulAddr string, xdsPort uint, opts ListenerOptions, xdsTLS XDSTLSOptions) (*ControlPlane, error) {
+	// 初始化Consul客户端，地址与认证配置见 common.NewConsulClient
+	consulClient, err := common.NewConsulClient(consulAddr)
 	if err != nil {
-		return nil, fmt.Errorf("创建Consul客户端失败: %v", err)
+		return nil, err
 	}
 
 	// 创建上下文
@@ -71,27 +133,134 @@ func NewControlPlane(consulAddr string, xdsPort uint) (*ControlPlane, error) {
 	// UDP代理不需要标准的HTTP路由配置，因此禁用一致性检查
 	snapshotCache := cache.NewSnapshotCache(false, cache.IDHash{}, nil)
 
-	// 创建服务器
-	callbacks := &test.Callbacks{}
-	xdsserver := server.NewServer(ctx, snapshotCache, callbacks)
-
 	controlPlane := &ControlPlane{
 		cache:   snapshotCache,
-		server:  xdsserver,
 		consul:  consulClient,
 		ctx:     ctx,
 		cancel:  cancel,
 		xdsPort: xdsPort,
+		opts:    opts,
+		tracker: newConfigTracker(),
+		seen:    make(map[string]string),
+
+		refreshCh: make(chan struct{}, 1),
+	}
+
+	if xdsTLS.Enabled() {
+		certs, err := newCertReloader(xdsTLS)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		controlPlane.certs = certs
+		if xdsTLS.BindNodeID {
+			controlPlane.auth = newNodeAuthorizer()
+		}
+	}
+
+	if opts.EdgeTLS.Mode == EdgeTLSQUIC {
+		secrets, err := newSecretStore(opts.EdgeTLS, controlPlane.requestRefresh)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		controlPlane.secrets = secrets
+	}
+
+	// 创建服务器，通过回调校验客户端身份并跟踪Envoy对快照的确认
+	controlPlane.server = server.NewServer(ctx, snapshotCache, controlPlane.xdsCallbacks())
+
+	if opts.HealthCheck.Interval > 0 {
+		controlPlane.health = NewUDPHealthChecker(opts.HealthCheck, controlPlane.requestRefresh)
+	}
+
+	if opts.AccessLog.Mode == AccessLogGRPC {
+		var sink LogSink
+		if opts.AccessLog.LokiPushURL != "" {
+			sink = NewLokiSink(opts.AccessLog.LokiPushURL)
+		}
+		controlPlane.als = NewAccessLogServer(sink)
 	}
 
 	return controlPlane, nil
 }
 
+// xdsCallbacks 组合xDS回调：绑定 node.id 时先按客户端证书校验请求，再跟踪快照确认
+func (cp *ControlPlane) xdsCallbacks() server.CallbackFuncs {
+	cb := cp.tracker.callbacks()
+	if cp.auth == nil {
+		return cb
+	}
+
+	track, closed := cb.StreamRequestFunc, cb.StreamClosedFunc
+	cb.StreamOpenFunc = func(ctx context.Context, streamID int64, _ string) error {
+		return cp.auth.open(ctx, xdsStream{id: streamID})
+	}
+	cb.StreamClosedFunc = func(streamID int64, node *core.Node) {
+		cp.auth.close(xdsStream{id: streamID})
+		closed(streamID, node)
+	}
+	cb.StreamRequestFunc = func(streamID int64, req *discoverygrpc.DiscoveryRequest) error {
+		if err := cp.auth.check(xdsStream{id: streamID}, req.GetNode()); err != nil {
+			return err
+		}
+		return track(streamID, req)
+	}
+	cb.DeltaStreamOpenFunc = func(ctx context.Context, streamID int64, _ string) error {
+		return cp.auth.open(ctx, xdsStream{delta: true, id: streamID})
+	}
+	cb.DeltaStreamClosedFunc = func(streamID int64, _ *core.Node) {
+		cp.auth.close(xdsStream{delta: true, id: streamID})
+	}
+	cb.StreamDeltaRequestFunc = func(streamID int64, req *discoverygrpc.DeltaDiscoveryRequest) error {
+		return cp.auth.check(xdsStream{delta: true, id: streamID}, req.GetNode())
+	}
+	cb.FetchRequestFunc = func(ctx context.Context, req *discoverygrpc.DiscoveryRequest) error {
+		return cp.auth.checkFetch(ctx, req.GetNode())
+	}
+	return cb
+}
+
+// checkConsulAccess 启动时查询一次服务列表，令牌或TLS配置错误时返回错误；Consul暂不可达不视为错误，由轮询重试
+func (cp *ControlPlane) checkConsulAccess() error {
+	_, _, err := cp.consul.Health().Service("game-server", "", true, nil)
+	if err = common.ConsulAccessError(err); common.IsConsulConfigError(err) {
+		return err
+	}
+	return nil
+}
+
 // Start 启动控制平面
 func (cp *ControlPlane) Start() error {
 	// 启动Consul监听器
 	go cp.watchConsulServices()
 
+	// 启动访问日志转发
+	if cp.als != nil {
+		go cp.als.Run(cp.ctx)
+	}
+
+	// 定期检查xDS证书是否更新
+	if cp.certs != nil {
+		go cp.certs.Run(cp.ctx)
+	}
+
+	// 定期检查边缘证书是否更新，更新后重建快照
+	if cp.secrets != nil {
+		go cp.secrets.Run(cp.ctx)
+	}
+
+	// 启动UDP主动健康检查
+	if cp.health != nil {
+		go cp.health.Run(cp.ctx)
+	}
+
+	// 启动Envoy统计抓取
+	if cp.stats != nil {
+		cp.stats.health = cp.health
+		go cp.stats.Run(cp.ctx)
+	}
+
 	// 启动xDS服务器
 	cp.runXdsServer()
 
@@ -100,7 +269,7 @@ func (cp *ControlPlane) Start() error {
 
 // watchConsulServices 监听Consul服务变化
 func (cp *ControlPlane) watchConsulServices() {
-	log.Println("🔄 开始监听Consul服务变化...")
+	slog.Info("开始监听Consul服务变化")
 
 	// 初始加载服务
 	cp.updateEnvoyConfig()
@@ -112,33 +281,53 @@ func (cp *ControlPlane) watchConsulServices() {
 	for {
 		select {
 		case <-cp.ctx.Done():
-			log.Println("⏹️ 控制平面停止监听")
+			slog.Info("控制平面停止监听")
 			return
 		case <-ticker.C:
 			cp.updateEnvoyConfig()
+		case <-cp.refreshCh:
+			cp.updateEnvoyConfig()
 		}
 	}
 }
 
+// requestRefresh 请求尽快重建配置，已有待处理请求时合并
+func (cp *ControlPlane) requestRefresh() {
+	select {
+	case cp.refreshCh <- struct{}{}:
+	default:
+	}
+}
+
 // updateEnvoyConfig 更新Envoy配置
 func (cp *ControlPlane) updateEnvoyConfig() {
-	log.Println("🔄 更新Envoy配置...")
+	ctx, span := tracer.Start(cp.ctx, "updateEnvoyConfig")
+	defer span.End()
 
-	// 查询所有game-server服务
-	services, _, err := cp.consul.Health().Service("game-server", "", true, nil)
+	// 查询所有game-server服务，排空中的实例在Consul中不是passing，需自行筛选
+	entries, _, err := cp.consul.Health().Service("game-server", "", false, nil)
 	if err != nil {
-		log.Printf("❌ 查询Consul服务失败: %v", err)
+		err = common.ConsulAccessError(err)
+		span.SetStatus(codes.Error, err.Error())
+		slog.Error("查询Consul服务失败", "error", err)
 		return
 	}
-
-	log.Printf("📊 发现 %d 个game-server服务实例", len(services))
+	services := servingServices(entries)
+	span.SetAttributes(attribute.Int("services", len(services)))
 
 	// 构建新的快照
-	snapshot, err := cp.buildSnapshot(services)
+	snapshot, routes, err := cp.buildSnapshot(ctx, services)
 	if err != nil {
-		log.Printf("❌ 构建快照失败: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		slog.Error("构建快照失败", "error", err)
 		return
 	}
+	version := snapshot.GetVersion(resource.ListenerType)
+	span.SetAttributes(attribute.String("snapshot_version", version))
+	logger := slog.With("snapshot_version", version)
+
+	// 先登记新注册的战斗服，避免Envoy的确认早于登记
+	cp.traceNewServices(ctx, services, version)
 
 	// Envoy 拉取配置时使用的 node.id 必须与 SetSnapshot 的 node 一致。go-control-plane 用 request.Node 的 hash 作为 key。
 	// 为 bootstrap 中的 id (envoy_instance_01) 与 docker-compose --service-node (proxy-1) 都设置快照，避免不一致导致 listeners 为空
@@ -158,7 +347,18 @@ func (cp *ControlPlane) updateEnvoyConfig() {
 	// }
 	// #endregion
 	for _, nodeID := range nodeIDs {
-		if err := cp.cache.SetSnapshot(cp.ctx, nodeID, snapshot); err != nil {
+		setCtx, setSpan := tracer.Start(ctx, "SetSnapshot", trace.WithAttributes(
+			attribute.String("node_id", nodeID),
+			attribute.String("snapshot_version", version),
+		))
+		// 在设置快照之前登记，Envoy的确认可能在 SetSnapshot 返回前到达
+		cp.tracker.expectAck(setCtx, nodeID, version)
+		err := cp.cache.SetSnapshot(setCtx, nodeID, snapshot)
+		if err != nil {
+			setSpan.SetStatus(codes.Error, err.Error())
+		}
+		setSpan.End()
+		if err != nil {
 			// #region agent log
 			// dp := os.Getenv("DEBUG_LOG_PATH")
 			// if dp == "" {
@@ -170,10 +370,14 @@ func (cp *ControlPlane) updateEnvoyConfig() {
 			// 	f.Close()
 			// }
 			// #endregion
-			log.Printf("❌ 设置快照失败 (node=%s): %v", nodeID, err)
+			logger.Error("设置快照失败", "node_id", nodeID, "error", err)
 			return
 		}
 	}
+	// 快照设置成功后才更新目录，避免玩家拿到Envoy尚未监听的端口
+	if cp.directory != nil {
+		cp.directory.SetRoutes(routes)
+	}
 	// #region agent log
 	// if f, e := os.OpenFile(debugLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); e == nil {
 	// 	b, _ := json.Marshal(map[string]interface{}{"sessionId": "debug-session", "runId": "post-fix", "hypothesisId": "H3", "location": "main.go:SetSnapshot", "message": "SetSnapshot success", "data": map[string]interface{}{"version": snapshot.GetVersion(resource.ListenerType), "nodeIDs": nodeIDs}, "timestamp": time.Now().UnixMilli()})
@@ -182,59 +386,119 @@ func (cp *ControlPlane) updateEnvoyConfig() {
 	// }
 	// #endregion
 
-	log.Println("✅ Envoy配置更新完成")
+	logger.Info("Envoy配置更新完成", "services", len(services), "node_ids", nodeIDs)
 }
 
-// buildSnapshot 构建配置快照
-func (cp *ControlPlane) buildSnapshot(services []*consulapi.ServiceEntry) (*cache.Snapshot, error) {
-	var clusters []cache_types.Resource
-	var listeners []cache_types.Resource
-
-	// 为每个服务创建集群和监听器
+// traceNewServices 为新注册（或重新注册）的战斗服开始一个传播span。该span以游戏服务器注册时的span为父，
+// 在包含它的快照被Envoy确认时结束，从注册所在的链路即可看到从注册到Envoy开始转发的耗时
+func (cp *ControlPlane) traceNewServices(ctx context.Context, services []*consulapi.ServiceEntry, version string) {
+	updateSpan := trace.SpanFromContext(ctx)
+	seen := make(map[string]string, len(services))
 	for _, service := range services {
-		servicePort := service.Service.Port
-		serviceAddress := service.Service.Address
-
-		// 从元数据中获取外部端口
-		externalPortStr, ok := service.Service.Meta["envoy_external_port"]
-		if !ok {
-			log.Printf("⚠️ 服务 %s 未指定envoy_external_port元数据，跳过", service.Service.ID)
+		id := service.Service.ID
+		registration := service.Service.Meta[common.MetaTraceParent] + service.Service.Meta["registered_at"]
+		seen[id] = registration
+		if prev, ok := cp.seen[id]; ok && prev == registration {
 			continue
 		}
 
-		externalPort, err := strconv.Atoi(externalPortStr)
-		if err != nil {
-			log.Printf("⚠️ 服务 %s 的外部端口格式错误: %s，跳过", service.Service.ID, externalPortStr)
-			continue
+		attrs := []attribute.KeyValue{
+			attribute.String("server_id", id),
+			attribute.String("external_port", service.Service.Meta[metaExternalPort]),
+			attribute.String("snapshot_version", version),
+		}
+		registered := common.RegistrationContext(service.Service.Meta)
+		if registered.IsValid() {
+			updateSpan.AddLink(trace.Link{SpanContext: registered, Attributes: attrs[:1]})
 		}
 
-		// 检查协议是否为UDP
-		protocol, ok := service.Service.Meta["protocol"]
-		if !ok || strings.ToLower(protocol) != "udp" {
-			log.Printf("⚠️ 服务 %s 协议非UDP，跳过", service.Service.ID)
-			continue
+		parent := trace.ContextWithRemoteSpanContext(context.Background(), registered)
+		_, span := tracer.Start(parent, "envoy.propagate",
+			trace.WithAttributes(attrs...),
+			trace.WithLinks(trace.LinkFromContext(ctx)),
+		)
+		cp.tracker.trackService(span, version)
+	}
+	cp.seen = seen
+}
+
+// nextSnapshotVersion 生成快照版本号：定长的递增序号在前，按字符串比较即按构建先后；
+// 附带的时间便于排查，也使控制平面重启后序号重新开始时版本号不会与Envoy已接受的版本重复
+func (cp *ControlPlane) nextSnapshotVersion() string {
+	return fmt.Sprintf("%010d-%s", cp.snapshotSeq.Add(1), time.Now().Format("20060102T150405"))
+}
+
+// buildSnapshot 构建配置快照，同时返回目录服务的路由表，只包含至少有一个健康且未在排空的端点的战斗服
+func (cp *ControlPlane) buildSnapshot(ctx context.Context, services []*consulapi.ServiceEntry) (*cache.Snapshot, []directoryRoute, error) {
+	_, span := tracer.Start(ctx, "buildSnapshot")
+	defer span.End()
+
+	var clusters []cache_types.Resource
+	var listeners []cache_types.Resource
+	var secrets []cache_types.Resource
+	var routes []directoryRoute
+	if cp.secrets != nil {
+		secrets = cp.secrets.resources()
+	}
+
+	version := cp.nextSnapshotVersion()
+	logger := slog.With("snapshot_version", version)
+	span.SetAttributes(attribute.String("snapshot_version", version))
+
+	servers := groupBattleServers(services)
+
+	if cp.health != nil {
+		var hosts []upstreamHost
+		for _, server := range servers {
+			hosts = append(hosts, server.Primary)
+			hosts = append(hosts, server.Standbys...)
 		}
+		cp.health.SetTargets(hosts)
+	}
 
-		clusterName := fmt.Sprintf("cluster_%s_%d", service.Service.ID, externalPort)
+	// 为每个战斗服创建集群和监听器，热备实例并入主实例的集群
+	for _, server := range servers {
+		externalPort := server.ExternalPort
+		clusterName := fmt.Sprintf("cluster_%s_%d", server.ServiceID, externalPort)
 		listenerName := fmt.Sprintf("listener_%d", externalPort)
 
 		// 创建集群
-		clusterResource := cp.createCluster(clusterName, serviceAddress, servicePort)
+		clusterResource, err := cp.createCluster(clusterName, server.Primary, server.Standbys)
+		if err != nil {
+			logger.Warn("创建集群失败", "server_id", server.ServiceID, "external_port", externalPort, "error", err)
+			continue
+		}
 		clusters = append(clusters, clusterResource)
 
 		// 创建UDP监听器
-		listenerResource, err := cp.createUDPListener(listenerName, uint32(externalPort), clusterName)
+		listenerResource, err := cp.createUDPListener(listenerName, uint32(externalPort), clusterName, server.ServiceID)
 		if err != nil {
-			log.Printf("⚠️ 创建UDP监听器失败: %v", err)
+			logger.Warn("创建UDP监听器失败", "server_id", server.ServiceID, "external_port", externalPort, "error", err)
 			continue
 		}
 		listeners = append(listeners, listenerResource)
+		route := directoryRoute{ServerID: server.ServiceID, Port: externalPort}
+
+		// 加密的QUIC监听器与明文监听器共用集群，战斗服无需感知
+		if cp.secrets != nil {
+			quicPort := cp.secrets.quicPort(uint32(externalPort))
+			quicListener, err := cp.secrets.createQUICListener(fmt.Sprintf("listener_%d_quic", quicPort), quicPort, clusterName, server.ServiceID)
+			if err != nil {
+				logger.Warn("创建QUIC监听器失败", "server_id", server.ServiceID, "external_port", externalPort, "error", err)
+			} else {
+				listeners = append(listeners, quicListener)
+				route.QUICPort = int(quicPort)
+			}
+		}
+		if cp.routable(server) {
+			routes = append(routes, route)
+		}
 
-		log.Printf("📝 为服务 %s 创建配置: 外部端口 %d -> 内部 %s:%d",
-			service.Service.ID, externalPort, serviceAddress, servicePort)
+		logger.Debug("创建战斗服配置", "server_id", server.ServiceID, "external_port", externalPort,
+			"upstream", net.JoinHostPort(server.Primary.Address, strconv.Itoa(server.Primary.Port)), "standbys", len(server.Standbys))
 	}
 
-	// 构建快照 - 仅包含集群与监听器。UDP 代理不需要 RouteConfiguration；
+	// 构建快照 - 包含集群、监听器与边缘证书。UDP 代理不需要 RouteConfiguration，QUIC监听器的路由内联在连接管理器中；
 	// 若提供 Route 但无 listener 引用，go-control-plane 一致性检查会报错：referenced 0 != resources 1
 	// #region agent log
 	// dp := os.Getenv("DEBUG_LOG_PATH")
@@ -249,17 +513,35 @@ func (cp *ControlPlane) buildSnapshot(services []*consulapi.ServiceEntry) (*cach
 	// #endregion
 
 	snapshot, err := cache.NewSnapshot(
-		time.Now().Format("2006-01-02T15:04:05"), // 版本号使用当前时间
+		version,
 		map[resource.Type][]cache_types.Resource{
 			resource.ClusterType:  clusters,
 			resource.ListenerType: listeners,
+			resource.SecretType:   secrets,
 		},
 	)
 	if err != nil {
-		return nil, fmt.Errorf("创建快照失败: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, nil, fmt.Errorf("创建快照失败: %v", err)
 	}
+	span.SetAttributes(attribute.Int("clusters", len(clusters)), attribute.Int("listeners", len(listeners)), attribute.Int("secrets", len(secrets)))
+	logger.Info("构建快照", "clusters", len(clusters), "listeners", len(listeners), "secrets", len(secrets))
 
-	return snapshot, nil
+	return snapshot, routes, nil
+}
+
+// routable 战斗服是否至少有一个端点未在排空，且未被主动健康检查或Envoy异常检测判为不健康。
+// 全部端点不可用时Envoy仍保留监听器，但不再把该战斗服告诉新玩家
+func (cp *ControlPlane) routable(server battleServer) bool {
+	for _, h := range append([]upstreamHost{server.Primary}, server.Standbys...) {
+		if h.Draining {
+			continue
+		}
+		if cp.health == nil || cp.health.Status(h.ServiceID) != core.HealthStatus_UNHEALTHY {
+			return true
+		}
+	}
+	return false
 }
 
 // isIP 判断是否为 IP 地址（否则视为主机名，需用 STRICT_DNS）
@@ -267,51 +549,120 @@ func isIP(s string) bool {
 	return net.ParseIP(s) != nil
 }
 
-// createCluster 创建集群资源。主机名（如 game-server-1）用 STRICT_DNS，IP 用 STATIC
-func (cp *ControlPlane) createCluster(name, address string, port int) *cluster.Cluster {
+// createCluster 创建集群资源。主机名（如 game-server-1）用 STRICT_DNS，全部为 IP 时用 STATIC。
+// 热备端点放在优先级1，主实例被健康检查或异常检测剔除后流量自动切到热备，客户端无需更换端口
+func (cp *ControlPlane) createCluster(name string, primary upstreamHost, standbys []upstreamHost) (*cluster.Cluster, error) {
 	typ := cluster.Cluster_STATIC
-	if !isIP(address) {
-		typ = cluster.Cluster_STRICT_DNS
+	for _, h := range append([]upstreamHost{primary}, standbys...) {
+		if !isIP(h.Address) {
+			typ = cluster.Cluster_STRICT_DNS
+			break
+		}
 	}
-	return &cluster.Cluster{
+
+	localities := []*endpoint.LocalityLbEndpoints{{
+		Priority:    0,
+		LbEndpoints: []*endpoint.LbEndpoint{cp.udpLbEndpoint(primary)},
+	}}
+	if len(standbys) > 0 {
+		standbyEndpoints := make([]*endpoint.LbEndpoint, 0, len(standbys))
+		for _, h := range standbys {
+			standbyEndpoints = append(standbyEndpoints, cp.udpLbEndpoint(h))
+		}
+		localities = append(localities, &endpoint.LocalityLbEndpoints{
+			Priority:    1,
+			LbEndpoints: standbyEndpoints,
+		})
+	}
+
+	c := &cluster.Cluster{
 		Name:                 name,
 		ConnectTimeout:       durationpb.New(5 * time.Second),
 		ClusterDiscoveryType: &cluster.Cluster_Type{Type: typ},
 		LbPolicy:             cluster.Cluster_ROUND_ROBIN,
 		LoadAssignment: &endpoint.ClusterLoadAssignment{
 			ClusterName: name,
-			Endpoints: []*endpoint.LocalityLbEndpoints{{
-				LbEndpoints: []*endpoint.LbEndpoint{{
-					HostIdentifier: &endpoint.LbEndpoint_Endpoint{
-						Endpoint: &endpoint.Endpoint{
-							Address: &core.Address{
-								Address: &core.Address_SocketAddress{
-									SocketAddress: &core.SocketAddress{
-										Protocol: core.SocketAddress_UDP,
-										Address:  address,
-										PortSpecifier: &core.SocketAddress_PortValue{
-											PortValue: uint32(port),
-										},
-									},
-								},
+			Endpoints:   localities,
+		},
+	}
+
+	// UDP没有5xx之类的上游错误，异常检测只能依据本地错误剔除主实例。udp_proxy 不会把上游收发失败
+	// 上报给异常检测器，因此这里的配置对纯UDP转发不会触发剔除，主实例故障时切换到热备依赖主动健康检查
+	// 下发的 UNHEALTHY 或主实例从Consul消失
+	if len(standbys) > 0 {
+		if cp.health == nil {
+			if n := cp.uncheckedStandbys.Add(1); n&(n-1) == 0 {
+				slog.Warn("集群配置了热备但未开启UDP主动健康检查，主实例故障时Envoy不会切换到热备",
+					"cluster", name, "count", n)
+			}
+		}
+		c.OutlierDetection = &cluster.OutlierDetection{
+			SplitExternalLocalOriginErrors:         true,
+			ConsecutiveLocalOriginFailure:          wrapperspb.UInt32(3),
+			EnforcingConsecutiveLocalOriginFailure: wrapperspb.UInt32(100),
+			Interval:                               durationpb.New(5 * time.Second),
+			BaseEjectionTime:                       durationpb.New(30 * time.Second),
+			MaxEjectionPercent:                     wrapperspb.UInt32(100),
+		}
+	}
+
+	return c, nil
+}
+
+// udpLbEndpoint 构建单个UDP上游端点。排空中的端点标记为 DRAINING，Envoy不再为其选择新会话，
+// 已建立的会话在没有其他健康端点时继续转发；开启主动健康检查时附带探测得到的健康状态
+func (cp *ControlPlane) udpLbEndpoint(h upstreamHost) *endpoint.LbEndpoint {
+	lbEndpoint := &endpoint.LbEndpoint{
+		HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+			Endpoint: &endpoint.Endpoint{
+				Hostname: h.ServiceID,
+				Address: &core.Address{
+					Address: &core.Address_SocketAddress{
+						SocketAddress: &core.SocketAddress{
+							Protocol: core.SocketAddress_UDP,
+							Address:  h.Address,
+							PortSpecifier: &core.SocketAddress_PortValue{
+								PortValue: uint32(h.Port),
 							},
 						},
 					},
-				}},
-			}},
+				},
+			},
 		},
 	}
+	switch {
+	case h.Draining:
+		lbEndpoint.HealthStatus = core.HealthStatus_DRAINING
+	case cp.health != nil:
+		lbEndpoint.HealthStatus = cp.health.Status(h.ServiceID)
+	}
+	return lbEndpoint
 }
 
 // createUDPListener 创建UDP监听器
-func (cp *ControlPlane) createUDPListener(name string, port uint32, clusterName string) (*listener.Listener, error) {
+func (cp *ControlPlane) createUDPListener(name string, port uint32, clusterName, serviceID string) (*listener.Listener, error) {
 	// 创建UDP代理过滤器
 	udpFilter := &udpproxy.UdpProxyConfig{
-		StatPrefix: fmt.Sprintf("udp_stats_%d", port),
+		StatPrefix: battleStatPrefix(serviceID, port),
 		RouteSpecifier: &udpproxy.UdpProxyConfig_Cluster{
 			Cluster: clusterName,
 		},
 		IdleTimeout: durationpb.New(60 * time.Second), // 游戏场景的合理超时
+		// 透明模式下以客户端原始IP作为上游源地址，游戏服务器可直接从ReadFromUDP获取
+		UseOriginalSrcIp: cp.opts.SourceAddressMode == SourceAddressTransparent,
+	}
+
+	if err := cp.applyAccessLogs(udpFilter, name, serviceID); err != nil {
+		return nil, err
+	}
+
+	var udpListenerConfig *listener.UdpListenerConfig
+	if cp.opts.MaxDatagramSize > 0 {
+		socketConfig := &core.UdpSocketConfig{
+			MaxRxDatagramSize: wrapperspb.UInt64(uint64(cp.opts.MaxDatagramSize)),
+		}
+		udpFilter.UpstreamSocketConfig = socketConfig
+		udpListenerConfig = &listener.UdpListenerConfig{DownstreamSocketConfig: socketConfig}
 	}
 
 	anyFilter, err := anypb.New(udpFilter)
@@ -333,6 +684,7 @@ func (cp *ControlPlane) createUDPListener(name string, port uint32, clusterName
 				},
 			},
 		},
+		UdpListenerConfig: udpListenerConfig,
 		ListenerFilters: []*listener.ListenerFilter{{
 			Name: "envoy.filters.udp_listener.udp_proxy",
 			ConfigType: &listener.ListenerFilter_TypedConfig{
@@ -358,6 +710,10 @@ func (cp *ControlPlane) runXdsServer() {
 		}),
 	)
 
+	if cp.certs != nil {
+		grpcOptions = append(grpcOptions, grpc.Creds(cp.certs.credentials()))
+	}
+
 	grpcServer := grpc.NewServer(grpcOptions...)
 
 	// 注册xDS服务
@@ -366,26 +722,47 @@ func (cp *ControlPlane) runXdsServer() {
 	clusterservice.RegisterClusterDiscoveryServiceServer(grpcServer, cp.server)
 	routeservice.RegisterRouteDiscoveryServiceServer(grpcServer, cp.server)
 	listenerservice.RegisterListenerDiscoveryServiceServer(grpcServer, cp.server)
+	secretservice.RegisterSecretDiscoveryServiceServer(grpcServer, cp.server)
+
+	// 访问日志服务与xDS共用端口，Envoy可直接复用 xds_control_plane 集群
+	if cp.als != nil {
+		accesslogservice.RegisterAccessLogServiceServer(grpcServer, cp.als)
+	}
 
 	// 监听端口
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cp.xdsPort))
 	if err != nil {
-		log.Fatalf("❌ 无法监听端口 %d: %v", cp.xdsPort, err)
+		slog.Error("无法监听xDS端口", "port", cp.xdsPort, "error", err)
+		os.Exit(1)
 	}
 
-	log.Printf("🚀 控制平面启动，监听xDS端口: %d", cp.xdsPort)
+	slog.Info("控制平面启动", "xds_port", cp.xdsPort, "tls", cp.certs != nil, "bind_node_id", cp.auth != nil)
 
 	if err = grpcServer.Serve(lis); err != nil {
-		log.Printf("❌ gRPC服务器错误: %v", err)
+		slog.Error("gRPC服务器错误", "error", err)
 	}
 }
 
 // Stop 停止控制平面
 func (cp *ControlPlane) Stop() {
-	log.Println("🛑 正在停止控制平面...")
+	slog.Info("正在停止控制平面")
 	cp.cancel()
 }
 
+// MetricsHandler 输出按战斗服聚合的Envoy会话指标，未配置Envoy admin地址时为空
+func (cp *ControlPlane) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if cp.stats != nil {
+		cp.stats.MetricsHandler(w, r)
+	}
+	if cp.health != nil {
+		cp.health.writeMetrics(w)
+	}
+	if cp.directory != nil {
+		cp.directory.writeMetrics(w)
+	}
+}
+
 // HealthHandler 健康检查处理器
 func (cp *ControlPlane) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -393,7 +770,37 @@ func (cp *ControlPlane) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, `{"status": "healthy", "component": "control-plane", "timestamp": "`+time.Now().Format(time.RFC3339)+`"}`)
 }
 
+// envDuration 读取时长类型的环境变量，未设置或格式错误时返回默认值
+func envDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// envInt 读取整数类型的环境变量，未设置或格式错误时返回默认值
+func envInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
 func main() {
+	// 结构化日志，LOG_LEVEL/LOG_FORMAT 控制级别与输出格式
+	slog.SetDefault(common.NewLogger("control-plane"))
+
+	// 链路追踪，OTEL_TRACES_EXPORTER 为空时不采集
+	shutdownTracing, err := common.SetupTracing(context.Background(), "control-plane")
+	if err != nil {
+		slog.Error("初始化链路追踪失败", "error", err)
+		os.Exit(1)
+	}
+
 	// 从环境变量获取配置
 	consulAddr := os.Getenv("CONSUL_ADDR")
 	if consulAddr == "" {
@@ -416,34 +823,152 @@ func main() {
 		}
 	}
 
-	log.Printf("🎮 启动游戏服务器动态UDP代理控制平面")
-	log.Printf("📍 Consul地址: %s", consulAddr)
-	log.Printf("📍 xDS端口: %d", xdsPort)
-	log.Printf("📍 健康检查端口: %d", healthPort)
+	sourceAddressMode, err := parseSourceAddressMode(os.Getenv("SOURCE_ADDRESS_MODE"))
+	if err != nil {
+		slog.Error("配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	accessLogMode, err := parseAccessLogMode(os.Getenv("ACCESS_LOG_MODE"))
+	if err != nil {
+		slog.Error("配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	adminEndpoints, err := parseAdminEndpoints(os.Getenv("ENVOY_ADMIN_ENDPOINTS"))
+	if err != nil {
+		slog.Error("配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	// xDS TLS：配置客户端CA即要求mTLS，默认同时把 node.id 绑定到客户端证书
+	xdsTLS := XDSTLSOptions{
+		CertFile:       os.Getenv("XDS_TLS_CERT"),
+		KeyFile:        os.Getenv("XDS_TLS_KEY"),
+		ClientCAFile:   os.Getenv("XDS_TLS_CLIENT_CA"),
+		ReloadInterval: envDuration("XDS_TLS_RELOAD_INTERVAL", defaultXDSTLSReloadInterval),
+	}
+	xdsTLS.BindNodeID = xdsTLS.ClientCAFile != ""
+	if v, err := strconv.ParseBool(os.Getenv("XDS_TLS_BIND_NODE_ID")); err == nil {
+		xdsTLS.BindNodeID = v
+	}
+
+	edgeTLSMode, err := parseEdgeTLSMode(os.Getenv("EDGE_TLS_MODE"))
+	if err != nil {
+		slog.Error("配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	healthCheck := HealthCheckOptions{
+		Interval:           envDuration("UDP_HEALTH_CHECK_INTERVAL", 0),
+		Timeout:            envDuration("UDP_HEALTH_CHECK_TIMEOUT", time.Second),
+		UnhealthyThreshold: envInt("UDP_HEALTH_CHECK_UNHEALTHY_THRESHOLD", 3),
+		HealthyThreshold:   envInt("UDP_HEALTH_CHECK_HEALTHY_THRESHOLD", 2),
+	}
+
+	slog.Info("启动游戏服务器动态UDP代理控制平面",
+		"consul_addr", consulAddr,
+		"xds_port", xdsPort,
+		"health_port", healthPort,
+		"source_address_mode", sourceAddressMode,
+		"access_log_mode", accessLogMode,
+		"envoy_admin_endpoints", len(adminEndpoints),
+		"udp_health_check_interval", healthCheck.Interval,
+		"edge_tls_mode", edgeTLSMode,
+	)
 
 	// 创建控制平面实例
-	controlPlane, err := NewControlPlane(consulAddr, xdsPort)
+	controlPlane, err := NewControlPlane(consulAddr, xdsPort, ListenerOptions{
+		SourceAddressMode: sourceAddressMode,
+		AccessLog: AccessLogOptions{
+			Mode:          accessLogMode,
+			Path:          os.Getenv("ACCESS_LOG_PATH"),
+			GRPCCluster:   os.Getenv("ACCESS_LOG_GRPC_CLUSTER"),
+			FlushInterval: envDuration("ACCESS_LOG_FLUSH_INTERVAL", 0),
+			LokiPushURL:   os.Getenv("LOKI_PUSH_URL"),
+		},
+		HealthCheck:     healthCheck,
+		MaxDatagramSize: envInt("MAX_DATAGRAM_SIZE", 0),
+		EdgeTLS: EdgeTLSOptions{
+			Mode:           edgeTLSMode,
+			CertFile:       os.Getenv("EDGE_TLS_CERT"),
+			KeyFile:        os.Getenv("EDGE_TLS_KEY"),
+			SecretName:     os.Getenv("EDGE_TLS_SECRET_NAME"),
+			SDSCluster:     os.Getenv("EDGE_TLS_SDS_CLUSTER"),
+			PortOffset:     envInt("EDGE_QUIC_PORT_OFFSET", defaultEdgeQUICOffset),
+			ReloadInterval: envDuration("EDGE_TLS_RELOAD_INTERVAL", defaultEdgeTLSInterval),
+		},
+	}, xdsTLS)
 	if err != nil {
-		log.Fatalf("❌ 创建控制平面失败: %v", err)
+		slog.Error("创建控制平面失败", "error", err)
+		os.Exit(1)
+	}
+	if err := controlPlane.checkConsulAccess(); err != nil {
+		slog.Error("无法访问Consul", "error", err)
+		os.Exit(1)
+	}
+	if len(adminEndpoints) > 0 {
+		controlPlane.stats = NewStatsAggregator(adminEndpoints)
+	}
+
+	// 目录服务：玩家按战斗服ID查询Envoy上的公网地址与端口，配置 TICKET_KEYS 时同时签发会话票据
+	if directoryPort := envInt("DIRECTORY_PORT", 0); directoryPort > 0 {
+		directoryOpts := DirectoryOptions{
+			PublicHost: os.Getenv("DIRECTORY_PUBLIC_HOST"),
+			TicketTTL:  envDuration("DIRECTORY_TICKET_TTL", defaultDirectoryTicketTTL),
+		}
+		if v := os.Getenv("TICKET_KEYS"); v != "" {
+			keys, err := gameproto.ParseTicketKeys(v)
+			if err != nil {
+				slog.Error("配置错误", "error", err)
+				os.Exit(1)
+			}
+			directoryOpts.TicketKeys = keys
+		}
+		// PLAYER_TOKEN_KEYS 校验登录服务签发的玩家凭证，应与 TICKET_KEYS 使用不同的密钥
+		if v := os.Getenv("PLAYER_TOKEN_KEYS"); v != "" {
+			keys, err := gameproto.ParseTicketKeys(v)
+			if err != nil {
+				slog.Error("配置错误", "error", fmt.Errorf("PLAYER_TOKEN_KEYS: %v", err))
+				os.Exit(1)
+			}
+			directoryOpts.PlayerKeys = keys
+		}
+		directoryOpts.Token = os.Getenv("DIRECTORY_TOKEN")
+		if directoryOpts.TicketKeys != nil && directoryOpts.Token == "" && directoryOpts.PlayerKeys == nil {
+			slog.Error("配置错误", "error", "目录服务签发票据时必须设置 DIRECTORY_TOKEN 或 PLAYER_TOKEN_KEYS，否则任何人都能领取有效票据")
+			os.Exit(1)
+		}
+		controlPlane.directory = NewDirectory(directoryOpts)
+
+		go func() {
+			addr := fmt.Sprintf("0.0.0.0:%d", directoryPort)
+			slog.Info("目录服务启动", "port", directoryPort, "tickets", directoryOpts.TicketKeys != nil,
+				"backend_auth", directoryOpts.Token != "", "player_auth", directoryOpts.PlayerKeys != nil)
+			if err := http.ListenAndServe(addr, controlPlane.directory.Handler()); err != nil {
+				slog.Error("目录服务错误", "error", err)
+			}
+		}()
 	}
 
 	// 启动健康检查服务器
 	go func() {
 		http.HandleFunc("/health", controlPlane.HealthHandler)
 		http.HandleFunc("/ready", controlPlane.HealthHandler)
+		http.HandleFunc("/metrics", controlPlane.MetricsHandler)
 
 		addr := fmt.Sprintf("0.0.0.0:%d", healthPort)
-		log.Printf("🏥 健康检查服务器启动，监听端口: %d", healthPort)
+		slog.Info("健康检查服务器启动", "port", healthPort)
 
 		if err := http.ListenAndServe(addr, nil); err != nil {
-			log.Printf("⚠️ 健康检查服务器错误: %v", err)
+			slog.Error("健康检查服务器错误", "error", err)
 		}
 	}()
 
 	// 启动控制平面
 	go func() {
 		if err := controlPlane.Start(); err != nil {
-			log.Printf("❌ 控制平面启动失败: %v", err)
+			slog.Error("控制平面启动失败", "error", err)
 		}
 	}()
 
@@ -452,10 +977,17 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	<-sigChan
-	log.Println("🛑 收到中断信号，正在关闭...")
+	slog.Info("收到中断信号，正在关闭")
 
 	// 停止控制平面
 	controlPlane.Stop()
 
-	log.Println("✅ 控制平面已关闭")
+	// 发送缓冲中的span
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Warn("关闭链路追踪失败", "error", err)
+	}
+
+	slog.Info("控制平面已关闭")
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+func TestNextSnapshotVersionMonotonic(t *testing.T) {
+	cp := &ControlPlane{}
+	prev := cp.nextSnapshotVersion()
+	// 同一秒内的连续重建也必须得到不同且递增的版本，refreshCh 触发的重建与ACK匹配依赖这一点
+	for i := 0; i < 1000; i++ {
+		v := cp.nextSnapshotVersion()
+		if v <= prev {
+			t.Fatalf("版本 %q 不大于上一个版本 %q", v, prev)
+		}
+		prev = v
+	}
+}
+
+func withChecks(entry *consulapi.ServiceEntry, checks ...*consulapi.HealthCheck) *consulapi.ServiceEntry {
+	entry.Checks = checks
+	return entry
+}
+
+func healthCheck(id, status string) *consulapi.HealthCheck {
+	return &consulapi.HealthCheck{CheckID: id, Status: status}
+}
+
+// TestDrainingServerStaysInSnapshot 维护模式中的战斗服（优雅关闭）保留集群与监听器、端点标记为 DRAINING，
+// 只从目录中移除；其他检查失败的实例不下发
+func TestDrainingServerStaysInSnapshot(t *testing.T) {
+	t.Setenv("ENVOY_NODE_ID", "")
+	passing := healthCheck("service:battle", consulapi.HealthPassing)
+	entries := []*consulapi.ServiceEntry{
+		withChecks(battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil), passing),
+		withChecks(battleEntry("battle-2", "10.0.0.2", 9000, "10001", nil), passing,
+			healthCheck(consulapi.ServiceMaintPrefix+"battle-2", consulapi.HealthCritical)),
+		withChecks(battleEntry("battle-3", "10.0.0.3", 9000, "10002", nil), passing,
+			healthCheck(consulapi.NodeMaint, consulapi.HealthCritical)),
+		withChecks(battleEntry("battle-4", "10.0.0.4", 9000, "10003", nil),
+			healthCheck("service:battle", consulapi.HealthCritical)),
+		withChecks(battleEntry("battle-5", "10.0.0.5", 9000, "10004", nil), passing,
+			healthCheck(consulapi.ServiceMaintPrefix+"battle-5", consulapi.HealthCritical),
+			healthCheck("service:battle", consulapi.HealthCritical)),
+	}
+	cp, err := NewControlPlane(fakeConsul(t, entries), 0, ListenerOptions{}, XDSTLSOptions{})
+	if err != nil {
+		t.Fatalf("NewControlPlane: %v", err)
+	}
+	defer cp.cancel()
+	cp.directory = NewDirectory(DirectoryOptions{})
+
+	cp.updateEnvoyConfig()
+
+	snapshot, err := cp.cache.GetSnapshot("proxy-1")
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	got := make(map[string]core.HealthStatus)
+	for _, r := range snapshot.GetResources(resource.ClusterType) {
+		c := r.(*cluster.Cluster)
+		for _, locality := range c.LoadAssignment.Endpoints {
+			for _, lb := range locality.LbEndpoints {
+				got[lb.GetEndpoint().Hostname] = lb.HealthStatus
+			}
+		}
+	}
+	want := map[string]core.HealthStatus{
+		"battle-1": core.HealthStatus_UNKNOWN,
+		"battle-2": core.HealthStatus_DRAINING,
+		"battle-3": core.HealthStatus_DRAINING,
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("endpoints = %v, want %v", got, want)
+	}
+	if n := len(snapshot.GetResources(resource.ListenerType)); n != 3 {
+		t.Errorf("listeners = %d, want 3", n)
+	}
+	if _, ok := cp.directory.routes["battle-1"]; !ok || len(cp.directory.routes) != 1 {
+		t.Errorf("directory = %v, want only battle-1", cp.directory.routes)
+	}
+}
+
+// TestDrainingPrimaryWithStandby 主实例排空时仍是集群的主端点，热备健康则战斗服继续出现在目录中
+func TestDrainingPrimaryWithStandby(t *testing.T) {
+	maint := healthCheck(consulapi.ServiceMaintPrefix+"battle-1", consulapi.HealthCritical)
+	tests := []struct {
+		name      string
+		entries   []*consulapi.ServiceEntry
+		wantRoute bool
+	}{
+		{"只有排空的主实例", []*consulapi.ServiceEntry{
+			withChecks(battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil), maint),
+		}, false},
+		{"热备健康", []*consulapi.ServiceEntry{
+			withChecks(battleEntry("battle-1", "10.0.0.1", 9000, "10000", nil), maint),
+			battleEntry("battle-1-standby", "10.0.0.2", 9000, "10000", map[string]string{metaStandbyFor: "battle-1"}),
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &ControlPlane{}
+			servers := groupBattleServers(servingServices(tt.entries))
+			if len(servers) != 1 || servers[0].PromotedFrom != "" || !servers[0].Primary.Draining {
+				t.Fatalf("servers = %+v", servers)
+			}
+			if got := cp.routable(servers[0]); got != tt.wantRoute {
+				t.Errorf("routable = %v, want %v", got, tt.wantRoute)
+			}
+		})
+	}
+}
+
+// TestCreateClusterStandbys 热备作为低优先级端点放入主实例的集群；未开启主动健康检查时计入告警次数，
+// 因为 udp_proxy 不会触发异常检测剔除，主实例故障时无法切换
+func TestCreateClusterStandbys(t *testing.T) {
+	primary := upstreamHost{ServiceID: "battle-1", Address: "10.0.0.1", Port: 9000}
+	standby := upstreamHost{ServiceID: "battle-1-standby", Address: "10.0.0.2", Port: 9000}
+	tests := []struct {
+		name          string
+		standbys      []upstreamHost
+		healthCheck   bool
+		wantPriority  int
+		wantOutlier   bool
+		wantUnchecked uint64
+	}{
+		{"无热备", nil, false, 1, false, 0},
+		{"热备且开启主动健康检查", []upstreamHost{standby}, true, 2, true, 0},
+		{"热备但未开启主动健康检查", []upstreamHost{standby}, false, 2, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &ControlPlane{}
+			if tt.healthCheck {
+				cp.health = NewUDPHealthChecker(HealthCheckOptions{Interval: time.Second}, nil)
+			}
+			c, err := cp.createCluster("cluster_battle-1_9000", primary, tt.standbys)
+			if err != nil {
+				t.Fatalf("createCluster: %v", err)
+			}
+			localities := c.GetLoadAssignment().GetEndpoints()
+			if len(localities) != tt.wantPriority {
+				t.Fatalf("localities = %d, want %d", len(localities), tt.wantPriority)
+			}
+			for i, l := range localities {
+				if l.Priority != uint32(i) {
+					t.Errorf("locality %d priority = %d", i, l.Priority)
+				}
+			}
+			if (c.OutlierDetection != nil) != tt.wantOutlier {
+				t.Errorf("outlier detection = %v, want %v", c.OutlierDetection, tt.wantOutlier)
+			}
+			if got := cp.uncheckedStandbys.Load(); got != tt.wantUnchecked {
+				t.Errorf("unchecked standbys = %d, want %d", got, tt.wantUnchecked)
+			}
+		})
+	}
+}
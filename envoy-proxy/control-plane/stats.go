@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsScrapeInterval = 15 * time.Second
+	statsScrapeTimeout  = 5 * time.Second
+
+	// battleStatPrefix 前缀的udp_proxy统计形如 udp.battle_<serviceID>_<port>.<stat>
+	battleStatPrefixHead = "battle_"
+)
+
+// battleStatPrefix 生成包含ServiceID的udp_proxy统计前缀。'.' 与 ':' 会破坏Envoy统计名分段，
+// ServiceID 中字母、数字与 '_' 以外的字节转义为 -XX（十六进制），parseBattleStatName 可还原出原始ServiceID
+func battleStatPrefix(serviceID string, port uint32) string {
+	return fmt.Sprintf("%s%s_%d", battleStatPrefixHead, escapeStatSegment(serviceID), port)
+}
+
+func escapeStatSegment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "-%02x", c)
+	}
+	return b.String()
+}
+
+func unescapeStatSegment(s string) (string, bool) {
+	if !strings.Contains(s, "-") {
+		return s, true
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '-' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", false
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", false
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), true
+}
+
+// parseBattleStatName 从 udp.battle_<serviceID>_<port>.<stat> 中解析出ServiceID、端口与统计名
+func parseBattleStatName(name string) (serviceID, port, stat string, ok bool) {
+	rest, found := strings.CutPrefix(name, "udp."+battleStatPrefixHead)
+	if !found {
+		return "", "", "", false
+	}
+	dot := strings.LastIndexByte(rest, '.')
+	if dot < 0 {
+		return "", "", "", false
+	}
+	prefix, stat := rest[:dot], rest[dot+1:]
+	sep := strings.LastIndexByte(prefix, '_')
+	if sep <= 0 {
+		return "", "", "", false
+	}
+	port = prefix[sep+1:]
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", "", false
+	}
+	serviceID, ok = unescapeStatSegment(prefix[:sep])
+	if !ok {
+		return "", "", "", false
+	}
+	return serviceID, port, stat, true
+}
+
+// battleMetric Envoy udp_proxy 统计到Prometheus指标的映射
+type battleMetric struct {
+	name string
+	typ  string
+	help string
+}
+
+var battleMetrics = map[string]battleMetric{
+	"downstream_sess_active":       {"battle_udp_sessions_active", "gauge", "当前活跃的客户端会话数"},
+	"downstream_sess_total":        {"battle_udp_sessions_total", "counter", "累计创建的客户端会话数"},
+	"downstream_sess_rx_datagrams": {"battle_udp_rx_datagrams_total", "counter", "从客户端收到的数据报数"},
+	"downstream_sess_rx_bytes":     {"battle_udp_rx_bytes_total", "counter", "从客户端收到的字节数"},
+	"downstream_sess_rx_errors":    {"battle_udp_rx_errors_total", "counter", "接收客户端数据报的错误数"},
+	"downstream_sess_tx_datagrams": {"battle_udp_tx_datagrams_total", "counter", "发往客户端的数据报数"},
+	"downstream_sess_tx_bytes":     {"battle_udp_tx_bytes_total", "counter", "发往客户端的字节数"},
+	"downstream_sess_tx_errors":    {"battle_udp_tx_errors_total", "counter", "发往客户端数据报的错误数"},
+	"downstream_sess_no_route":     {"battle_udp_no_route_total", "counter", "找不到上游集群而丢弃的数据报数"},
+	"idle_timeout":                 {"battle_udp_idle_timeout_total", "counter", "因空闲超时被回收的会话数"},
+}
+
+// battleSample 单个战斗服的单项统计值
+type battleSample struct {
+	nodeID    string
+	serviceID string
+	port      string
+	stat      string
+	value     float64
+}
+
+// nodeScrapeState 每个Envoy节点的抓取状态
+type nodeScrapeState struct {
+	up      bool
+	errors  uint64
+	samples []battleSample
+}
+
+// StatsAggregator 定期抓取各Envoy节点admin接口的 /stats，按战斗服重新发布为Prometheus指标
+type StatsAggregator struct {
+	endpoints map[string]string // nodeID -> admin地址，如 http://envoy-proxy:9901
+	client    *http.Client
+	// health 非空时同时读取 /clusters 中的异常剔除状态并上报给健康检查器
+	health *UDPHealthChecker
+
+	mu    sync.RWMutex
+	nodes map[string]*nodeScrapeState
+}
+
+// parseAdminEndpoints 解析 "nodeID=url,nodeID=url" 形式的Envoy admin地址列表
+func parseAdminEndpoints(s string) (map[string]string, error) {
+	endpoints := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		nodeID, addr, ok := strings.Cut(item, "=")
+		if !ok || nodeID == "" || addr == "" {
+			return nil, fmt.Errorf("Envoy admin地址格式错误(应为 nodeID=url): %s", item)
+		}
+		endpoints[nodeID] = strings.TrimRight(addr, "/")
+	}
+	return endpoints, nil
+}
+
+// NewStatsAggregator 创建统计聚合器
+func NewStatsAggregator(endpoints map[string]string) *StatsAggregator {
+	nodes := make(map[string]*nodeScrapeState, len(endpoints))
+	for nodeID := range endpoints {
+		nodes[nodeID] = &nodeScrapeState{}
+	}
+	return &StatsAggregator{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: statsScrapeTimeout},
+		nodes:     nodes,
+	}
+}
+
+// Run 定期抓取所有节点，直到 ctx 结束
+func (sa *StatsAggregator) Run(ctx context.Context) {
+	sa.scrapeAll(ctx)
+
+	ticker := time.NewTicker(statsScrapeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sa.scrapeAll(ctx)
+		}
+	}
+}
+
+func (sa *StatsAggregator) scrapeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for nodeID, addr := range sa.endpoints {
+		wg.Add(1)
+		go func(nodeID, addr string) {
+			defer wg.Done()
+
+			samples, err := sa.scrape(ctx, nodeID, addr)
+
+			sa.mu.Lock()
+			state := sa.nodes[nodeID]
+			if err != nil {
+				state.up = false
+				state.errors++
+				state.samples = nil
+			} else {
+				state.up = true
+				state.samples = samples
+			}
+			sa.mu.Unlock()
+
+			if err != nil {
+				slog.Warn("抓取Envoy统计失败", "node_id", nodeID, "error", err)
+				return
+			}
+
+			if sa.health != nil {
+				ejected, err := scrapeEjections(ctx, sa.client, addr)
+				if err != nil {
+					slog.Warn("抓取Envoy集群状态失败", "node_id", nodeID, "error", err)
+					return
+				}
+				sa.health.ReportEnvoyEjections(nodeID, ejected)
+			}
+		}(nodeID, addr)
+	}
+	wg.Wait()
+}
+
+// envoyStatsResponse /stats?format=json 的响应，直方图条目没有 value，这里只关心计数器与仪表
+type envoyStatsResponse struct {
+	Stats []struct {
+		Name  string   `json:"name"`
+		Value *float64 `json:"value"`
+	} `json:"stats"`
+}
+
+// scrape 抓取单个节点，仅请求战斗服相关统计以减小响应体
+func (sa *StatsAggregator) scrape(ctx context.Context, nodeID, addr string) ([]battleSample, error) {
+	query := url.Values{}
+	query.Set("format", "json")
+	query.Set("filter", `^udp\.`+battleStatPrefixHead)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/stats?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sa.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin接口返回状态 %d", resp.StatusCode)
+	}
+
+	var stats envoyStatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("解析统计响应失败: %v", err)
+	}
+
+	var samples []battleSample
+	for _, s := range stats.Stats {
+		if s.Value == nil {
+			continue
+		}
+		serviceID, port, stat, ok := parseBattleStatName(s.Name)
+		if !ok {
+			continue
+		}
+		if _, known := battleMetrics[stat]; !known {
+			continue
+		}
+		samples = append(samples, battleSample{
+			nodeID:    nodeID,
+			serviceID: serviceID,
+			port:      port,
+			stat:      stat,
+			value:     *s.Value,
+		})
+	}
+	return samples, nil
+}
+
+// MetricsHandler 以Prometheus文本格式输出按战斗服聚合的指标
+func (sa *StatsAggregator) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	sa.mu.RLock()
+	byMetric := make(map[string][]battleSample)
+	nodeIDs := make([]string, 0, len(sa.nodes))
+	for nodeID, state := range sa.nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+		for _, s := range state.samples {
+			byMetric[s.stat] = append(byMetric[s.stat], s)
+		}
+	}
+	sort.Strings(nodeIDs)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP envoy_stats_scrape_up 最近一次抓取Envoy统计是否成功")
+	fmt.Fprintln(w, "# TYPE envoy_stats_scrape_up gauge")
+	for _, nodeID := range nodeIDs {
+		up := 0
+		if sa.nodes[nodeID].up {
+			up = 1
+		}
+		fmt.Fprintf(w, "envoy_stats_scrape_up{node_id=%q} %d\n", nodeID, up)
+	}
+	fmt.Fprintln(w, "# HELP envoy_stats_scrape_errors_total 抓取Envoy统计失败次数")
+	fmt.Fprintln(w, "# TYPE envoy_stats_scrape_errors_total counter")
+	for _, nodeID := range nodeIDs {
+		fmt.Fprintf(w, "envoy_stats_scrape_errors_total{node_id=%q} %d\n", nodeID, sa.nodes[nodeID].errors)
+	}
+	sa.mu.RUnlock()
+
+	stats := make([]string, 0, len(byMetric))
+	for stat := range byMetric {
+		stats = append(stats, stat)
+	}
+	sort.Strings(stats)
+
+	for _, stat := range stats {
+		m := battleMetrics[stat]
+		samples := byMetric[stat]
+		sort.Slice(samples, func(i, j int) bool {
+			if samples[i].serviceID != samples[j].serviceID {
+				return samples[i].serviceID < samples[j].serviceID
+			}
+			return samples[i].nodeID < samples[j].nodeID
+		})
+
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+		for _, s := range samples {
+			fmt.Fprintf(w, "%s{node_id=%q,service_id=%q,external_port=%q} %s\n",
+				m.name, s.nodeID, s.serviceID, s.port, strconv.FormatFloat(s.value, 'f', -1, 64))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBattleStatNameRoundTrip(t *testing.T) {
+	tests := []struct {
+		serviceID string
+		port      uint32
+	}{
+		{"battle-server-1", 7001},
+		{"battle_server_1", 7002},
+		{"battle.node1.zone-a", 7003},
+		{"battle:7001", 7004},
+		{"a_1", 7005},
+		{"a.1", 7006},
+		{"a-2e1", 7007},
+		{"战斗服1", 7008},
+	}
+
+	seen := make(map[string]string)
+	for _, tt := range tests {
+		t.Run(tt.serviceID, func(t *testing.T) {
+			prefix := battleStatPrefix(tt.serviceID, tt.port)
+			if strings.ContainsAny(prefix, ".:") {
+				t.Fatalf("prefix %q 包含 '.' 或 ':'", prefix)
+			}
+			if other, dup := seen[prefix]; dup {
+				t.Fatalf("%q 与 %q 生成了相同的前缀 %q", tt.serviceID, other, prefix)
+			}
+			seen[prefix] = tt.serviceID
+
+			serviceID, port, stat, ok := parseBattleStatName("udp." + prefix + ".downstream_sess_active")
+			if !ok {
+				t.Fatalf("parseBattleStatName(%q) 失败", prefix)
+			}
+			if serviceID != tt.serviceID || port != strconv.FormatUint(uint64(tt.port), 10) || stat != "downstream_sess_active" {
+				t.Errorf("got (%q, %q, %q), want (%q, %d, downstream_sess_active)", serviceID, port, stat, tt.serviceID, tt.port)
+			}
+		})
+	}
+}
+
+func TestParseBattleStatNameRejects(t *testing.T) {
+	for _, name := range []string{
+		"udp.udp_stats_7001.downstream_sess_active", // 旧的统计前缀
+		"cluster.battle_a_7001.upstream_cx_total",
+		"udp.battle_a_7001",                 // 没有统计名
+		"udp.battle_7001.idle_timeout",      // 没有ServiceID
+		"udp.battle_a_port.idle_timeout",    // 端口不是数字
+		"udp.battle_a-2_7001.idle_timeout",  // 转义不完整
+		"udp.battle_a-zz_7001.idle_timeout", // 转义不是十六进制
+	} {
+		if serviceID, port, stat, ok := parseBattleStatName(name); ok {
+			t.Errorf("parseBattleStatName(%q) = (%q, %q, %q), want !ok", name, serviceID, port, stat)
+		}
+	}
+}
+
+func TestStatsAggregatorMetrics(t *testing.T) {
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" || r.URL.Query().Get("format") != "json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"stats":[
+			{"name":"udp.` + battleStatPrefix("battle.b", 7002) + `.downstream_sess_active","value":3},
+			{"name":"udp.` + battleStatPrefix("battle_a", 7001) + `.downstream_sess_active","value":5},
+			{"name":"udp.` + battleStatPrefix("battle_a", 7001) + `.downstream_sess_rx_bytes","value":1.5e6},
+			{"name":"udp.` + battleStatPrefix("battle_a", 7001) + `.unknown_stat","value":1},
+			{"name":"udp.` + battleStatPrefix("battle_a", 7001) + `.session_duration"},
+			{"name":"udp.udp_stats_7001.downstream_sess_active","value":9}
+		]}`))
+	}))
+	defer envoy.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	sa := NewStatsAggregator(map[string]string{"proxy-1": envoy.URL, "proxy-2": down.URL})
+	sa.scrapeAll(context.Background())
+
+	rec := httptest.NewRecorder()
+	sa.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# HELP envoy_stats_scrape_up 最近一次抓取Envoy统计是否成功
+# TYPE envoy_stats_scrape_up gauge
+envoy_stats_scrape_up{node_id="proxy-1"} 1
+envoy_stats_scrape_up{node_id="proxy-2"} 0
+# HELP envoy_stats_scrape_errors_total 抓取Envoy统计失败次数
+# TYPE envoy_stats_scrape_errors_total counter
+envoy_stats_scrape_errors_total{node_id="proxy-1"} 0
+envoy_stats_scrape_errors_total{node_id="proxy-2"} 1
+# HELP battle_udp_sessions_active 当前活跃的客户端会话数
+# TYPE battle_udp_sessions_active gauge
+battle_udp_sessions_active{node_id="proxy-1",service_id="battle.b",external_port="7002"} 3
+battle_udp_sessions_active{node_id="proxy-1",service_id="battle_a",external_port="7001"} 5
+# HELP battle_udp_rx_bytes_total 从客户端收到的字节数
+# TYPE battle_udp_rx_bytes_total counter
+battle_udp_rx_bytes_total{node_id="proxy-1",service_id="battle_a",external_port="7001"} 1500000
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", got, want)
+	}
+}
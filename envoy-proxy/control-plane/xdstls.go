@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const defaultXDSTLSReloadInterval = 30 * time.Second
+
+// XDSTLSOptions xDS gRPC服务的TLS配置。CertFile/KeyFile 为空时使用明文gRPC
+type XDSTLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile 校验Envoy客户端证书的CA，设置后要求客户端出示证书（mTLS）
+	ClientCAFile string
+	// ReloadInterval 检查证书文件是否更新的间隔，文件变化后新连接使用新证书，已建立的连接不受影响
+	ReloadInterval time.Duration
+	// BindNodeID 要求请求中的 node.id 与客户端证书的CN或某个DNS SAN一致，防止一个节点的证书拉取其他节点的配置
+	BindNodeID bool
+}
+
+// Enabled 是否启用TLS
+func (o XDSTLSOptions) Enabled() bool {
+	return o.CertFile != "" && o.KeyFile != ""
+}
+
+// certReloader 持有当前生效的TLS配置，定期检查证书文件的修改时间并在变化时重新加载
+type certReloader struct {
+	opts    XDSTLSOptions
+	config  atomic.Pointer[tls.Config]
+	modTime time.Time // 最近一次加载时所有证书文件中最新的修改时间
+}
+
+// newCertReloader 加载证书，任何文件无法加载时返回错误
+func newCertReloader(opts XDSTLSOptions) (*certReloader, error) {
+	if opts.ClientCAFile == "" && opts.BindNodeID {
+		return nil, fmt.Errorf("绑定 node.id 需要同时配置客户端CA (XDS_TLS_CLIENT_CA)")
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = defaultXDSTLSReloadInterval
+	}
+
+	r := &certReloader{opts: opts}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// credentials 返回gRPC服务端凭证，每次握手使用当前生效的配置
+func (r *certReloader) credentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config.Load(), nil
+		},
+	})
+}
+
+// Run 定期检查证书文件，直到 ctx 结束
+func (r *certReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := r.latestModTime()
+			if err != nil {
+				slog.Warn("检查xDS证书文件失败，继续使用当前证书", "error", err)
+				continue
+			}
+			if !modTime.After(r.modTime) {
+				continue
+			}
+			if err := r.load(); err != nil {
+				// 证书与私钥可能分两次写入，下一个周期再试
+				slog.Warn("重新加载xDS证书失败，继续使用当前证书", "error", err)
+				continue
+			}
+			slog.Info("已重新加载xDS证书", "cert_file", r.opts.CertFile)
+		}
+	}
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.opts.CertFile, r.opts.KeyFile}
+	if r.opts.ClientCAFile != "" {
+		files = append(files, r.opts.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	return latestModTime(r.files()...)
+}
+
+// latestModTime 返回文件中最新的修改时间，任一文件不存在时返回错误
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load 读取证书文件并替换当前配置
+func (r *certReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("读取xDS证书文件失败: %v", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("加载xDS服务端证书失败: %v", err)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	}
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("读取xDS客户端CA失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("xDS客户端CA %s 中没有有效的证书", r.opts.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.config.Store(cfg)
+	r.modTime = modTime
+	return nil
+}
+
+// xdsStream 标识一条xDS流。go-control-plane 的SotW与增量服务各自从1开始为流编号，只用流ID会让两类流互相覆盖
+type xdsStream struct {
+	delta bool
+	id    int64
+}
+
+// nodeAuthorizer 将xDS流绑定到客户端证书的身份：流上出现的 node.id 必须是证书的CN或DNS SAN之一
+type nodeAuthorizer struct {
+	mu     sync.Mutex
+	allows map[xdsStream][]string // 流 -> 证书允许的 node.id
+	nodes  map[xdsStream]string   // 流 -> 已校验的 node.id
+}
+
+func newNodeAuthorizer() *nodeAuthorizer {
+	return &nodeAuthorizer{
+		allows: make(map[xdsStream][]string),
+		nodes:  make(map[xdsStream]string),
+	}
+}
+
+// certIdentities 从连接的客户端证书中取出允许使用的 node.id
+func certIdentities(ctx context.Context) ([]string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "无法获取xDS客户端连接信息")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "xDS客户端 %s 未提供证书", p.Addr)
+	}
+	leaf := info.State.PeerCertificates[0]
+	ids := append([]string(nil), leaf.DNSNames...)
+	if cn := leaf.Subject.CommonName; cn != "" && !allowedNode(ids, cn) {
+		ids = append(ids, cn)
+	}
+	return ids, nil
+}
+
+// open 在流建立时记录客户端证书的身份
+func (a *nodeAuthorizer) open(ctx context.Context, stream xdsStream) error {
+	ids, err := certIdentities(ctx)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.allows[stream] = ids
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *nodeAuthorizer) close(stream xdsStream) {
+	a.mu.Lock()
+	delete(a.allows, stream)
+	delete(a.nodes, stream)
+	a.mu.Unlock()
+}
+
+// check 校验流上的请求。Envoy可能只在首个请求中携带node，此后的请求沿用已校验的 node.id
+func (a *nodeAuthorizer) check(stream xdsStream, node *core.Node) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	nodeID := node.GetId()
+	verified, ok := a.nodes[stream]
+	switch {
+	case nodeID == "" && ok:
+		return nil
+	case nodeID == "":
+		return status.Error(codes.InvalidArgument, "xDS请求未携带 node.id")
+	case ok && nodeID == verified:
+		return nil
+	case ok:
+		return status.Errorf(codes.PermissionDenied, "xDS流上的 node.id 从 %s 变为 %s", verified, nodeID)
+	}
+
+	if !allowedNode(a.allows[stream], nodeID) {
+		slog.Warn("拒绝xDS请求：node.id 与客户端证书不符", "node_id", nodeID, "cert_identities", a.allows[stream])
+		return status.Errorf(codes.PermissionDenied, "客户端证书无权使用 node.id %s", nodeID)
+	}
+	a.nodes[stream] = nodeID
+	return nil
+}
+
+// checkFetch 校验一次性的REST风格请求（Fetch），没有流可以记录身份
+func (a *nodeAuthorizer) checkFetch(ctx context.Context, node *core.Node) error {
+	ids, err := certIdentities(ctx)
+	if err != nil {
+		return err
+	}
+	if !allowedNode(ids, node.GetId()) {
+		return status.Errorf(codes.PermissionDenied, "客户端证书无权使用 node.id %s", node.GetId())
+	}
+	return nil
+}
+
+func allowedNode(ids []string, nodeID string) bool {
+	for _, id := range ids {
+		if id == nodeID {
+			return true
+		}
+	}
+	return false
+}
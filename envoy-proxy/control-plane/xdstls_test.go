@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// testCert 自签名证书，可同时作为客户端CA使用
+type testCert struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, dnsNames ...string) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return testCert{
+		cert:    cert,
+		certPEM: pemBlock("CERTIFICATE", der),
+		keyPEM:  pemBlock("EC PRIVATE KEY", keyDER),
+	}
+}
+
+func pemBlock(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+// peerContext 模拟gRPC在TLS握手后放入流上下文的连接信息，cert 为 nil 时客户端未出示证书
+func peerContext(cert *x509.Certificate) context.Context {
+	state := tls.ConnectionState{}
+	if cert != nil {
+		state.PeerCertificates = []*x509.Certificate{cert}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(10, 0, 0, 9), Port: 40000},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
+
+func node(id string) *core.Node {
+	if id == "" {
+		return nil
+	}
+	return &core.Node{Id: id}
+}
+
+// TestNodeAuthorizerStreamKinds SotW流与增量流的ID各自编号，同号的两条流不能共享或覆盖彼此校验过的身份
+func TestNodeAuthorizerStreamKinds(t *testing.T) {
+	cp := &ControlPlane{tracker: newConfigTracker(), auth: newNodeAuthorizer()}
+	cb := cp.xdsCallbacks()
+	victim := newTestCert(t, "proxy-1")
+	attacker := newTestCert(t, "proxy-2")
+
+	sotwRequest := func(nodeID string) error {
+		return cb.OnStreamRequest(1, &discovery.DiscoveryRequest{Node: node(nodeID)})
+	}
+	deltaRequest := func(nodeID string) error {
+		return cb.OnStreamDeltaRequest(1, &discovery.DeltaDiscoveryRequest{Node: node(nodeID)})
+	}
+
+	if err := cb.OnStreamOpen(peerContext(victim.cert), 1, ""); err != nil {
+		t.Fatalf("OnStreamOpen: %v", err)
+	}
+	if err := sotwRequest("proxy-1"); err != nil {
+		t.Fatalf("SotW proxy-1: %v", err)
+	}
+
+	// 增量流 #1 由另一张证书建立，不能沿用SotW流 #1 校验过的 proxy-1
+	if err := cb.OnDeltaStreamOpen(peerContext(attacker.cert), 1, ""); err != nil {
+		t.Fatalf("OnDeltaStreamOpen: %v", err)
+	}
+	if err := deltaRequest("proxy-1"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("增量流冒用 proxy-1: err = %v, want PermissionDenied", err)
+	}
+	if err := deltaRequest("proxy-2"); err != nil {
+		t.Fatalf("增量流 proxy-2: %v", err)
+	}
+
+	// 增量流的证书没有覆盖SotW流的身份
+	if err := sotwRequest(""); err != nil {
+		t.Errorf("SotW 后续请求: %v", err)
+	}
+	if err := sotwRequest("proxy-2"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("SotW 改用 proxy-2: err = %v, want PermissionDenied", err)
+	}
+
+	// 关闭增量流不影响同号的SotW流
+	cb.OnDeltaStreamClosed(1, nil)
+	if err := sotwRequest("proxy-1"); err != nil {
+		t.Errorf("关闭增量流后 SotW proxy-1: %v", err)
+	}
+	cb.OnStreamClosed(1, nil)
+	if len(cp.auth.allows) != 0 || len(cp.auth.nodes) != 0 {
+		t.Errorf("流关闭后仍有记录: allows = %v, nodes = %v", cp.auth.allows, cp.auth.nodes)
+	}
+}
+
+func TestCertIdentities(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		want     []string
+		wantCode codes.Code
+	}{
+		{"CN与SAN", peerContext(newTestCert(t, "proxy-1", "proxy-1.edge", "proxy-1.backup").cert), []string{"proxy-1.edge", "proxy-1.backup", "proxy-1"}, codes.OK},
+		{"CN已在SAN中", peerContext(newTestCert(t, "proxy-1", "proxy-1").cert), []string{"proxy-1"}, codes.OK},
+		{"只有SAN", peerContext(newTestCert(t, "", "proxy-1").cert), []string{"proxy-1"}, codes.OK},
+		{"未出示证书", peerContext(nil), nil, codes.Unauthenticated},
+		{"明文连接", peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{}}), nil, codes.Unauthenticated},
+		{"没有连接信息", context.Background(), nil, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := certIdentities(tt.ctx)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("err = %v, want %v", err, tt.wantCode)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("ids = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeAuthorizerCheck(t *testing.T) {
+	cert := newTestCert(t, "proxy-1", "proxy-1.edge").cert
+	type step struct {
+		node string
+		want codes.Code
+	}
+	tests := []struct {
+		name  string
+		open  bool // 是否先经过 open 记录证书身份
+		steps []step
+	}{
+		{"CN匹配", true, []step{{"proxy-1", codes.OK}}},
+		{"SAN匹配", true, []step{{"proxy-1.edge", codes.OK}}},
+		{"不在证书中", true, []step{{"proxy-2", codes.PermissionDenied}, {"proxy-1", codes.OK}}},
+		{"大小写不同", true, []step{{"Proxy-1", codes.PermissionDenied}}},
+		{"首个请求未携带node", true, []step{{"", codes.InvalidArgument}}},
+		{"后续请求沿用已校验的node", true, []step{{"proxy-1", codes.OK}, {"", codes.OK}, {"proxy-1", codes.OK}}},
+		{"流中途更换为其他节点", true, []step{{"proxy-1", codes.OK}, {"proxy-2", codes.PermissionDenied}}},
+		{"流中途更换为证书内的另一身份", true, []step{{"proxy-1", codes.OK}, {"proxy-1.edge", codes.PermissionDenied}, {"", codes.OK}}},
+		{"未记录证书的流", false, []step{{"proxy-1", codes.PermissionDenied}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newNodeAuthorizer()
+			stream := xdsStream{id: 3}
+			if tt.open {
+				if err := a.open(peerContext(cert), stream); err != nil {
+					t.Fatalf("open: %v", err)
+				}
+			}
+			for i, s := range tt.steps {
+				if err := a.check(stream, node(s.node)); status.Code(err) != s.want {
+					t.Fatalf("step %d node %q: err = %v, want %v", i, s.node, err, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeAuthorizerOpenWithoutCert(t *testing.T) {
+	a := newNodeAuthorizer()
+	if err := a.open(peerContext(nil), xdsStream{id: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated", err)
+	}
+	if len(a.allows) != 0 {
+		t.Errorf("allows = %v", a.allows)
+	}
+}
+
+func TestNodeAuthorizerCheckFetch(t *testing.T) {
+	cert := newTestCert(t, "proxy-1", "proxy-1.edge").cert
+	tests := []struct {
+		name string
+		ctx  context.Context
+		node string
+		want codes.Code
+	}{
+		{"CN匹配", peerContext(cert), "proxy-1", codes.OK},
+		{"SAN匹配", peerContext(cert), "proxy-1.edge", codes.OK},
+		{"其他节点", peerContext(cert), "proxy-2", codes.PermissionDenied},
+		{"未携带node", peerContext(cert), "", codes.PermissionDenied},
+		{"未出示证书", peerContext(nil), "proxy-1", codes.Unauthenticated},
+	}
+	a := newNodeAuthorizer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.checkFetch(tt.ctx, node(tt.node)); status.Code(err) != tt.want {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// certFiles 在临时目录中写入证书、私钥与客户端CA，返回对应的配置
+func certFiles(t *testing.T, c testCert) XDSTLSOptions {
+	t.Helper()
+	dir := t.TempDir()
+	opts := XDSTLSOptions{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	writeFile(t, opts.CertFile, c.certPEM, time.Now().Add(-time.Minute))
+	writeFile(t, opts.KeyFile, c.keyPEM, time.Now().Add(-time.Minute))
+	writeFile(t, opts.ClientCAFile, c.certPEM, time.Now().Add(-time.Minute))
+	return opts
+}
+
+// writeFile 写入文件并设置修改时间，避免依赖文件系统时间戳的精度
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+}
+
+func servedCert(r *certReloader) []byte {
+	return r.config.Load().Certificates[0].Certificate[0]
+}
+
+func TestNewCertReloader(t *testing.T) {
+	good := newTestCert(t, "control-plane")
+	other := newTestCert(t, "other")
+
+	tests := []struct {
+		name    string
+		modify  func(opts *XDSTLSOptions)
+		wantErr bool
+	}{
+		{"有效", func(*XDSTLSOptions) {}, false},
+		{"证书与私钥不匹配", func(o *XDSTLSOptions) { writeFile(t, o.KeyFile, other.keyPEM, time.Now()) }, true},
+		{"证书只写了一半", func(o *XDSTLSOptions) { writeFile(t, o.CertFile, good.certPEM[:len(good.certPEM)/2], time.Now()) }, true},
+		{"私钥为空", func(o *XDSTLSOptions) { writeFile(t, o.KeyFile, nil, time.Now()) }, true},
+		{"CA中没有证书", func(o *XDSTLSOptions) { writeFile(t, o.ClientCAFile, []byte("not a pem"), time.Now()) }, true},
+		{"CA文件不存在", func(o *XDSTLSOptions) { o.ClientCAFile += ".missing" }, true},
+		{"绑定node.id需要客户端CA", func(o *XDSTLSOptions) { o.ClientCAFile, o.BindNodeID = "", true }, true},
+		{"不校验客户端证书", func(o *XDSTLSOptions) { o.ClientCAFile = "" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := certFiles(t, good)
+			tt.modify(&opts)
+			r, err := newCertReloader(opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			cfg := r.config.Load()
+			if !bytes.Equal(servedCert(r), good.cert.Raw) {
+				t.Error("未使用配置的证书")
+			}
+			if wantAuth := opts.ClientCAFile != ""; (cfg.ClientAuth == tls.RequireAndVerifyClientCert) != wantAuth {
+				t.Errorf("ClientAuth = %v, 配置CA = %v", cfg.ClientAuth, wantAuth)
+			}
+		})
+	}
+}
+
+// TestCertReloaderRun 证书轮换：半写入或与私钥不匹配的文件不会替换当前证书，写完整后下一个周期生效
+func TestCertReloaderRun(t *testing.T) {
+	first := newTestCert(t, "control-plane")
+	second := newTestCert(t, "control-plane")
+	opts := certFiles(t, first)
+	opts.ReloadInterval = 5 * time.Millisecond
+	r, err := newCertReloader(opts)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	waitCert := func(want []byte, msg string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !bytes.Equal(servedCert(r), want) {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// stays 检查若干个周期内证书不变
+	stays := func(want []byte, msg string) {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			time.Sleep(opts.ReloadInterval)
+			if !bytes.Equal(servedCert(r), want) {
+				t.Fatal(msg)
+			}
+		}
+	}
+
+	now := time.Now()
+	writeFile(t, opts.CertFile, second.certPEM[:len(second.certPEM)/2], now)
+	stays(first.cert.Raw, "加载了只写了一半的证书")
+
+	writeFile(t, opts.CertFile, second.certPEM, now.Add(time.Second))
+	stays(first.cert.Raw, "加载了与私钥不匹配的证书")
+
+	writeFile(t, opts.KeyFile, second.keyPEM, now.Add(2*time.Second))
+	waitCert(second.cert.Raw, "私钥写入后未加载新证书")
+
+	if err := os.Remove(opts.KeyFile); err != nil {
+		t.Fatal(err)
+	}
+	stays(second.cert.Raw, "私钥文件缺失时替换了证书")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("control-plane")
+
+// ackedTypes 等待Envoy确认的资源类型，与快照中包含的资源一致
+var ackedTypes = []string{resource.ClusterType, resource.ListenerType}
+
+type ackKey struct {
+	nodeID  string
+	typeURL string
+}
+
+// pendingSpan 等待某个快照版本被确认的span
+type pendingSpan struct {
+	version string
+	span    trace.Span
+}
+
+// configTracker 通过xDS回调跟踪快照是否被Envoy接受，
+// 把配置从设置快照到Envoy确认（ACK）或拒绝（NACK）的过程记录为span
+type configTracker struct {
+	mu      sync.Mutex
+	streams map[int64]string // xDS流ID -> Envoy node.id，Envoy可能只在流的首个请求中携带node
+	acks    map[ackKey]*pendingSpan
+	// services 新注册的战斗服，包含其监听器的快照被任一Envoy节点确认后结束
+	services []pendingSpan
+}
+
+func newConfigTracker() *configTracker {
+	return &configTracker{
+		streams: make(map[int64]string),
+		acks:    make(map[ackKey]*pendingSpan),
+	}
+}
+
+// callbacks 返回注册到xDS服务器的回调
+func (t *configTracker) callbacks() server.CallbackFuncs {
+	return server.CallbackFuncs{
+		StreamRequestFunc: t.onStreamRequest,
+		StreamClosedFunc: func(streamID int64, _ *core.Node) {
+			t.mu.Lock()
+			delete(t.streams, streamID)
+			t.mu.Unlock()
+		},
+	}
+}
+
+// expectAck 记录已为节点设置的快照版本，为每种资源开始一个等待确认的span。
+// 节点尚未确认上一个版本时，上一个span以被取代结束
+func (t *configTracker) expectAck(ctx context.Context, nodeID, version string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, typeURL := range ackedTypes {
+		key := ackKey{nodeID: nodeID, typeURL: typeURL}
+		if prev, ok := t.acks[key]; ok {
+			prev.span.AddEvent("superseded", trace.WithAttributes(attribute.String("snapshot_version", version)))
+			prev.span.End()
+		}
+
+		_, span := tracer.Start(ctx, "envoy.ack", trace.WithAttributes(
+			attribute.String("node_id", nodeID),
+			attribute.String("snapshot_version", version),
+			attribute.String("type_url", typeURL),
+		))
+		t.acks[key] = &pendingSpan{version: version, span: span}
+	}
+}
+
+// trackService 登记一个新注册战斗服的传播span，在 version 或更新的监听器被确认时结束
+func (t *configTracker) trackService(span trace.Span, version string) {
+	t.mu.Lock()
+	t.services = append(t.services, pendingSpan{version: version, span: span})
+	t.mu.Unlock()
+}
+
+// onStreamRequest 识别Envoy对已下发版本的确认：带 response_nonce 的请求中，
+// version_info 等于下发版本为ACK，携带 error_detail 为NACK（此时 version_info 仍为上一个接受的版本）
+func (t *configTracker) onStreamRequest(streamID int64, req *discovery.DiscoveryRequest) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	nodeID := req.GetNode().GetId()
+	if nodeID != "" {
+		t.streams[streamID] = nodeID
+	} else {
+		nodeID = t.streams[streamID]
+	}
+	if req.GetResponseNonce() == "" {
+		return nil // 订阅请求，不是对下发内容的响应
+	}
+
+	key := ackKey{nodeID: nodeID, typeURL: req.GetTypeUrl()}
+	pending, ok := t.acks[key]
+	if !ok {
+		return nil
+	}
+
+	if detail := req.GetErrorDetail(); detail != nil {
+		pending.span.SetStatus(codes.Error, detail.GetMessage())
+		pending.span.End()
+		delete(t.acks, key)
+		return nil
+	}
+	if req.GetVersionInfo() != pending.version {
+		return nil
+	}
+	pending.span.End()
+	delete(t.acks, key)
+
+	if key.typeURL == resource.ListenerType {
+		t.endServices(nodeID, pending.version)
+	}
+	return nil
+}
+
+// endServices 结束所有已包含在 version 中的战斗服传播span。版本号以定长序号开头，按字符串比较即按构建先后
+func (t *configTracker) endServices(nodeID, version string) {
+	remaining := t.services[:0]
+	for _, s := range t.services {
+		if s.version > version {
+			remaining = append(remaining, s)
+			continue
+		}
+		s.span.SetAttributes(attribute.String("node_id", nodeID), attribute.String("acked_version", version))
+		s.span.End()
+	}
+	t.services = remaining
+}
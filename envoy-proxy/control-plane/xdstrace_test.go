@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	consulapi "github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/status"
+)
+
+var (
+	spanExporterOnce sync.Once
+	spanExporter     *tracetest.InMemoryExporter
+)
+
+// recordSpans 把全局 TracerProvider 设置为同步导出到内存的实现。全局 tracer 只会委托给第一次设置的
+// Provider，所以各测试共用一个导出器，开始前清空
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	spanExporterOnce.Do(func() {
+		spanExporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	spanExporter.Reset()
+	return spanExporter
+}
+
+// endedSpans 按名称返回已结束的span
+func endedSpans(exp *tracetest.InMemoryExporter, name string) []tracetest.SpanStub {
+	var out []tracetest.SpanStub
+	for _, s := range exp.GetSpans() {
+		if s.Name == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func spanAttr(s tracetest.SpanStub, key string) string {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func ackRequest(nodeID, typeURL, version, nonce string, nack bool) *discovery.DiscoveryRequest {
+	req := &discovery.DiscoveryRequest{TypeUrl: typeURL, VersionInfo: version, ResponseNonce: nonce}
+	if nodeID != "" {
+		req.Node = &core.Node{Id: nodeID}
+	}
+	if nack {
+		req.ErrorDetail = &status.Status{Message: "rejected"}
+	}
+	return req
+}
+
+func TestConfigTrackerAck(t *testing.T) {
+	type req struct {
+		streamID int64
+		node     string // 为空时模拟后续请求不携带node
+		typeURL  string
+		version  string
+		nonce    string
+		nack     bool
+	}
+	tests := []struct {
+		name     string
+		expect   []string // 依次设置给 proxy-1 的快照版本
+		requests []req
+		// wantAck 已结束的 envoy.ack span：type_url -> 状态，"ok" 为确认，"error" 为拒绝，"superseded" 为被取代
+		wantAck     map[string][]string
+		wantPending int
+	}{
+		{
+			name:   "确认当前版本",
+			expect: []string{"v1"},
+			requests: []req{
+				{1, "proxy-1", resource.ClusterType, "v1", "n1", false},
+				{1, "proxy-1", resource.ListenerType, "v1", "n2", false},
+			},
+			wantAck: map[string][]string{resource.ClusterType: {"ok"}, resource.ListenerType: {"ok"}},
+		},
+		{
+			name:   "订阅请求不算确认",
+			expect: []string{"v1"},
+			requests: []req{
+				{1, "proxy-1", resource.ListenerType, "v1", "", false},
+			},
+			wantPending: 2,
+		},
+		{
+			name:   "确认旧版本时继续等待",
+			expect: []string{"v1"},
+			requests: []req{
+				{1, "proxy-1", resource.ListenerType, "v0", "n1", false},
+			},
+			wantPending: 2,
+		},
+		{
+			name:   "拒绝时以错误结束",
+			expect: []string{"v1"},
+			requests: []req{
+				{1, "proxy-1", resource.ListenerType, "v0", "n1", true},
+			},
+			wantAck:     map[string][]string{resource.ListenerType: {"error"}},
+			wantPending: 1,
+		},
+		{
+			name:   "后续请求沿用流首个请求的node",
+			expect: []string{"v1"},
+			requests: []req{
+				{7, "proxy-1", resource.ListenerType, "", "", false},
+				{7, "", resource.ListenerType, "v1", "n1", false},
+			},
+			wantAck:     map[string][]string{resource.ListenerType: {"ok"}},
+			wantPending: 1,
+		},
+		{
+			name:   "其他节点的确认不匹配",
+			expect: []string{"v1"},
+			requests: []req{
+				{1, "proxy-2", resource.ListenerType, "v1", "n1", false},
+			},
+			wantPending: 2,
+		},
+		{
+			name:   "新版本取代未确认的版本",
+			expect: []string{"v1", "v2"},
+			requests: []req{
+				{1, "proxy-1", resource.ListenerType, "v1", "n1", false},
+				{1, "proxy-1", resource.ListenerType, "v2", "n2", false},
+			},
+			wantAck: map[string][]string{
+				resource.ClusterType:  {"superseded"},
+				resource.ListenerType: {"superseded", "ok"},
+			},
+			wantPending: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := recordSpans(t)
+			tracker := newConfigTracker()
+			for _, v := range tt.expect {
+				tracker.expectAck(context.Background(), "proxy-1", v)
+			}
+			for _, r := range tt.requests {
+				if err := tracker.onStreamRequest(r.streamID, ackRequest(r.node, r.typeURL, r.version, r.nonce, r.nack)); err != nil {
+					t.Fatalf("onStreamRequest: %v", err)
+				}
+			}
+
+			got := make(map[string][]string)
+			for _, s := range endedSpans(exp, "envoy.ack") {
+				state := "ok"
+				if s.Status.Code == codes.Error {
+					state = "error"
+				} else if len(s.Events) > 0 && s.Events[0].Name == "superseded" {
+					state = "superseded"
+				}
+				typeURL := spanAttr(s, "type_url")
+				got[typeURL] = append(got[typeURL], state)
+			}
+			if len(got) != len(tt.wantAck) {
+				t.Fatalf("ended = %v, want %v", got, tt.wantAck)
+			}
+			for typeURL, want := range tt.wantAck {
+				if !slices.Equal(got[typeURL], want) {
+					t.Errorf("%s: ended = %v, want %v", typeURL, got[typeURL], want)
+				}
+			}
+			if len(tracker.acks) != tt.wantPending {
+				t.Errorf("pending = %d, want %d", len(tracker.acks), tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestConfigTrackerEndServices(t *testing.T) {
+	exp := recordSpans(t)
+	tracker := newConfigTracker()
+	for _, s := range []struct{ id, version string }{
+		{"battle-1", "0000000001-20260101T000000"},
+		{"battle-2", "0000000002-20260101T000000"},
+		{"battle-3", "0000000010-20260101T000000"}, // 序号定长，字符串比较不会把10排在2之前
+	} {
+		_, span := otel.Tracer("test").Start(context.Background(), "envoy.propagate", trace.WithAttributes(attribute.String("server_id", s.id)))
+		tracker.trackService(span, s.version)
+	}
+
+	tracker.expectAck(context.Background(), "proxy-1", "0000000002-20260101T000001")
+	tracker.onStreamRequest(1, ackRequest("proxy-1", resource.ListenerType, "0000000002-20260101T000001", "n1", false))
+
+	var ended []string
+	for _, s := range endedSpans(exp, "envoy.propagate") {
+		ended = append(ended, spanAttr(s, "server_id"))
+		if spanAttr(s, "acked_version") != "0000000002-20260101T000001" || spanAttr(s, "node_id") != "proxy-1" {
+			t.Errorf("%s: attributes = %v", spanAttr(s, "server_id"), s.Attributes)
+		}
+	}
+	if !slices.Equal(ended, []string{"battle-1", "battle-2"}) {
+		t.Errorf("ended = %v, want [battle-1 battle-2]", ended)
+	}
+	if len(tracker.services) != 1 {
+		t.Errorf("remaining = %d, want 1", len(tracker.services))
+	}
+}
+
+// fakeConsul 只实现健康服务查询，返回固定的 game-server 实例
+func fakeConsul(t *testing.T, entries []*consulapi.ServiceEntry) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/game-server" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// TestUpdateEnvoyConfigTraceLinkage 从游戏服务器注册的span，经 SetSnapshot，到Envoy确认包含该服务的快照，
+// 各span按服务ID与快照版本关联在注册所在的链路上
+func TestUpdateEnvoyConfigTraceLinkage(t *testing.T) {
+	exp := recordSpans(t)
+	t.Setenv("ENVOY_NODE_ID", "")
+
+	regCtx, regSpan := otel.Tracer("game-server").Start(context.Background(), "register")
+	regSpan.End()
+	meta := map[string]string{metaExternalPort: "7001", metaProtocol: "udp", "registered_at": "1"}
+	otel.GetTextMapPropagator().Inject(regCtx, propagation.MapCarrier(meta))
+
+	addr := fakeConsul(t, []*consulapi.ServiceEntry{{
+		Node:    &consulapi.Node{Node: "node-1"},
+		Service: &consulapi.AgentService{ID: "battle-1", Service: "game-server", Address: "10.0.0.1", Port: 9000, Meta: meta},
+	}})
+	cp, err := NewControlPlane(addr, 0, ListenerOptions{}, XDSTLSOptions{})
+	if err != nil {
+		t.Fatalf("NewControlPlane: %v", err)
+	}
+	defer cp.cancel()
+
+	cp.updateEnvoyConfig()
+	snapshot, err := cp.cache.GetSnapshot("proxy-1")
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	version := snapshot.GetVersion(resource.ListenerType)
+	if len(endedSpans(exp, "envoy.propagate")) != 0 {
+		t.Fatal("Envoy确认之前传播span已结束")
+	}
+
+	// 再次构建时服务未重新注册，不应开始新的传播span
+	cp.updateEnvoyConfig()
+	snapshot, _ = cp.cache.GetSnapshot("proxy-1")
+	latest := snapshot.GetVersion(resource.ListenerType)
+	if latest <= version {
+		t.Fatalf("新版本 %q 不大于 %q", latest, version)
+	}
+	if len(cp.tracker.services) != 1 {
+		t.Fatalf("tracked services = %d, want 1", len(cp.tracker.services))
+	}
+
+	// Envoy 经xDS流确认最新版本，与 xDS 服务器使用同一组回调
+	callbacks := cp.xdsCallbacks()
+	callbacks.StreamRequestFunc(1, ackRequest("proxy-1", resource.ListenerType, "", "", false))
+	callbacks.StreamRequestFunc(1, ackRequest("", resource.ListenerType, latest, "n1", false))
+
+	propagate := endedSpans(exp, "envoy.propagate")
+	if len(propagate) != 1 {
+		t.Fatalf("envoy.propagate spans = %d, want 1", len(propagate))
+	}
+	p := propagate[0]
+	if p.Parent.SpanID() != regSpan.SpanContext().SpanID() || p.SpanContext.TraceID() != regSpan.SpanContext().TraceID() {
+		t.Errorf("传播span未以注册span为父: parent %v", p.Parent)
+	}
+	for key, want := range map[string]string{
+		"server_id":        "battle-1",
+		"external_port":    "7001",
+		"snapshot_version": version, // 首次包含该服务的版本
+		"acked_version":    latest,
+		"node_id":          "proxy-1",
+	} {
+		if got := spanAttr(p, key); got != want {
+			t.Errorf("envoy.propagate %s = %q, want %q", key, got, want)
+		}
+	}
+
+	// 确认span属于最新一次 SetSnapshot，上一次的被取代
+	sets := make(map[trace.SpanID]tracetest.SpanStub)
+	for _, s := range endedSpans(exp, "SetSnapshot") {
+		sets[s.SpanContext.SpanID()] = s
+	}
+	var acked int
+	for _, s := range endedSpans(exp, "envoy.ack") {
+		if spanAttr(s, "type_url") != resource.ListenerType || len(s.Events) > 0 {
+			continue
+		}
+		acked++
+		set, ok := sets[s.Parent.SpanID()]
+		if !ok || spanAttr(set, "snapshot_version") != latest || spanAttr(set, "node_id") != "proxy-1" {
+			t.Errorf("envoy.ack 的父span不是版本 %s 的 SetSnapshot", latest)
+		}
+		if spanAttr(s, "snapshot_version") != latest {
+			t.Errorf("envoy.ack snapshot_version = %q, want %q", spanAttr(s, "snapshot_version"), latest)
+		}
+	}
+	if acked != 1 {
+		t.Errorf("确认的 envoy.ack spans = %d, want 1", acked)
+	}
+
+	// 首次更新链接到注册span，之后的更新不再链接
+	var linked int
+	for _, s := range endedSpans(exp, "updateEnvoyConfig") {
+		for _, l := range s.Links {
+			if l.SpanContext.SpanID() == regSpan.SpanContext().SpanID() {
+				linked++
+			}
+		}
+	}
+	if linked != 1 {
+		t.Errorf("链接到注册span的 updateEnvoyConfig = %d, want 1", linked)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gameproto"
+)
+
+// ticketRejectReason 票据被拒绝的原因，作为指标标签
+type ticketRejectReason int
+
+const (
+	rejectFormat ticketRejectReason = iota
+	rejectKey
+	rejectSignature
+	rejectExpired
+	rejectNotYetValid
+	rejectServer
+	rejectSession
+	rejectReplay
+	numRejectReasons
+)
+
+var ticketRejectReasonNames = [numRejectReasons]string{
+	"format", "unknown_key", "signature", "expired", "not_yet_valid", "server", "session", "replay",
+}
+
+var errTicketReplay = errors.New("票据已被其他地址使用")
+
+// usedTicket 已使用的票据，在票据过期前保留，用于识别重放
+type usedTicket struct {
+	addr    string
+	expires time.Time
+}
+
+// ticketAuth 校验 HELLO 帧携带的票据。票据绑定战斗服与会话ID，且只能被首次使用它的客户端地址使用，
+// 同一客户端重发 HELLO 或会话超时后重连仍可使用
+type ticketAuth struct {
+	keys      *gameproto.TicketKeys
+	serverIDs []string // 票据可用于的服务器ID：自身，热备时还包括被保护的主实例
+
+	mu        sync.Mutex
+	used      map[uint64]usedTicket // 会话ID -> 使用记录
+	lastPrune time.Time
+
+	accepted atomic.Uint64
+	rejected [numRejectReasons]atomic.Uint64
+	// unauthenticated 启用票据校验后不属于任何已建立会话的帧，被直接丢弃
+	unauthenticated atomic.Uint64
+}
+
+func newTicketAuth(keys *gameproto.TicketKeys, serverIDs ...string) *ticketAuth {
+	return &ticketAuth{
+		keys:      keys,
+		serverIDs: serverIDs,
+		used:      make(map[uint64]usedTicket),
+	}
+}
+
+// verify 校验票据并登记使用记录
+func (a *ticketAuth) verify(ticket []byte, sessionID uint64, addr string, now time.Time) error {
+	t, err := a.keys.Verify(ticket, now)
+	if err != nil {
+		a.reject(rejectReasonOf(err))
+		return err
+	}
+	if !slices.Contains(a.serverIDs, t.ServerID) {
+		a.reject(rejectServer)
+		return fmt.Errorf("票据属于服务器 %s", t.ServerID)
+	}
+	if t.SessionID != sessionID {
+		a.reject(rejectSession)
+		return fmt.Errorf("票据绑定的会话 %d 与帧头中的会话 %d 不一致", t.SessionID, sessionID)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune(now)
+	if u, ok := a.used[sessionID]; ok && u.addr != addr {
+		a.reject(rejectReplay)
+		return fmt.Errorf("%w: %s", errTicketReplay, u.addr)
+	}
+	a.used[sessionID] = usedTicket{addr: addr, expires: t.ExpiresAt}
+	a.accepted.Add(1)
+	return nil
+}
+
+// prune 每分钟清理一次已过期票据的使用记录，过期票据无法通过校验，不需要再记录
+func (a *ticketAuth) prune(now time.Time) {
+	if now.Sub(a.lastPrune) < time.Minute {
+		return
+	}
+	a.lastPrune = now
+	for id, u := range a.used {
+		if now.After(u.expires) {
+			delete(a.used, id)
+		}
+	}
+}
+
+func (a *ticketAuth) reject(reason ticketRejectReason) {
+	a.rejected[reason].Add(1)
+}
+
+// totalRejected 被拒绝的票据总数
+func (a *ticketAuth) totalRejected() uint64 {
+	var n uint64
+	for i := range a.rejected {
+		n += a.rejected[i].Load()
+	}
+	return n
+}
+
+func rejectReasonOf(err error) ticketRejectReason {
+	switch {
+	case errors.Is(err, gameproto.ErrTicketKey):
+		return rejectKey
+	case errors.Is(err, gameproto.ErrTicketSignature):
+		return rejectSignature
+	case errors.Is(err, gameproto.ErrTicketExpired):
+		return rejectExpired
+	case errors.Is(err, gameproto.ErrTicketNotYetValid):
+		return rejectNotYetValid
+	default:
+		return rejectFormat
+	}
+}
+
+// stats 票据校验统计
+func (a *ticketAuth) stats() map[string]interface{} {
+	rejected := make(map[string]uint64, numRejectReasons)
+	for i := range a.rejected {
+		rejected[ticketRejectReasonNames[i]] = a.rejected[i].Load()
+	}
+	a.mu.Lock()
+	used := len(a.used)
+	a.mu.Unlock()
+	return map[string]interface{}{
+		"accepted":        a.accepted.Load(),
+		"rejected":        rejected,
+		"unauthenticated": a.unauthenticated.Load(),
+		"tracked":         used,
+	}
+}
+
+// hello 处理会话的首个帧。启用票据校验时校验通过才建立会话，失败时不回复，避免被用于探测；
+// 会话已存在（客户端未收到 WELCOME 而重发）时直接回复
+func (gs *GameServer) hello(req *gameproto.Frame, clientAddr, replyAddr *net.UDPAddr) []*gameproto.Frame {
+	if gs.tickets != nil && gs.Sessions.Find(clientAddr, req.SessionID) == nil {
+		if err := gs.tickets.verify(req.Payload, req.SessionID, clientAddr.String(), time.Now()); err != nil {
+			// 按2的幂次记录日志，避免伪造票据刷屏
+			if n := gs.tickets.totalRejected(); n&(n-1) == 0 {
+				slog.Warn("拒绝会话：票据无效", "remote_addr", clientAddr.String(), "session", req.SessionID, "rejected", n, "error", err)
+			}
+			return nil
+		}
+	}
+
+	sess, created := gs.Sessions.Touch(clientAddr, replyAddr, req.SessionID, req.Seq)
+	if sess == nil {
+		gs.sessionRejected(clientAddr, req.SessionID)
+		return nil
+	}
+	if created {
+		slog.Info("新会话", "remote_addr", clientAddr.String(), "session", req.SessionID, "active_sessions", gs.Sessions.Count(),
+			"authenticated", gs.tickets != nil)
+	}
+	return []*gameproto.Frame{{
+		Type:      gameproto.TypeWelcome,
+		Seq:       req.Seq,
+		SessionID: sess.SessionID,
+		Payload:   fmt.Appendf(nil, "WELCOME from server %s", gs.ServerID),
+	}}
+}
+
+// session 查找或创建帧所属的会话。启用票据校验时只有 HELLO 能建立会话，不属于已有会话的帧返回 nil
+func (gs *GameServer) session(req *gameproto.Frame, clientAddr, replyAddr *net.UDPAddr) *Session {
+	if gs.tickets != nil {
+		sess := gs.Sessions.Find(clientAddr, req.SessionID)
+		if sess == nil {
+			if n := gs.tickets.unauthenticated.Add(1); n&(n-1) == 0 {
+				slog.Warn("丢弃未认证的帧", "remote_addr", clientAddr.String(), "type", req.Type.String(), "dropped", n)
+			}
+			return nil
+		}
+		sess.observe(req.Seq, time.Now())
+		return sess
+	}
+
+	sess, created := gs.Sessions.Touch(clientAddr, replyAddr, req.SessionID, req.Seq)
+	if sess == nil {
+		gs.sessionRejected(clientAddr, req.SessionID)
+		return nil
+	}
+	if created {
+		slog.Info("新会话", "remote_addr", clientAddr.String(), "session", req.SessionID, "active_sessions", gs.Sessions.Count())
+	}
+	return sess
+}
+
+// sessionRejected 会话数已达上限，丢弃帧且不回复，按2的幂次记录日志
+func (gs *GameServer) sessionRejected(clientAddr *net.UDPAddr, sessionID uint64) {
+	if n := gs.Sessions.Rejected(); n&(n-1) == 0 {
+		slog.Warn("拒绝会话：会话数已达上限", "remote_addr", clientAddr.String(), "session", sessionID, "rejected", n)
+	}
+}
+
+// authenticated 帧是否属于经票据认证的会话；未启用票据校验时所有来源均视为未认证
+func (gs *GameServer) authenticated(req *gameproto.Frame, clientAddr *net.UDPAddr) bool {
+	return gs.tickets != nil && req.Type != gameproto.TypeHello && gs.Sessions.Find(clientAddr, req.SessionID) != nil
+}
+
+// textAllowed 启用票据校验后文本协议只接受 PING，供控制平面健康检查与本机自检使用
+func (gs *GameServer) textAllowed(message []byte) bool {
+	return gs.tickets == nil || parseTextMessage(string(message)).Type == gameproto.TypePing
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gameproto"
+)
+
+func testTicketKeys(t testing.TB) *gameproto.TicketKeys {
+	t.Helper()
+	keys, err := gameproto.ParseTicketKeys("k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatalf("ParseTicketKeys: %v", err)
+	}
+	return keys
+}
+
+func TestTicketAuthVerify(t *testing.T) {
+	keys := testTicketKeys(t)
+	now := time.Unix(1_800_000_000, 0)
+	issue := func(serverID string, sessionID uint64) []byte {
+		b, err := keys.Issue(serverID, sessionID, time.Minute, now)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		return b
+	}
+
+	type attempt struct {
+		ticket    []byte
+		sessionID uint64
+		addr      string
+		at        time.Time
+		wantErr   bool
+	}
+	tests := []struct {
+		name         string
+		attempts     []attempt
+		wantAccepted uint64
+		wantRejected map[ticketRejectReason]uint64
+	}{
+		{
+			name:         "有效票据",
+			attempts:     []attempt{{issue("battle-1", 7), 7, "1.1.1.1:1000", now, false}},
+			wantAccepted: 1,
+		},
+		{
+			name:         "热备可接受主实例的票据",
+			attempts:     []attempt{{issue("battle-primary", 7), 7, "1.1.1.1:1000", now, false}},
+			wantAccepted: 1,
+		},
+		{
+			name:         "其他服务器的票据",
+			attempts:     []attempt{{issue("battle-2", 7), 7, "1.1.1.1:1000", now, true}},
+			wantRejected: map[ticketRejectReason]uint64{rejectServer: 1},
+		},
+		{
+			name:         "会话ID不一致",
+			attempts:     []attempt{{issue("battle-1", 7), 8, "1.1.1.1:1000", now, true}},
+			wantRejected: map[ticketRejectReason]uint64{rejectSession: 1},
+		},
+		{
+			name:         "已过期",
+			attempts:     []attempt{{issue("battle-1", 7), 7, "1.1.1.1:1000", now.Add(2 * time.Minute), true}},
+			wantRejected: map[ticketRejectReason]uint64{rejectExpired: 1},
+		},
+		{
+			name:         "格式错误",
+			attempts:     []attempt{{[]byte("garbage"), 7, "1.1.1.1:1000", now, true}},
+			wantRejected: map[ticketRejectReason]uint64{rejectFormat: 1},
+		},
+		{
+			name: "同一地址重复使用",
+			attempts: []attempt{
+				{issue("battle-1", 7), 7, "1.1.1.1:1000", now, false},
+				{issue("battle-1", 7), 7, "1.1.1.1:1000", now.Add(time.Second), false},
+			},
+			wantAccepted: 2,
+		},
+		{
+			name: "其他地址重放",
+			attempts: []attempt{
+				{issue("battle-1", 7), 7, "1.1.1.1:1000", now, false},
+				{issue("battle-1", 7), 7, "2.2.2.2:2000", now.Add(time.Second), true},
+			},
+			wantAccepted: 1,
+			wantRejected: map[ticketRejectReason]uint64{rejectReplay: 1},
+		},
+		{
+			name: "其他会话的票据不受影响",
+			attempts: []attempt{
+				{issue("battle-1", 7), 7, "1.1.1.1:1000", now, false},
+				{issue("battle-1", 8), 8, "2.2.2.2:2000", now, false},
+			},
+			wantAccepted: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTicketAuth(keys, "battle-1", "battle-primary")
+			for i, at := range tt.attempts {
+				err := a.verify(at.ticket, at.sessionID, at.addr, at.at)
+				if (err != nil) != at.wantErr {
+					t.Fatalf("attempt %d: err = %v, wantErr %v", i, err, at.wantErr)
+				}
+			}
+			if got := a.accepted.Load(); got != tt.wantAccepted {
+				t.Errorf("accepted = %d, want %d", got, tt.wantAccepted)
+			}
+			for reason := ticketRejectReason(0); reason < numRejectReasons; reason++ {
+				if got := a.rejected[reason].Load(); got != tt.wantRejected[reason] {
+					t.Errorf("rejected[%s] = %d, want %d", ticketRejectReasonNames[reason], got, tt.wantRejected[reason])
+				}
+			}
+		})
+	}
+}
+
+func TestTicketAuthPrunesExpiredTickets(t *testing.T) {
+	a := newTicketAuth(testTicketKeys(t), "battle-1")
+	now := time.Unix(1_800_000_000, 0)
+	ticket, _ := a.keys.Issue("battle-1", 7, time.Minute, now)
+	if err := a.verify(ticket, 7, "1.1.1.1:1000", now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// 过期后使用记录被清理，票据本身也无法再通过校验
+	later := now.Add(2 * time.Minute)
+	fresh, _ := a.keys.Issue("battle-1", 8, time.Minute, later)
+	if err := a.verify(fresh, 8, "2.2.2.2:2000", later); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if _, ok := a.used[7]; ok {
+		t.Error("过期票据的使用记录未被清理")
+	}
+	if err := a.verify(ticket, 7, "3.3.3.3:3000", later); !errors.Is(err, gameproto.ErrTicketExpired) {
+		t.Errorf("err = %v, want %v", err, gameproto.ErrTicketExpired)
+	}
+}
+
+// TestHelloRequiresTicket 启用票据校验后只有携带有效票据的 HELLO 能建立会话
+func TestHelloRequiresTicket(t *testing.T) {
+	gs, err := NewGameServer("battle-1", 0, 7001, "127.0.0.1:8500")
+	if err != nil {
+		t.Fatalf("NewGameServer: %v", err)
+	}
+	gs.tickets = newTicketAuth(testTicketKeys(t), "battle-1")
+	client := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}
+	battle := &gameproto.Frame{Type: gameproto.TypeBattle, Seq: 2, SessionID: 7}
+
+	if sess := gs.session(battle, client, client); sess != nil || gs.tickets.unauthenticated.Load() != 1 {
+		t.Fatalf("未认证的帧建立了会话")
+	}
+	if gs.textAllowed([]byte("BATTLE:attack")) || !gs.textAllowed([]byte("PING")) {
+		t.Error("启用票据校验后文本协议只应接受 PING")
+	}
+
+	if replies := gs.hello(&gameproto.Frame{Type: gameproto.TypeHello, Seq: 1, SessionID: 7, Payload: []byte("forged")}, client, client); replies != nil {
+		t.Fatalf("伪造票据得到回复 %+v", replies)
+	}
+	if gs.Sessions.Find(client, 7) != nil {
+		t.Fatal("伪造票据建立了会话")
+	}
+
+	ticket, _ := gs.tickets.keys.Issue("battle-1", 7, time.Minute, time.Now())
+	replies := gs.hello(&gameproto.Frame{Type: gameproto.TypeHello, Seq: 1, SessionID: 7, Payload: ticket}, client, client)
+	if len(replies) != 1 || replies[0].Type != gameproto.TypeWelcome || replies[0].SessionID != 7 {
+		t.Fatalf("replies = %+v, want WELCOME", replies)
+	}
+	if sess := gs.session(battle, client, client); sess == nil || !gs.authenticated(battle, client) {
+		t.Fatal("认证后的会话未被识别")
+	}
+
+	// 会话已建立时重发 HELLO 不再校验票据
+	if replies := gs.hello(&gameproto.Frame{Type: gameproto.TypeHello, Seq: 1, SessionID: 7}, client, client); len(replies) != 1 {
+		t.Errorf("重发 HELLO 未得到回复")
+	}
+	other := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5000}
+	if replies := gs.hello(&gameproto.Frame{Type: gameproto.TypeHello, Seq: 1, SessionID: 7, Payload: ticket}, other, other); replies != nil {
+		t.Error("其他地址重放票据得到回复")
+	}
+}
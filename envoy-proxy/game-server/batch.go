@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const defaultBatchSize = 32
+
+// batchConn 批量收发接口，ipv4.PacketConn 与 ipv6.PacketConn 都满足（两者的 Message 是同一类型）
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// udpSocket 一个读取套接字。batch 非空时使用 recvmmsg/sendmmsg 批量收发
+type udpSocket struct {
+	conn  *net.UDPConn
+	batch batchConn
+}
+
+// newUDPSocket 包装套接字。batchSize<=1 或非Linux平台（x/net 在其他平台上每次只收发一个数据报）时不启用批量收发
+func newUDPSocket(conn *net.UDPConn, batchSize int) *udpSocket {
+	sock := &udpSocket{conn: conn}
+	if batchSize <= 1 || runtime.GOOS != "linux" {
+		return sock
+	}
+
+	// 监听通配地址时Go创建的是双栈IPv6套接字
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil && !addr.IP.IsUnspecified() {
+		sock.batch = ipv4.NewPacketConn(conn)
+	} else {
+		sock.batch = ipv6.NewPacketConn(conn)
+	}
+	return sock
+}
+
+// ioStats 系统调用与数据报计数，平均批量大小 = 数据报数 / 系统调用数
+type ioStats struct {
+	reads        atomic.Uint64
+	readPackets  atomic.Uint64
+	writes       atomic.Uint64
+	writePackets atomic.Uint64
+	writeErrors  atomic.Uint64
+	truncated    atomic.Uint64 // 超过 MAX_DATAGRAM_SIZE 被丢弃的数据报
+	fragmented   atomic.Uint64 // 拆分为多个分片发送的响应
+	// badProxyHeaders PROXY协议头无效或来自不可信来源而被丢弃的数据报
+	badProxyHeaders atomic.Uint64
+}
+
+func (s *ioStats) snapshot(batchSize int) map[string]interface{} {
+	avg := func(packets, calls uint64) float64 {
+		if calls == 0 {
+			return 0
+		}
+		return float64(packets) / float64(calls)
+	}
+	reads, readPackets := s.reads.Load(), s.readPackets.Load()
+	writes, writePackets := s.writes.Load(), s.writePackets.Load()
+	return map[string]interface{}{
+		"batch_size":      batchSize,
+		"read_syscalls":   reads,
+		"read_packets":    readPackets,
+		"avg_read_batch":  avg(readPackets, reads),
+		"write_syscalls":  writes,
+		"write_packets":   writePackets,
+		"avg_write_batch": avg(writePackets, writes),
+		"write_errors":    s.writeErrors.Load(),
+	}
+}
+
+// batchUnsupported 内核或套接字不支持 recvmmsg/sendmmsg
+func batchUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// readBatchLoop 以 recvmmsg 一次读取多个数据报。返回 false 表示连接已关闭，
+// 返回 true 表示批量读取不可用，调用方应回退为逐个读取
+func (gs *GameServer) readBatchLoop(sock *udpSocket) bool {
+	msgs := make([]ipv4.Message, gs.BatchSize)
+	bufs := make([]*[]byte, gs.BatchSize)
+	for i := range msgs {
+		bufs[i] = gs.workers.getBuffer()
+		msgs[i].Buffers = [][]byte{*bufs[i]}
+	}
+	defer func() {
+		for _, b := range bufs {
+			gs.workers.bufs.Put(b)
+		}
+	}()
+
+	for {
+		gs.liveness.heartbeat()
+		gs.signalTTL()
+
+		sock.conn.SetReadDeadline(time.Now().Add(udpReadHeartbeat))
+
+		n, err := sock.batch.ReadBatch(msgs, 0)
+		if err != nil {
+			if batchUnsupported(err) {
+				slog.Warn("不支持批量读取，回退为逐个读取", "error", err)
+				return true
+			}
+			if gs.readFailed(err) {
+				return false
+			}
+			continue
+		}
+		gs.liveness.markRead()
+		gs.io.reads.Add(1)
+		gs.io.readPackets.Add(uint64(n))
+
+		for i := 0; i < n; i++ {
+			addr, ok := msgs[i].Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			p := &packet{sock: sock, addr: addr, buf: bufs[i], n: msgs[i].N}
+			if gs.truncated(p) {
+				continue
+			}
+			gs.enqueue(p)
+
+			// 已交给处理协程的缓冲区换成新的
+			bufs[i] = gs.workers.getBuffer()
+			msgs[i].Buffers[0] = *bufs[i]
+		}
+	}
+}
+
+// outbox 处理协程待发送的响应，按套接字分组后批量发送
+type outbox struct {
+	io      *ioStats
+	pending map[*udpSocket][]ipv4.Message
+	n       int
+}
+
+func newOutbox(io *ioStats) *outbox {
+	return &outbox{io: io, pending: make(map[*udpSocket][]ipv4.Message)}
+}
+
+func (o *outbox) len() int {
+	return o.n
+}
+
+func (o *outbox) add(sock *udpSocket, addr *net.UDPAddr, b []byte) {
+	o.pending[sock] = append(o.pending[sock], ipv4.Message{Buffers: [][]byte{b}, Addr: addr})
+	o.n++
+}
+
+// flush 发送所有待发响应，sendmmsg 失败时回退为逐个发送
+func (o *outbox) flush() {
+	for sock, msgs := range o.pending {
+		if len(msgs) == 0 {
+			continue
+		}
+		o.write(sock, msgs)
+		clear(msgs)
+		o.pending[sock] = msgs[:0]
+	}
+	o.n = 0
+}
+
+func (o *outbox) write(sock *udpSocket, msgs []ipv4.Message) {
+	if sock.batch != nil && len(msgs) > 1 {
+		for len(msgs) > 0 {
+			n, err := sock.batch.WriteBatch(msgs, 0)
+			if err != nil {
+				if !batchUnsupported(err) {
+					slog.Warn("批量发送响应失败", "error", err)
+				}
+				break
+			}
+			o.io.writes.Add(1)
+			o.io.writePackets.Add(uint64(n))
+			msgs = msgs[n:]
+		}
+	}
+
+	for _, m := range msgs {
+		o.io.writes.Add(1)
+		if _, err := sock.conn.WriteToUDP(m.Buffers[0], m.Addr.(*net.UDPAddr)); err != nil {
+			o.io.writeErrors.Add(1)
+			slog.Warn("发送响应失败", "error", err)
+			continue
+		}
+		o.io.writePackets.Add(1)
+	}
+}
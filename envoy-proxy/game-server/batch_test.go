@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// BenchmarkOutboxWrite 单独比较发送路径：每轮发送一批响应，sendmmsg 一次系统调用发出整批，
+// 逐个发送时每个数据报一次 sendto。每个 op 是一个数据报
+func BenchmarkOutboxWrite(b *testing.B) {
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sink.Close()
+	// 不读取 sink，接收缓冲区满后内核直接丢弃，只测量发送开销
+	dst := sink.LocalAddr().(*net.UDPAddr)
+
+	for _, batch := range []int{1, 8, defaultBatchSize} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			var stats ioStats
+			sock := newUDPSocket(conn, batch)
+			out := newOutbox(&stats)
+			payload := make([]byte, 64)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				out.add(sock, dst, payload)
+				if out.len() >= batch {
+					out.flush()
+				}
+			}
+			out.flush()
+			b.StopTimer()
+
+			if stats.writeErrors.Load() != 0 {
+				b.Fatalf("发送失败 %d 次", stats.writeErrors.Load())
+			}
+			b.ReportMetric(float64(stats.writes.Load())/float64(b.N), "syscalls/op")
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"gameproto"
+)
+
+const (
+	// defaultMaxDatagramSize 与Envoy max_rx_datagram_size 的默认值一致
+	defaultMaxDatagramSize = 1500
+	// defaultMaxMessageSize 分片重组后单条消息的最大长度
+	defaultMaxMessageSize = gameproto.MaxPayloadSize
+	// fragmentTimeout 分片未在该时间内收齐则丢弃整条消息
+	fragmentTimeout = 5 * time.Second
+)
+
+// readBufferLen 接收缓冲区长度：最大数据报长度加上PROXY协议头，再多1字节用于识别被截断的数据报
+func (gs *GameServer) readBufferLen() int {
+	n := gs.MaxDatagramSize + 1
+	if gs.ProxyProtocol {
+		n += proxyV2MaxHeaderLen
+	}
+	return n
+}
+
+// truncated 判断读取到的数据报是否超过接收上限（缓冲区被填满即说明内核截断了数据报）
+func (gs *GameServer) truncated(p *packet) bool {
+	if p.n < len(*p.buf) {
+		return false
+	}
+	n := gs.io.truncated.Add(1)
+	if n&(n-1) == 0 {
+		slog.Warn("丢弃超过 MAX_DATAGRAM_SIZE 的数据报", "remote_addr", p.addr.String(), "max_datagram_size", gs.MaxDatagramSize, "truncated", n)
+	}
+	return true
+}
+
+// maxResponseDatagram 响应数据报的长度上限：不超过Envoy的接收上限，也不超过路径MTU以避免IP分片
+func (gs *GameServer) maxResponseDatagram() int {
+	return min(gs.MaxDatagramSize, gameproto.MaxDatagramForMTU(gs.PathMTU))
+}
+
+// reassemble 分片帧加入会话的重组器，消息收齐时返回完整帧；非分片帧原样返回
+func (gs *GameServer) reassemble(sess *Session, f *gameproto.Frame) *gameproto.Frame {
+	if f.Type != gameproto.TypeFragment {
+		return f
+	}
+
+	var full *gameproto.Frame
+	var err error
+	sess.withReassembler(func(r *gameproto.Reassembler) {
+		full, err = r.Add(f, time.Now())
+	}, gs.MaxMessageSize)
+	if err != nil {
+		slog.Warn("丢弃分片", "remote_addr", sess.Key, "error", err)
+		return nil
+	}
+	return full
+}
+
+// fragment 超过响应上限的响应拆分为分片
+func (gs *GameServer) fragment(resp *gameproto.Frame) []*gameproto.Frame {
+	frames, err := gameproto.Fragment(resp, gs.maxResponseDatagram())
+	if err != nil {
+		slog.Error("拆分响应失败", "error", err)
+		return nil
+	}
+	if len(frames) > 1 {
+		gs.io.fragmented.Add(1)
+	}
+	return frames
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"gameproto"
+)
+
+// ErrUnknownMessageType 没有为消息类型注册处理函数
+var ErrUnknownMessageType = errors.New("未知消息类型")
+
+// MessageContext 一次消息处理的上下文
+type MessageContext struct {
+	Server     *GameServer
+	ClientAddr *net.UDPAddr
+	Request    *gameproto.Frame
+	// Session 帧协议请求所属的玩家会话；文本协议（健康检查、调试）不建立会话，为 nil
+	Session *Session
+}
+
+// HandlerFunc 消息处理函数，返回响应类型与负载；返回错误时向客户端回复 ERROR 帧
+type HandlerFunc func(mc *MessageContext) (gameproto.MessageType, []byte, error)
+
+// Middleware 包装处理函数，用于日志、指标、鉴权等横切逻辑
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// handlerRegistry 消息类型到处理函数的注册表
+type handlerRegistry struct {
+	byType     map[gameproto.MessageType]HandlerFunc
+	middleware []Middleware
+}
+
+// Handle 注册消息类型的处理函数，需在 Start 之前调用
+func (gs *GameServer) Handle(t gameproto.MessageType, h HandlerFunc) {
+	if gs.handlers.byType == nil {
+		gs.handlers.byType = make(map[gameproto.MessageType]HandlerFunc)
+	}
+	gs.handlers.byType[t] = h
+}
+
+// Use 追加中间件，先注册的位于外层，需在 Start 之前调用
+func (gs *GameServer) Use(mw ...Middleware) {
+	gs.handlers.middleware = append(gs.handlers.middleware, mw...)
+}
+
+// dispatch 查找处理函数并经过中间件链执行；未注册的类型同样经过中间件，由最内层返回 ErrUnknownMessageType
+func (gs *GameServer) dispatch(mc *MessageContext) (gameproto.MessageType, []byte) {
+	h, ok := gs.handlers.byType[mc.Request.Type]
+	if !ok {
+		h = func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+			return 0, nil, fmt.Errorf("%w: %s", ErrUnknownMessageType, mc.Request.Type)
+		}
+	}
+	for i := len(gs.handlers.middleware) - 1; i >= 0; i-- {
+		h = gs.handlers.middleware[i](h)
+	}
+
+	respType, payload, err := h(mc)
+	if err != nil {
+		return gameproto.TypeError, fmt.Appendf(nil, "ERROR from server %s: %v", gs.ServerID, err)
+	}
+	return respType, payload
+}
+
+// registerDefaultHandlers 注册内置消息类型与默认中间件
+func (gs *GameServer) registerDefaultHandlers() {
+	// recoverMiddleware 位于默认中间件的最内层，panic 转为错误后仍被日志与统计记录
+	gs.Use(loggingMiddleware, gs.stats.middleware, gs.metrics.middleware, recoverMiddleware)
+
+	gs.Handle(gameproto.TypePing, func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+		return gameproto.TypePong, fmt.Appendf(nil, "PONG from server %s at %s", gs.ServerID, timestamp()), nil
+	})
+	gs.Handle(gameproto.TypeBattle, func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+		return gameproto.TypeBattleResponse, fmt.Appendf(nil, "BATTLE_RESPONSE from server %s: 战斗数据已处理 at %s", gs.ServerID, timestamp()), nil
+	})
+	gs.Handle(gameproto.TypeStatus, func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+		return gameproto.TypeStatusResponse, fmt.Appendf(nil, "STATUS_RESPONSE from server %s: 运行正常，端口 %d at %s",
+			gs.ServerID, gs.ListenPort, timestamp()), nil
+	})
+	gs.Handle(gameproto.TypeEcho, func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+		return gameproto.TypeEchoResponse, fmt.Appendf(nil, "ECHO from server %s: %s at %s", gs.ServerID, mc.Request.Payload, timestamp()), nil
+	})
+}
+
+func timestamp() string {
+	return time.Now().Format("2006-01-02 15:04:05")
+}
+
+// recoverMiddleware 捕获处理函数中的panic，避免单个消息拖垮读取循环
+func recoverMiddleware(next HandlerFunc) HandlerFunc {
+	return func(mc *MessageContext) (t gameproto.MessageType, payload []byte, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("处理消息时发生panic", "type", mc.Request.Type.String(), "remote_addr", mc.ClientAddr.String(), "panic", r, "stack", string(debug.Stack()))
+				t, payload, err = 0, nil, errors.New("服务器内部错误")
+			}
+		}()
+		return next(mc)
+	}
+}
+
+// loggingMiddleware 记录每条消息的类型、来源、耗时与结果，成功的消息仅在 debug 级别输出
+func loggingMiddleware(next HandlerFunc) HandlerFunc {
+	return func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+		start := time.Now()
+		t, payload, err := next(mc)
+
+		req := mc.Request
+		if err != nil {
+			slog.Warn("处理消息失败", "remote_addr", mc.ClientAddr.String(), "type", req.Type.String(),
+				"seq", req.Seq, "session", req.SessionID, "duration", time.Since(start), "error", err)
+		} else {
+			slog.Debug("处理消息", "remote_addr", mc.ClientAddr.String(), "type", req.Type.String(),
+				"seq", req.Seq, "session", req.SessionID, "len", len(req.Payload), "duration", time.Since(start))
+		}
+		return t, payload, err
+	}
+}
+
+// AuthMiddleware 对除 public 以外的消息类型执行鉴权，check 返回错误时拒绝处理
+func AuthMiddleware(check func(mc *MessageContext) error, public ...gameproto.MessageType) Middleware {
+	skip := make(map[gameproto.MessageType]bool, len(public))
+	for _, t := range public {
+		skip[t] = true
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+			if !skip[mc.Request.Type] {
+				if err := check(mc); err != nil {
+					return 0, nil, fmt.Errorf("鉴权失败: %w", err)
+				}
+			}
+			return next(mc)
+		}
+	}
+}
+
+// messageStats 按消息类型统计处理次数、错误次数与累计耗时
+type messageStats struct {
+	mu     sync.Mutex
+	byType map[gameproto.MessageType]*typeStats
+}
+
+type typeStats struct {
+	handled  uint64
+	errors   uint64
+	duration time.Duration
+}
+
+func (s *messageStats) middleware(next HandlerFunc) HandlerFunc {
+	return func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+		start := time.Now()
+		t, payload, err := next(mc)
+		elapsed := time.Since(start)
+
+		s.mu.Lock()
+		if s.byType == nil {
+			s.byType = make(map[gameproto.MessageType]*typeStats)
+		}
+		ts, ok := s.byType[mc.Request.Type]
+		if !ok {
+			ts = &typeStats{}
+			s.byType[mc.Request.Type] = ts
+		}
+		ts.handled++
+		ts.duration += elapsed
+		if err != nil {
+			ts.errors++
+		}
+		s.mu.Unlock()
+
+		return t, payload, err
+	}
+}
+
+// snapshot 返回按类型名排序的统计，用于 GetServerInfo
+func (s *messageStats) snapshot() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]map[string]interface{}, 0, len(s.byType))
+	for t, ts := range s.byType {
+		var avg time.Duration
+		if ts.handled > 0 {
+			avg = ts.duration / time.Duration(ts.handled)
+		}
+		out = append(out, map[string]interface{}{
+			"type":    t.String(),
+			"handled": ts.handled,
+			"errors":  ts.errors,
+			"avg_us":  avg.Microseconds(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i]["type"].(string) < out[j]["type"].(string) })
+	return out
+}
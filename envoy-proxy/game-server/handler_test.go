@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gameproto"
+)
+
+// reply 返回固定响应的处理函数
+func reply(t gameproto.MessageType, payload string) HandlerFunc {
+	return func(*MessageContext) (gameproto.MessageType, []byte, error) {
+		return t, []byte(payload), nil
+	}
+}
+
+// tracing 记录进入与离开顺序的中间件
+func tracing(name string, trace *[]string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+			*trace = append(*trace, name+">")
+			t, payload, err := next(mc)
+			*trace = append(*trace, "<"+name)
+			return t, payload, err
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	var trace []string
+	errDenied := errors.New("票据无效")
+	tests := []struct {
+		name        string
+		setup       func(gs *GameServer)
+		reqType     gameproto.MessageType
+		wantType    gameproto.MessageType
+		wantPayload string // 响应负载需包含的内容
+		wantTrace   string
+	}{
+		{
+			name:        "按类型调用注册的处理函数",
+			setup:       func(gs *GameServer) { gs.Handle(gameproto.TypePing, reply(gameproto.TypePong, "pong")) },
+			reqType:     gameproto.TypePing,
+			wantType:    gameproto.TypePong,
+			wantPayload: "pong",
+		},
+		{
+			name: "重复注册以后者为准",
+			setup: func(gs *GameServer) {
+				gs.Handle(gameproto.TypeEcho, reply(gameproto.TypeEchoResponse, "旧"))
+				gs.Handle(gameproto.TypeEcho, reply(gameproto.TypeEchoResponse, "新"))
+			},
+			reqType:     gameproto.TypeEcho,
+			wantType:    gameproto.TypeEchoResponse,
+			wantPayload: "新",
+		},
+		{
+			name:        "未注册的类型回复 ERROR",
+			setup:       func(gs *GameServer) { gs.Handle(gameproto.TypePing, reply(gameproto.TypePong, "pong")) },
+			reqType:     gameproto.TypeBattle,
+			wantType:    gameproto.TypeError,
+			wantPayload: "ERROR from server battle-1: " + ErrUnknownMessageType.Error() + ": BATTLE",
+		},
+		{
+			name: "先注册的中间件位于外层",
+			setup: func(gs *GameServer) {
+				gs.Use(tracing("a", &trace), tracing("b", &trace))
+				gs.Use(tracing("c", &trace))
+				gs.Handle(gameproto.TypePing, reply(gameproto.TypePong, "pong"))
+			},
+			reqType:   gameproto.TypePing,
+			wantType:  gameproto.TypePong,
+			wantTrace: "a> b> c> <c <b <a",
+		},
+		{
+			name: "未注册的类型同样经过中间件",
+			setup: func(gs *GameServer) {
+				gs.Use(tracing("a", &trace))
+			},
+			reqType:   gameproto.TypeStatus,
+			wantType:  gameproto.TypeError,
+			wantTrace: "a> <a",
+		},
+		{
+			name: "处理函数返回错误时回复 ERROR",
+			setup: func(gs *GameServer) {
+				gs.Handle(gameproto.TypeBattle, func(*MessageContext) (gameproto.MessageType, []byte, error) {
+					return gameproto.TypeBattleResponse, []byte("部分结果"), errors.New("房间已关闭")
+				})
+			},
+			reqType:     gameproto.TypeBattle,
+			wantType:    gameproto.TypeError,
+			wantPayload: "ERROR from server battle-1: 房间已关闭",
+		},
+		{
+			name: "panic 被捕获并回复 ERROR",
+			setup: func(gs *GameServer) {
+				gs.Use(recoverMiddleware)
+				gs.Handle(gameproto.TypeBattle, func(*MessageContext) (gameproto.MessageType, []byte, error) {
+					panic("nil map")
+				})
+			},
+			reqType:     gameproto.TypeBattle,
+			wantType:    gameproto.TypeError,
+			wantPayload: "ERROR from server battle-1: 服务器内部错误",
+		},
+		{
+			name: "鉴权失败时不调用处理函数",
+			setup: func(gs *GameServer) {
+				gs.Use(AuthMiddleware(func(*MessageContext) error { return errDenied }, gameproto.TypePing), tracing("inner", &trace))
+				gs.Handle(gameproto.TypeBattle, reply(gameproto.TypeBattleResponse, "battle"))
+			},
+			reqType:     gameproto.TypeBattle,
+			wantType:    gameproto.TypeError,
+			wantPayload: "鉴权失败: 票据无效",
+			wantTrace:   "",
+		},
+		{
+			name: "公开类型跳过鉴权",
+			setup: func(gs *GameServer) {
+				gs.Use(AuthMiddleware(func(*MessageContext) error { return errDenied }, gameproto.TypePing, gameproto.TypeStatus))
+				gs.Handle(gameproto.TypePing, reply(gameproto.TypePong, "pong"))
+			},
+			reqType:     gameproto.TypePing,
+			wantType:    gameproto.TypePong,
+			wantPayload: "pong",
+		},
+		{
+			name: "鉴权通过后调用处理函数",
+			setup: func(gs *GameServer) {
+				gs.Use(AuthMiddleware(func(mc *MessageContext) error {
+					if mc.Request.SessionID != 7 {
+						return errDenied
+					}
+					return nil
+				}))
+				gs.Handle(gameproto.TypeBattle, reply(gameproto.TypeBattleResponse, "battle"))
+			},
+			reqType:     gameproto.TypeBattle,
+			wantType:    gameproto.TypeBattleResponse,
+			wantPayload: "battle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace = nil
+			gs := &GameServer{ServerID: "battle-1"}
+			tt.setup(gs)
+
+			req := &gameproto.Frame{Type: tt.reqType, Seq: 1, SessionID: 7}
+			gotType, payload := gs.dispatch(&MessageContext{Server: gs, ClientAddr: udpAddr("10.0.0.1:5000"), Request: req})
+			if gotType != tt.wantType {
+				t.Errorf("type = %v, want %v", gotType, tt.wantType)
+			}
+			if !strings.Contains(string(payload), tt.wantPayload) {
+				t.Errorf("payload = %q, want containing %q", payload, tt.wantPayload)
+			}
+			if got := strings.Join(trace, " "); got != tt.wantTrace {
+				t.Errorf("trace = %q, want %q", got, tt.wantTrace)
+			}
+		})
+	}
+}
+
+// TestDefaultHandlers 内置处理函数与默认中间件：未知类型与panic都计入按类型的错误统计
+func TestDefaultHandlers(t *testing.T) {
+	gs, err := NewGameServer("battle-1", 0, 7001, "127.0.0.1:8500")
+	if err != nil {
+		t.Fatalf("NewGameServer: %v", err)
+	}
+	gs.Handle(gameproto.TypeAck, func(*MessageContext) (gameproto.MessageType, []byte, error) {
+		panic("boom")
+	})
+
+	tests := []struct {
+		reqType  gameproto.MessageType
+		wantType gameproto.MessageType
+	}{
+		{gameproto.TypePing, gameproto.TypePong},
+		{gameproto.TypeBattle, gameproto.TypeBattleResponse},
+		{gameproto.TypeStatus, gameproto.TypeStatusResponse},
+		{gameproto.TypeEcho, gameproto.TypeEchoResponse},
+		{gameproto.TypeFragment, gameproto.TypeError},
+		{gameproto.TypeAck, gameproto.TypeError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reqType.String(), func(t *testing.T) {
+			req := &gameproto.Frame{Type: tt.reqType, Seq: 1, Payload: []byte("hi")}
+			gotType, payload := gs.dispatch(&MessageContext{Server: gs, ClientAddr: udpAddr("10.0.0.1:5000"), Request: req})
+			if gotType != tt.wantType || !strings.Contains(string(payload), "battle-1") {
+				t.Errorf("dispatch = %v %q, want %v", gotType, payload, tt.wantType)
+			}
+		})
+	}
+
+	errors := make(map[string]uint64)
+	for _, s := range gs.stats.snapshot() {
+		errors[s["type"].(string)] = s["errors"].(uint64)
+	}
+	if errors[gameproto.TypePing.String()] != 0 || errors[gameproto.TypeFragment.String()] != 1 || errors[gameproto.TypeAck.String()] != 1 {
+		t.Errorf("errors = %v", errors)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"common"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+const (
+	udpReadHeartbeat  = time.Second     // 读取超时，保证没有流量时读取循环也会更新心跳
+	udpHeartbeatStale = 5 * time.Second // 心跳超过该时长未更新视为读取循环卡死或退出
+	udpErrorThreshold = 10              // 连续读取错误达到该值视为UDP路径故障
+
+	udpSelfProbeInterval      = 5 * time.Second
+	udpSelfProbeTimeout       = time.Second
+	udpSelfProbeFailThreshold = 2
+
+	udpTTL              = "15s"           // Consul TTL检查超时，读取循环停止更新后自动变为critical
+	udpTTLUpdateEvery   = 5 * time.Second // 正常情况下的TTL更新间隔
+	udpTTLRetryInterval = time.Second     // 更新失败（如尚未注册）后的重试间隔
+)
+
+// udpCheckID 读取循环驱动的Consul TTL检查ID
+func udpCheckID(serverID string) string {
+	return serverID + ":udp"
+}
+
+// udpLiveness UDP读取循环的存活状态，由读取循环与自检协程并发更新
+type udpLiveness struct {
+	lastRead          atomic.Int64 // 最近一次收到数据报的时间(UnixNano)
+	lastHeartbeat     atomic.Int64 // 读取循环最近一次迭代的时间(UnixNano)
+	readErrors        atomic.Uint64
+	consecutiveErrors atomic.Int64
+	probeFailures     atomic.Int64 // 自检连续失败次数
+	lastProbeError    atomic.Value // string
+}
+
+func (l *udpLiveness) heartbeat() {
+	l.lastHeartbeat.Store(time.Now().UnixNano())
+}
+
+func (l *udpLiveness) markRead() {
+	l.lastRead.Store(time.Now().UnixNano())
+	l.consecutiveErrors.Store(0)
+}
+
+func (l *udpLiveness) markError() {
+	l.readErrors.Add(1)
+	l.consecutiveErrors.Add(1)
+}
+
+func (l *udpLiveness) markProbe(err error) {
+	if err == nil {
+		l.probeFailures.Store(0)
+		l.lastProbeError.Store("")
+		return
+	}
+	l.probeFailures.Add(1)
+	l.lastProbeError.Store(err.Error())
+}
+
+// check 判断UDP路径是否正常，不正常时返回原因
+func (l *udpLiveness) check(now time.Time) (bool, string) {
+	last := l.lastHeartbeat.Load()
+	if last == 0 {
+		return false, "UDP读取循环尚未启动"
+	}
+	if since := now.Sub(time.Unix(0, last)); since > udpHeartbeatStale {
+		return false, fmt.Sprintf("UDP读取循环 %v 未更新心跳", since.Round(time.Second))
+	}
+	if n := l.consecutiveErrors.Load(); n >= udpErrorThreshold {
+		return false, fmt.Sprintf("UDP连续读取失败 %d 次", n)
+	}
+	if n := l.probeFailures.Load(); n >= udpSelfProbeFailThreshold {
+		reason, _ := l.lastProbeError.Load().(string)
+		return false, fmt.Sprintf("UDP自检连续失败 %d 次: %s", n, reason)
+	}
+	return true, ""
+}
+
+// details 健康检查接口中输出的UDP状态
+func (l *udpLiveness) details() map[string]interface{} {
+	d := map[string]interface{}{
+		"read_errors":        l.readErrors.Load(),
+		"consecutive_errors": l.consecutiveErrors.Load(),
+		"probe_failures":     l.probeFailures.Load(),
+	}
+	if t := l.lastRead.Load(); t != 0 {
+		d["last_read"] = time.Unix(0, t).Format(time.RFC3339)
+	}
+	if t := l.lastHeartbeat.Load(); t != 0 {
+		d["last_heartbeat"] = time.Unix(0, t).Format(time.RFC3339)
+	}
+	return d
+}
+
+// signalTTL 由读取循环调用，通知TTL更新协程循环仍在运行；不阻塞读取
+func (gs *GameServer) signalTTL() {
+	select {
+	case gs.ttlCh <- struct{}{}:
+	default:
+	}
+}
+
+// runTTLUpdater 在读取循环的驱动下更新Consul TTL检查。读取循环停止后不再更新，TTL到期后Consul将其标记为critical
+func (gs *GameServer) runTTLUpdater(ctx context.Context) {
+	checkID := udpCheckID(gs.ServerID)
+	var lastAttempt time.Time
+	lastOK := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-gs.ttlCh:
+		}
+
+		wait := udpTTLUpdateEvery
+		if !lastOK {
+			wait = udpTTLRetryInterval
+		}
+		if time.Since(lastAttempt) < wait {
+			continue
+		}
+		lastAttempt = time.Now()
+
+		status, output := consulapi.HealthPassing, "UDP读取循环正常"
+		if ok, reason := gs.liveness.check(time.Now()); !ok {
+			status, output = consulapi.HealthCritical, reason
+		}
+
+		err := gs.Registry.Client.Agent().UpdateTTL(checkID, output, status)
+		if err != nil && lastOK {
+			slog.Warn("更新Consul TTL检查失败", "error", common.ConsulAccessError(err))
+		}
+		lastOK = err == nil
+	}
+}
+
+// runSelfProbe 定期从本机向UDP端口发送 PING，验证读取与处理路径端到端可用
+func (gs *GameServer) runSelfProbe(ctx context.Context) {
+	ticker := time.NewTicker(udpSelfProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := selfProbe(gs.ListenPort)
+			if err != nil && gs.liveness.probeFailures.Load() == 0 {
+				slog.Warn("UDP自检失败", "error", err)
+			}
+			gs.liveness.markProbe(err)
+		}
+	}
+}
+
+func selfProbe(port int) error {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		return fmt.Errorf("连接本机UDP端口失败: %v", err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(udpSelfProbeTimeout))
+
+	if _, err := conn.Write([]byte("PING")); err != nil {
+		return fmt.Errorf("发送自检数据报失败: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("等待自检响应失败: %v", err)
+	}
+	if !bytes.HasPrefix(buf[:n], []byte("PONG")) {
+		return fmt.Errorf("自检响应不匹配: %q", buf[:n])
+	}
+	return nil
+}
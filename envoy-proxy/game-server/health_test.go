@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUDPLivenessCheck(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	probeErr := errors.New("等待自检响应失败: i/o timeout")
+	tests := []struct {
+		name       string
+		heartbeat  time.Duration // 心跳距 now 的时长，负数表示从未更新
+		errors     int           // 连续读取错误次数
+		reads      bool          // 错误之后是否又成功收到数据报
+		probes     []error       // 依次记录的自检结果
+		wantOK     bool
+		wantReason string
+	}{
+		{name: "正常", heartbeat: time.Second, wantOK: true},
+		{name: "读取循环尚未启动", heartbeat: -1, wantReason: "尚未启动"},
+		{name: "心跳恰好到达期限", heartbeat: udpHeartbeatStale, wantOK: true},
+		{name: "心跳过期", heartbeat: udpHeartbeatStale + time.Second, wantReason: "6s 未更新心跳"},
+		{name: "连续读取错误未达阈值", heartbeat: time.Second, errors: udpErrorThreshold - 1, wantOK: true},
+		{name: "连续读取错误达到阈值", heartbeat: time.Second, errors: udpErrorThreshold, wantReason: "连续读取失败 10 次"},
+		{name: "收到数据报后错误计数清零", heartbeat: time.Second, errors: udpErrorThreshold, reads: true, wantOK: true},
+		{name: "自检失败一次", heartbeat: time.Second, probes: []error{probeErr}, wantOK: true},
+		{name: "自检连续失败", heartbeat: time.Second, probes: []error{probeErr, probeErr}, wantReason: "自检连续失败 2 次: 等待自检响应失败"},
+		{name: "自检成功打断连续失败", heartbeat: time.Second, probes: []error{probeErr, nil, probeErr}, wantOK: true},
+		{name: "心跳过期优先于其他原因", heartbeat: time.Minute, errors: udpErrorThreshold, probes: []error{probeErr, probeErr}, wantReason: "未更新心跳"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l udpLiveness
+			if tt.heartbeat >= 0 {
+				l.lastHeartbeat.Store(now.Add(-tt.heartbeat).UnixNano())
+			}
+			for i := 0; i < tt.errors; i++ {
+				l.markError()
+			}
+			if tt.reads {
+				l.markRead()
+			}
+			for _, err := range tt.probes {
+				l.markProbe(err)
+			}
+
+			ok, reason := l.check(now)
+			if ok != tt.wantOK || !strings.Contains(reason, tt.wantReason) || (ok && reason != "") {
+				t.Errorf("check = %v %q, want %v containing %q", ok, reason, tt.wantOK, tt.wantReason)
+			}
+			if got := l.details()["read_errors"]; got != uint64(tt.errors) {
+				t.Errorf("read_errors = %v, want %d", got, tt.errors)
+			}
+		})
+	}
+}
@@ -1,42 +1,66 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"common"
+	"gameproto"
+
 	consulapi "github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("game-server")
+
 // ConsulRegistry Consul服务注册器
 type ConsulRegistry struct {
 	Client *consulapi.Client
+	// ExtraMeta 附加到注册信息中的元数据，用于向控制平面传递战斗服级别的配置（如 standby_for）
+	ExtraMeta map[string]string
+
+	status registrationStatus
 }
 
-// NewConsulRegistry 创建Consul注册器
+// NewConsulRegistry 创建Consul注册器，地址与认证配置见 common.NewConsulClient
 func NewConsulRegistry(consulAddr string) (*ConsulRegistry, error) {
-	config := consulapi.DefaultConfig()
-	config.Address = consulAddr
-
-	client, err := consulapi.NewClient(config)
+	client, err := common.NewConsulClient(consulAddr)
 	if err != nil {
-		return nil, fmt.Errorf("创建Consul客户端失败: %v", err)
+		return nil, err
 	}
 
-	return &ConsulRegistry{Client: client}, nil
+	return &ConsulRegistry{Client: client, ExtraMeta: make(map[string]string)}, nil
 }
 
-// RegisterGameServer 注册游戏服务器到Consul
-func (cr *ConsulRegistry) RegisterGameServer(serverID string, serverIP string, serverPort int, externalPort int) error {
+// RegisterGameServer 注册游戏服务器到Consul。注册所在的链路上下文写入元数据，
+// 控制平面据此记录从注册到Envoy开始转发的耗时
+func (cr *ConsulRegistry) RegisterGameServer(ctx context.Context, serverID string, serverIP string, serverPort int, externalPort int) error {
 	healthPort := serverPort + 1000
 
+	ctx, span := tracer.Start(ctx, "RegisterGameServer", trace.WithAttributes(
+		attribute.String("server_id", serverID),
+		attribute.Int("external_port", externalPort),
+	))
+	defer span.End()
+
 	registration := &consulapi.AgentServiceRegistration{
 		ID:      serverID,
 		Name:    "game-server", // 修改服务名为game-server
@@ -48,21 +72,46 @@ func (cr *ConsulRegistry) RegisterGameServer(serverID string, serverIP string, s
 			"server_type":         "game",
 			"registered_at":       time.Now().Format("2006-01-02 15:04:05"),
 			"envoy_external_port": fmt.Sprintf("%d", externalPort), // 为Envoy动态端口转发指定外部端口
+			"metrics_port":        fmt.Sprintf("%d", healthPort),   // Prometheus通过Consul服务发现抓取 /metrics
 		},
-		Check: &consulapi.AgentServiceCheck{
-			DeregisterCriticalServiceAfter: "5m",
-			HTTP:                           fmt.Sprintf("http://%s:%d/health", serverIP, healthPort),
-			Interval:                       "10s",
-			Timeout:                        "2s",
+		Checks: consulapi.AgentServiceChecks{
+			{
+				DeregisterCriticalServiceAfter: "5m",
+				HTTP:                           fmt.Sprintf("http://%s:%d/health", serverIP, healthPort),
+				Interval:                       "10s",
+				Timeout:                        "2s",
+			},
+			{
+				// 由UDP读取循环驱动更新，循环卡死或退出后TTL到期自动变为critical
+				CheckID:                        udpCheckID(serverID),
+				Name:                           "UDP读取循环",
+				TTL:                            udpTTL,
+				DeregisterCriticalServiceAfter: "5m",
+			},
 		},
 	}
 
+	for k, v := range cr.ExtraMeta {
+		registration.Meta[k] = v
+	}
+	common.InjectTraceParent(ctx, registration.Meta)
+
 	err := cr.Client.Agent().ServiceRegister(registration)
 	if err != nil {
-		return fmt.Errorf("注册服务到Consul失败: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("注册服务到Consul失败: %w", common.ConsulAccessError(err))
 	}
 
-	log.Printf("✅ 游戏服务器 %s 已成功注册到Consul: %s:%d (外部端口: %d)", serverID, serverIP, serverPort, externalPort)
+	slog.Info("已注册到Consul", "server_id", serverID, "address", serverIP, "port", serverPort, "external_port", externalPort, "health_port", healthPort)
+	return nil
+}
+
+// EnableMaintenance 将服务置于维护模式，Consul随即把服务视为不健康，不再向其路由新流量
+func (cr *ConsulRegistry) EnableMaintenance(serverID, reason string) error {
+	if err := cr.Client.Agent().EnableServiceMaintenance(serverID, reason); err != nil {
+		return fmt.Errorf("设置维护模式失败: %w", common.ConsulAccessError(err))
+	}
+	slog.Info("已进入Consul维护模式", "server_id", serverID)
 	return nil
 }
 
@@ -70,10 +119,10 @@ func (cr *ConsulRegistry) RegisterGameServer(serverID string, serverIP string, s
 func (cr *ConsulRegistry) DeregisterGameServer(serverID string) error {
 	err := cr.Client.Agent().ServiceDeregister(serverID)
 	if err != nil {
-		return fmt.Errorf("从Consul注销服务失败: %v", err)
+		return fmt.Errorf("从Consul注销服务失败: %w", common.ConsulAccessError(err))
 	}
 
-	log.Printf("✅ 游戏服务器 %s 已从Consul注销", serverID)
+	slog.Info("已从Consul注销", "server_id", serverID)
 	return nil
 }
 
@@ -81,9 +130,41 @@ func (cr *ConsulRegistry) DeregisterGameServer(serverID string) error {
 type GameServer struct {
 	ServerID     string
 	ListenPort   int
-	ExternalPort int // 对应的外部UDP端口
-	Conn         *net.UDPConn
-	Registry     *ConsulRegistry
+	ExternalPort int          // 对应的外部UDP端口
+	Conn         *net.UDPConn // 第一个读取套接字，用于主动发送（如可靠帧重传）
+	// Readers 读取套接字数量，大于1时使用 SO_REUSEPORT；Workers 处理协程数；QueueSize 每个处理协程的队列长度
+	Readers   int
+	Workers   int
+	QueueSize int
+	// BatchSize 每次 recvmmsg/sendmmsg 最多收发的数据报数，1 表示逐个收发
+	BatchSize int
+	// MaxDatagramSize 接收数据报的最大长度，需与控制平面下发给Envoy的 max_rx_datagram_size 一致；
+	// PathMTU 到客户端的路径MTU，响应超过两者限制时拆分为分片；MaxMessageSize 分片重组后的消息上限
+	MaxDatagramSize int
+	PathMTU         int
+	MaxMessageSize  int
+	Registry        *ConsulRegistry
+	// ProxyProtocol 为 true 时解析前端负载均衡附加的PROXY protocol v2头以获取客户端原始地址，
+	// 只信任来自 TrustedProxies 的协议头，否则任何客户端都能自行附加协议头伪造源地址
+	ProxyProtocol  bool
+	TrustedProxies []netip.Prefix
+	Sessions       *SessionManager
+
+	sockets  []*udpSocket
+	tickets  *ticketAuth    // 配置 TICKET_KEYS 时创建，要求客户端先以 HELLO 出示票据
+	limiter  *sourceLimiter // 启用按来源限流、黑名单或防放大时创建
+	workers  *workerPool
+	io       ioStats
+	readers  sync.WaitGroup
+	handlers handlerRegistry
+	stats    messageStats
+	reliable reliableCounters
+	metrics  gameMetrics
+	liveness udpLiveness
+	draining atomic.Bool
+	ttlCh    chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
 // NewGameServer 创建新的游戏服务器实例
@@ -93,81 +174,117 @@ func NewGameServer(serverID string, port int, externalPort int, consulAddr strin
 		return nil, fmt.Errorf("创建Consul注册器失败: %v", err)
 	}
 
-	return &GameServer{
-		ServerID:     serverID,
-		ListenPort:   port,
-		ExternalPort: externalPort,
-		Registry:     registry,
-	}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gs := &GameServer{
+		ServerID:        serverID,
+		ListenPort:      port,
+		ExternalPort:    externalPort,
+		Registry:        registry,
+		Readers:         defaultUDPReaders,
+		Workers:         runtime.NumCPU(),
+		QueueSize:       defaultWorkerQueue,
+		BatchSize:       defaultBatchSize,
+		MaxDatagramSize: defaultMaxDatagramSize,
+		PathMTU:         gameproto.DefaultMTU,
+		MaxMessageSize:  defaultMaxMessageSize,
+		Sessions:        NewSessionManager(defaultSessionIdleTimeout, defaultMaxSessions),
+		ttlCh:           make(chan struct{}, 1),
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+	gs.registerDefaultHandlers()
+
+	return gs, nil
 }
 
 // Start 启动UDP服务器
 func (gs *GameServer) Start() error {
-	addr := fmt.Sprintf("0.0.0.0:%d", gs.ListenPort)
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return fmt.Errorf("解析UDP地址失败: %v", err)
+	if err := gs.startUDP(); err != nil {
+		return err
 	}
 
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		return fmt.Errorf("监听UDP端口失败: %v", err)
-	}
+	// 启动UDP自检与Consul TTL更新
+	go gs.runSelfProbe(gs.ctx)
+	go gs.runTTLUpdater(gs.ctx)
 
-	gs.Conn = conn
-	log.Printf("游戏服务器 %s 启动成功，监听端口: %d", gs.ServerID, gs.ListenPort)
+	// 回收空闲会话，重传未确认的可靠帧
+	go gs.Sessions.Run(gs.ctx)
+	go gs.runRetransmitter(gs.ctx)
 
-	// 启动消息处理循环
-	go gs.handleMessages()
+	// 回收空闲的限流令牌桶
+	if gs.limiter != nil {
+		go gs.limiter.Run(gs.ctx)
+	}
 
 	return nil
 }
 
-// handleMessages 处理接收到的UDP消息
-func (gs *GameServer) handleMessages() {
-	buffer := make([]byte, 1024)
-
-	for {
-		n, remoteAddr, err := gs.Conn.ReadFromUDP(buffer)
-		if err != nil {
-			log.Printf("读取UDP数据失败: %v", err)
-			continue
-		}
-
-		message := string(buffer[:n])
-		log.Printf("服务器 %s 收到来自 %s 的消息: %s", gs.ServerID, remoteAddr.String(), message)
-
-		// 处理不同类型的消息
-		response := gs.processMessage(message, remoteAddr)
+// startUDP 监听UDP端口并启动读取与处理协程
+func (gs *GameServer) startUDP() error {
+	addr := fmt.Sprintf("0.0.0.0:%d", gs.ListenPort)
+	conns, err := listenUDP(addr, gs.Readers)
+	if err != nil {
+		return fmt.Errorf("监听UDP端口失败: %v", err)
+	}
 
-		// 发送响应
-		if response != "" {
-			_, err = gs.Conn.WriteToUDP([]byte(response), remoteAddr)
-			if err != nil {
-				log.Printf("发送响应失败: %v", err)
-			}
-		}
+	gs.Conn = conns[0]
+	for _, conn := range conns {
+		gs.sockets = append(gs.sockets, newUDPSocket(conn, gs.BatchSize))
+	}
+	slog.Info("UDP服务启动成功", "port", gs.ListenPort, "readers", len(conns), "workers", gs.Workers,
+		"queue_size", gs.QueueSize, "batch_size", gs.BatchSize)
+
+	// 启动处理协程与读取循环
+	gs.workers = newWorkerPool(gs.Workers, gs.QueueSize, gs.readBufferLen(), gs.BatchSize, &gs.io, gs.processPacket)
+	gs.workers.start()
+	for _, sock := range gs.sockets {
+		gs.readers.Add(1)
+		go gs.readLoop(sock)
 	}
+	return nil
 }
 
-// processMessage 处理不同类型的消息
-func (gs *GameServer) processMessage(message string, remoteAddr *net.UDPAddr) string {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-
-	if strings.HasPrefix(message, "PING") {
-		return fmt.Sprintf("PONG from server %s at %s", gs.ServerID, timestamp)
+// stopUDP 关闭UDP连接，读取循环退出后处理完已入队的数据报
+func (gs *GameServer) stopUDP() {
+	for _, sock := range gs.sockets {
+		sock.conn.Close()
 	}
+	gs.readers.Wait()
+	gs.workers.stop()
+}
 
-	if strings.HasPrefix(message, "BATTLE") {
-		return fmt.Sprintf("BATTLE_RESPONSE from server %s: 战斗数据已处理 at %s", gs.ServerID, timestamp)
+// resolveClient 确定数据报对应的客户端地址。未启用PROXY协议或数据报不带协议头时使用对端地址；
+// 不在 TrustedProxies 中的对端携带协议头时返回错误，丢弃该数据报
+func (gs *GameServer) resolveClient(datagram []byte, remoteAddr *net.UDPAddr) ([]byte, *net.UDPAddr, error) {
+	if !gs.ProxyProtocol {
+		return datagram, remoteAddr, nil
+	}
+	if !containsAddr(gs.TrustedProxies, remoteAddr.AddrPort().Addr().Unmap()) {
+		if bytes.HasPrefix(datagram, proxyV2Signature) {
+			return nil, nil, errUntrustedProxyHeader
+		}
+		return datagram, remoteAddr, nil
 	}
 
-	if strings.HasPrefix(message, "STATUS") {
-		return fmt.Sprintf("STATUS_RESPONSE from server %s: 运行正常，端口 %d at %s",
-			gs.ServerID, gs.ListenPort, timestamp)
+	src, payload, _, err := parseProxyHeader(datagram)
+	if err != nil {
+		return nil, nil, err
 	}
+	if src == nil {
+		return payload, remoteAddr, nil
+	}
+	return payload, src, nil
+}
 
-	return fmt.Sprintf("ECHO from server %s: %s at %s", gs.ServerID, message, timestamp)
+// processMessage 处理文本协议消息，映射为消息类型后与帧协议共用处理函数注册表
+func (gs *GameServer) processMessage(message string, remoteAddr *net.UDPAddr) string {
+	req := parseTextMessage(message)
+	gs.metrics.received(req.Type, len(message))
+
+	respType, payload := gs.dispatch(&MessageContext{Server: gs, ClientAddr: remoteAddr, Request: req})
+	gs.metrics.sent(respType, len(payload))
+	return string(payload)
 }
 
 // GetServerInfo 获取服务器信息
@@ -178,33 +295,82 @@ func (gs *GameServer) GetServerInfo() map[string]interface{} {
 		"protocol":   "udp",
 		"status":     "running",
 		"started_at": time.Now().Format(time.RFC3339),
+		"messages":   gs.stats.snapshot(),
+		"sessions":   gs.Sessions.Stats(),
+		"reliable":   gs.reliableStats(),
+		"workers":    gs.workers.stats(),
+		"io":         gs.io.snapshot(gs.BatchSize),
+		"tickets":    gs.ticketStats(),
+		"rate_limit": gs.rateLimitStats(),
+	}
+}
+
+// rateLimitStats 限流统计，未启用时为 nil
+func (gs *GameServer) rateLimitStats() map[string]interface{} {
+	if gs.limiter == nil {
+		return nil
 	}
+	return gs.limiter.stats()
 }
 
-// startHealthCheckServer 启动HTTP健康检查服务器
-func startHealthCheckServer(port int) {
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"status": "healthy", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
-	})
+// ticketStats 票据校验统计，未启用时为 nil
+func (gs *GameServer) ticketStats() map[string]interface{} {
+	if gs.tickets == nil {
+		return nil
+	}
+	return gs.tickets.stats()
+}
 
-	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"status": "ready", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
-	})
+// startHealthCheckServer 启动HTTP健康检查服务器。UDP读取路径异常时 /health 与 /ready 返回503，
+// 优雅关闭期间 /ready 返回503
+func startHealthCheckServer(gs *GameServer, port int) *http.Server {
+	udpStatusHandler := func(okStatus, failStatus string, checkDraining bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ok, reason := gs.liveness.check(time.Now())
+			if ok && checkDraining && gs.Draining() {
+				ok, reason = false, "游戏服务器正在关闭"
+			}
+
+			code, status := http.StatusOK, okStatus
+			if !ok {
+				code, status = http.StatusServiceUnavailable, failStatus
+			}
 
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
-	log.Printf("健康检查服务器启动，监听端口: %d", port)
+			body := map[string]interface{}{
+				"status":    status,
+				"udp":       gs.liveness.details(),
+				"sessions":  gs.Sessions.Stats(),
+				"draining":  gs.Draining(),
+				"consul":    gs.Registry.Status(),
+				"timestamp": time.Now().Format(time.RFC3339),
+			}
+			if reason != "" {
+				body["reason"] = reason
+			}
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Printf("健康检查服务器启动失败: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(body)
+		}
 	}
+
+	http.HandleFunc("/health", udpStatusHandler("healthy", "unhealthy", false))
+	http.HandleFunc("/ready", udpStatusHandler("ready", "not_ready", true))
+	http.HandleFunc("/metrics", gs.MetricsHandler)
+
+	srv := &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", port)}
+	slog.Info("健康检查服务器启动", "port", port)
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("健康检查服务器启动失败", "error", err)
+		}
+	}()
+	return srv
 }
 
 // RegisterToConsul 注册到Consul
-func (gs *GameServer) RegisterToConsul() error {
+func (gs *GameServer) RegisterToConsul(ctx context.Context) error {
 	if gs.Registry == nil {
 		return fmt.Errorf("Consul注册器未初始化")
 	}
@@ -215,9 +381,9 @@ func (gs *GameServer) RegisterToConsul() error {
 		serverIP = gs.ServerID // 使用服务名作为IP（在Docker网络中可用）
 	}
 
-	err := gs.Registry.RegisterGameServer(gs.ServerID, serverIP, gs.ListenPort, gs.ExternalPort)
+	err := gs.Registry.RegisterGameServer(ctx, gs.ServerID, serverIP, gs.ListenPort, gs.ExternalPort)
 	if err != nil {
-		return fmt.Errorf("注册到Consul失败: %v", err)
+		return fmt.Errorf("注册到Consul失败: %w", err)
 	}
 
 	return nil
@@ -237,30 +403,18 @@ func (gs *GameServer) DeregisterFromConsul() error {
 	return nil
 }
 
-// registerWithRetry 带重试的 Consul 注册，应对 Consul 未就绪或重启
-func registerWithRetry(gs *GameServer, totalWait time.Duration, interval time.Duration) {
-	deadline := time.Now().Add(totalWait)
-	for time.Now().Before(deadline) {
-		if err := gs.RegisterToConsul(); err == nil {
-			return
-		}
-		log.Printf("⚠️ 注册到Consul失败，%v 后重试...", interval)
-		time.Sleep(interval)
-	}
-	log.Printf("⚠️ 游戏服务器将继续运行，但服务发现可能不可用（Consul 注册超时）")
-}
-
 // Stop 停止服务器
 func (gs *GameServer) Stop() {
+	gs.cancel()
+
 	// 从Consul注销
 	if err := gs.DeregisterFromConsul(); err != nil {
-		log.Printf("⚠️ 从Consul注销失败: %v", err)
+		slog.Warn("从Consul注销失败", "error", err)
 	}
 
-	// 关闭UDP连接
 	if gs.Conn != nil {
-		gs.Conn.Close()
-		log.Printf("游戏服务器 %s 已停止", gs.ServerID)
+		gs.stopUDP()
+		slog.Info("游戏服务器已停止")
 	}
 }
 
@@ -288,55 +442,172 @@ func main() {
 		}
 	}
 
-	// Consul地址配置（支持 http://host:port，客户端会去掉 scheme）
+	// 结构化日志，所有日志附带服务器ID与外部端口
+	slog.SetDefault(common.NewLogger("game-server").With("server_id", serverID, "external_port", externalPort))
+
+	// 链路追踪，OTEL_TRACES_EXPORTER 为空时不采集
+	shutdownTracing, err := common.SetupTracing(context.Background(), "game-server", attribute.String("server_id", serverID))
+	if err != nil {
+		slog.Error("初始化链路追踪失败", "error", err)
+		os.Exit(1)
+	}
+
+	// Consul地址配置（支持 http://host:port 与 https://host:port，https 时启用TLS）
 	consulAddr := os.Getenv("CONSUL_URL")
 	if consulAddr == "" {
 		consulAddr = "consul-server:8500"
 	}
-	consulAddr = strings.TrimPrefix(strings.TrimPrefix(consulAddr, "http://"), "https://")
 
 	// 创建并启动游戏服务器
 	gameServer, err := NewGameServer(serverID, port, externalPort, consulAddr)
 	if err != nil {
-		log.Fatalf("创建游戏服务器失败: %v", err)
+		slog.Error("创建游戏服务器失败", "error", err)
+		os.Exit(1)
 	}
 
-	// 启动HTTP健康检查服务器
-	go startHealthCheckServer(port + 1000)
+	// 前端为会附加PROXY协议头的UDP负载均衡时开启（Envoy的udp_proxy不会附加），必须同时指定可信的负载均衡地址
+	if proxyProtocol, err := strconv.ParseBool(os.Getenv("PROXY_PROTOCOL")); err == nil {
+		gameServer.ProxyProtocol = proxyProtocol
+	}
+	trustedProxies, err := parsePrefixes(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		slog.Error("配置错误", "error", fmt.Errorf("TRUSTED_PROXIES: %v", err))
+		os.Exit(1)
+	}
+	if gameServer.ProxyProtocol && len(trustedProxies) == 0 {
+		slog.Error("配置错误", "error", "PROXY_PROTOCOL 需要通过 TRUSTED_PROXIES 指定可信的负载均衡地址")
+		os.Exit(1)
+	}
+	gameServer.TrustedProxies = trustedProxies
 
-	// 启动UDP服务器
-	if err := gameServer.Start(); err != nil {
-		log.Fatalf("启动游戏服务器失败: %v", err)
+	// 作为热备时声明保护的主实例，需与主实例使用相同的 EXTERNAL_PORT
+	if standbyFor := os.Getenv("STANDBY_FOR"); standbyFor != "" {
+		gameServer.Registry.ExtraMeta["standby_for"] = standbyFor
+		slog.Info("以热备模式运行", "standby_for", standbyFor)
+	}
+
+	// 会话票据：配置密钥后客户端必须先以 HELLO 帧出示匹配服务签发的票据，热备实例同时接受签发给主实例的票据
+	if v := os.Getenv("TICKET_KEYS"); v != "" {
+		keys, err := gameproto.ParseTicketKeys(v)
+		if err != nil {
+			slog.Error("配置错误", "error", err)
+			os.Exit(1)
+		}
+		serverIDs := []string{serverID}
+		if standbyFor := os.Getenv("STANDBY_FOR"); standbyFor != "" {
+			serverIDs = append(serverIDs, standbyFor)
+		}
+		gameServer.tickets = newTicketAuth(keys, serverIDs...)
+		slog.Info("已启用会话票据校验", "server_ids", serverIDs)
+	}
+
+	// 按来源限流与防放大：未认证来源的响应默认在启用票据校验时受限
+	rateLimit, err := rateLimitOptionsFromEnv(gameServer.tickets != nil)
+	if err == nil {
+		err = rateLimit.validate(gameServer.ProxyProtocol)
+	}
+	if err != nil {
+		slog.Error("配置错误", "error", err)
+		os.Exit(1)
+	}
+	// 负载均衡自身发出的数据报（如健康检查）不带协议头，以其地址为来源，不参与按来源限流
+	if gameServer.ProxyProtocol {
+		rateLimit.Exempt = append(rateLimit.Exempt, trustedProxies...)
+	}
+	if rateLimit.Enabled() {
+		gameServer.limiter = newSourceLimiter(rateLimit)
+		slog.Info("已启用来源限制", "packets_per_second", rateLimit.PacketsPerSecond, "burst", gameServer.limiter.opts.Burst,
+			"blocklist", len(rateLimit.Blocklist), "limit_unauthenticated", rateLimit.LimitUnauthenticated)
 	}
 
-	// 自动注册到Consul（带重试，应对 Consul 未就绪或重启）
-	log.Printf("正在注册到Consul...")
-	registerWithRetry(gameServer, 30*time.Second, 2*time.Second)
+	// 会话空闲超时，应与控制平面下发的Envoy udp_proxy idle_timeout一致
+	idleTimeout := defaultSessionIdleTimeout
+	if v := os.Getenv("SESSION_IDLE_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			idleTimeout = d
+		} else {
+			slog.Warn("无效的 SESSION_IDLE_TIMEOUT，使用默认值", "value", v, "default", defaultSessionIdleTimeout)
+		}
+	}
+	maxSessions := defaultMaxSessions
+	if n, err := strconv.Atoi(os.Getenv("MAX_SESSIONS")); err == nil && n > 0 {
+		maxSessions = n
+	}
+	gameServer.Sessions = NewSessionManager(idleTimeout, maxSessions)
 
-	log.Printf("🎮 游戏服务器 %s 准备就绪，监听端口: %d (外部端口: %d)", serverID, port, externalPort)
+	// 读取与处理并发度
+	if n, err := strconv.Atoi(os.Getenv("UDP_READERS")); err == nil && n > 0 {
+		gameServer.Readers = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("UDP_WORKERS")); err == nil && n > 0 {
+		gameServer.Workers = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("UDP_WORKER_QUEUE")); err == nil && n > 0 {
+		gameServer.QueueSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("UDP_BATCH_SIZE")); err == nil && n > 0 {
+		gameServer.BatchSize = n
+	}
 
-	// 设置信号处理，优雅关闭
-	setupSignalHandling(gameServer)
+	// 数据报长度上限，需与控制平面的 MAX_DATAGRAM_SIZE 一致
+	if n, err := strconv.Atoi(os.Getenv("MAX_DATAGRAM_SIZE")); err == nil && n >= gameproto.MaxDatagramForMTU(gameproto.MinMTU) {
+		gameServer.MaxDatagramSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("PATH_MTU")); err == nil && n >= gameproto.MinMTU {
+		gameServer.PathMTU = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MAX_MESSAGE_SIZE")); err == nil && n > 0 {
+		gameServer.MaxMessageSize = n
+	}
 
-	// 等待中断信号
-	select {}
-}
+	shutdownTimeout := defaultShutdownTimeout
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			shutdownTimeout = d
+		}
+	}
 
-// setupSignalHandling 设置信号处理，实现优雅关闭
-func setupSignalHandling(gameServer *GameServer) {
-	// 创建信号通道
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// 收到 SIGINT/SIGTERM 时取消 ctx，触发优雅关闭
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// 启动信号处理协程
-	go func() {
-		sig := <-sigChan
-		log.Printf("收到信号: %v，正在优雅关闭...", sig)
+	// 启动HTTP健康检查服务器
+	healthServer := startHealthCheckServer(gameServer, port+1000)
 
-		// 停止游戏服务器
-		gameServer.Stop()
+	// 启动UDP服务器
+	if err := gameServer.Start(); err != nil {
+		slog.Error("启动游戏服务器失败", "error", err)
+		os.Exit(1)
+	}
 
-		log.Printf("游戏服务器已优雅关闭")
-		os.Exit(0)
+	// 后台保持Consul注册：启动时注册，此后定期确认，Consul重启丢失注册后自动重新注册
+	slog.Info("正在注册到Consul")
+	go func() {
+		if err := gameServer.Registry.Maintain(gameServer.ctx, serverID, gameServer.RegisterToConsul, gameServer.Draining); err != nil {
+			slog.Error("无法注册到Consul", "error", err)
+			os.Exit(1)
+		}
 	}()
+
+	slog.Info("游戏服务器准备就绪", "port", port)
+
+	// 等待中断信号
+	<-ctx.Done()
+	stop() // 关闭过程中再次收到信号时直接退出
+	slog.Info("收到退出信号，正在优雅关闭", "timeout", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	gameServer.Shutdown(shutdownCtx)
+
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer httpCancel()
+	if err := healthServer.Shutdown(httpCtx); err != nil {
+		slog.Warn("关闭健康检查服务器失败", "error", err)
+	}
+	if err := shutdownTracing(httpCtx); err != nil {
+		slog.Warn("关闭链路追踪失败", "error", err)
+	}
+
+	slog.Info("游戏服务器已优雅关闭")
 }
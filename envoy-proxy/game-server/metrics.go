@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gameproto"
+)
+
+// latencyBuckets 消息处理耗时直方图的桶上限（秒）
+var latencyBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+// histogram 无锁直方图，counts[i] 为落入第 i 个桶（不累计）的样本数，最后一个为 +Inf
+type histogram struct {
+	counts [12]atomic.Uint64
+	sumNs  atomic.Uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	i := 0
+	for i < len(latencyBuckets) && s > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sumNs.Add(uint64(d.Nanoseconds()))
+}
+
+// typeCounters 按消息类型计数。消息类型只有一个字节，用定长数组避免加锁
+type typeCounters [256]atomic.Uint64
+
+// gameMetrics 游戏服务器的Prometheus指标
+type gameMetrics struct {
+	rxPackets, rxBytes typeCounters
+	txPackets, txBytes typeCounters
+	handled, errors    typeCounters
+	latency            [256]histogram
+	invalidFrames      atomic.Uint64
+}
+
+func (m *gameMetrics) received(t gameproto.MessageType, n int) {
+	m.rxPackets[t].Add(1)
+	m.rxBytes[t].Add(uint64(n))
+}
+
+func (m *gameMetrics) sent(t gameproto.MessageType, n int) {
+	m.txPackets[t].Add(1)
+	m.txBytes[t].Add(uint64(n))
+}
+
+// middleware 记录每类消息的处理次数、错误数与耗时分布
+func (m *gameMetrics) middleware(next HandlerFunc) HandlerFunc {
+	return func(mc *MessageContext) (gameproto.MessageType, []byte, error) {
+		start := time.Now()
+		t, payload, err := next(mc)
+
+		typ := mc.Request.Type
+		m.latency[typ].observe(time.Since(start))
+		m.handled[typ].Add(1)
+		if err != nil {
+			m.errors[typ].Add(1)
+		}
+		return t, payload, err
+	}
+}
+
+// MetricsHandler 以Prometheus文本格式输出游戏服务器指标，与健康检查共用HTTP端口
+func (gs *GameServer) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	m := &gs.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeTypeCounter(w, "game_packets_received_total", "按消息类型统计收到的数据报数", &m.rxPackets)
+	writeTypeCounter(w, "game_bytes_received_total", "按消息类型统计收到的字节数", &m.rxBytes)
+	writeTypeCounter(w, "game_packets_sent_total", "按消息类型统计发出的数据报数", &m.txPackets)
+	writeTypeCounter(w, "game_bytes_sent_total", "按消息类型统计发出的字节数", &m.txBytes)
+	writeTypeCounter(w, "game_messages_handled_total", "按消息类型统计处理函数执行次数", &m.handled)
+	writeTypeCounter(w, "game_message_errors_total", "按消息类型统计处理失败次数", &m.errors)
+
+	fmt.Fprintln(w, "# HELP game_message_duration_seconds 消息处理耗时")
+	fmt.Fprintln(w, "# TYPE game_message_duration_seconds histogram")
+	for t := range m.latency {
+		h := &m.latency[t]
+		var total uint64
+		for i := range h.counts {
+			total += h.counts[i].Load()
+		}
+		if total == 0 {
+			continue
+		}
+
+		name := gameproto.MessageType(t).String()
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i].Load()
+			fmt.Fprintf(w, "game_message_duration_seconds_bucket{type=%q,le=%q} %d\n", name, strconv.FormatFloat(le, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "game_message_duration_seconds_bucket{type=%q,le=\"+Inf\"} %d\n", name, total)
+		fmt.Fprintf(w, "game_message_duration_seconds_sum{type=%q} %s\n", name,
+			strconv.FormatFloat(time.Duration(h.sumNs.Load()).Seconds(), 'f', -1, 64))
+		fmt.Fprintf(w, "game_message_duration_seconds_count{type=%q} %d\n", name, total)
+	}
+
+	sessions := gs.Sessions.Stats()
+	writeMetric(w, "game_sessions_active", "gauge", "当前活跃会话数", sessions["active"])
+	writeMetric(w, "game_sessions_created_total", "counter", "累计创建的会话数", sessions["created"])
+	writeMetric(w, "game_sessions_expired_total", "counter", "因空闲超时回收的会话数", sessions["expired"])
+	writeMetric(w, "game_sessions_rejected_total", "counter", "达到会话数上限而拒绝创建的会话数", sessions["rejected"])
+
+	writeMetric(w, "game_udp_read_errors_total", "counter", "UDP读取错误数", gs.liveness.readErrors.Load())
+	writeMetric(w, "game_udp_write_errors_total", "counter", "UDP发送错误数", gs.io.writeErrors.Load())
+	writeMetric(w, "game_udp_truncated_total", "counter", "超过 MAX_DATAGRAM_SIZE 被丢弃的数据报数", gs.io.truncated.Load())
+	writeMetric(w, "game_invalid_frames_total", "counter", "解码失败的帧数", m.invalidFrames.Load())
+	writeMetric(w, "game_fragmented_responses_total", "counter", "拆分为分片发送的响应数", gs.io.fragmented.Load())
+	writeMetric(w, "game_proxy_header_rejected_total", "counter", "PROXY协议头无效或来自不可信来源而被丢弃的数据报数", gs.io.badProxyHeaders.Load())
+
+	// 系统调用与数据报计数，平均批量大小 = packets / syscalls
+	writeMetric(w, "game_udp_read_syscalls_total", "counter", "UDP读取系统调用次数（recvmmsg 或 recvfrom）", gs.io.reads.Load())
+	writeMetric(w, "game_udp_read_packets_total", "counter", "UDP读取到的数据报数", gs.io.readPackets.Load())
+	writeMetric(w, "game_udp_write_syscalls_total", "counter", "UDP发送系统调用次数（sendmmsg 或 sendto）", gs.io.writes.Load())
+	writeMetric(w, "game_udp_write_packets_total", "counter", "UDP发出的数据报数", gs.io.writePackets.Load())
+	writeMetric(w, "game_udp_batch_size", "gauge", "每次批量收发的最大数据报数，1 表示逐个收发", gs.BatchSize)
+
+	if wp := gs.workers; wp != nil {
+		queued := 0
+		for _, q := range wp.queues {
+			queued += len(q)
+		}
+		writeMetric(w, "game_workers", "gauge", "处理协程数", len(wp.queues))
+		writeMetric(w, "game_workers_busy", "gauge", "正在执行处理函数的协程数", wp.busy.Load())
+		writeMetric(w, "game_worker_queue_capacity", "gauge", "所有处理队列的总容量", len(wp.queues)*cap(wp.queues[0]))
+		writeMetric(w, "game_worker_queue_depth", "gauge", "处理队列中等待的数据报数", queued)
+		writeMetric(w, "game_worker_enqueued_total", "counter", "进入处理队列的数据报数", wp.enqueued.Load())
+		writeMetric(w, "game_worker_processed_total", "counter", "处理完成的数据报数", wp.processed.Load())
+		writeMetric(w, "game_worker_queue_dropped_total", "counter", "处理队列已满而丢弃的数据报数", wp.dropped.Load())
+	}
+
+	if a := gs.tickets; a != nil {
+		writeMetric(w, "game_tickets_accepted_total", "counter", "校验通过的会话票据数", a.accepted.Load())
+		fmt.Fprintln(w, "# HELP game_tickets_rejected_total 按原因统计被拒绝的会话票据数")
+		fmt.Fprintln(w, "# TYPE game_tickets_rejected_total counter")
+		for i := range a.rejected {
+			fmt.Fprintf(w, "game_tickets_rejected_total{reason=%q} %d\n", ticketRejectReasonNames[i], a.rejected[i].Load())
+		}
+		writeMetric(w, "game_unauthenticated_frames_total", "counter", "不属于已认证会话而被丢弃的帧数", a.unauthenticated.Load())
+	}
+
+	if l := gs.limiter; l != nil {
+		fmt.Fprintln(w, "# HELP game_source_dropped_total 按原因统计因来源限制被丢弃的数据报或响应数")
+		fmt.Fprintln(w, "# TYPE game_source_dropped_total counter")
+		for i := range l.dropped {
+			fmt.Fprintf(w, "game_source_dropped_total{reason=%q} %d\n", dropReasonNames[i], l.dropped[i].Load())
+		}
+		stats := l.stats()
+		writeMetric(w, "game_ratelimit_sources", "gauge", "正在跟踪令牌桶的来源IP数", stats["sources"])
+		writeMetric(w, "game_ratelimit_blocked_sources", "gauge", "当前被自动封禁的来源IP数", stats["blocked"])
+		writeMetric(w, "game_ratelimit_auto_blocked_total", "counter", "累计自动封禁次数", stats["auto_blocked"])
+	}
+
+	writeMetric(w, "game_reliable_retransmits_total", "counter", "可靠帧重传次数", gs.reliable.retransmits.Load())
+	writeMetric(w, "game_reliable_lost_total", "counter", "重试耗尽被放弃的可靠帧数", gs.reliable.lost.Load())
+	writeMetric(w, "game_reliable_pending", "gauge", "等待确认的可靠帧数", gs.reliablePending())
+
+	up := 0
+	if ok, _ := gs.liveness.check(time.Now()); ok {
+		up = 1
+	}
+	writeMetric(w, "game_udp_up", "gauge", "UDP读取路径是否正常", up)
+	draining := 0
+	if gs.Draining() {
+		draining = 1
+	}
+	writeMetric(w, "game_draining", "gauge", "是否处于优雅关闭过程中", draining)
+}
+
+func writeTypeCounter(w http.ResponseWriter, name, help string, c *typeCounters) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	for t := range c {
+		if v := c[t].Load(); v > 0 {
+			fmt.Fprintf(w, "%s{type=%q} %d\n", name, gameproto.MessageType(t).String(), v)
+		}
+	}
+}
+
+func writeMetric(w http.ResponseWriter, name, typ, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+
+	"gameproto"
+)
+
+// handleDatagram 处理一个数据报并返回需要回复的数据报，返回空表示不响应。
+// replyAddr 为回复的目标地址，可靠帧的重传同样发往该地址
+func (gs *GameServer) handleDatagram(payload []byte, clientAddr, replyAddr *net.UDPAddr) [][]byte {
+	if !gameproto.IsFrame(payload) {
+		if !gs.textAllowed(payload) {
+			return nil
+		}
+		resp := gs.processMessage(string(payload), clientAddr)
+		if gs.limiter != nil {
+			resp = gs.limiter.limitTextResponse(resp, len(payload))
+		}
+		return [][]byte{[]byte(resp)}
+	}
+
+	req, err := gameproto.Decode(payload)
+	if err != nil {
+		gs.metrics.invalidFrames.Add(1)
+		slog.Warn("丢弃无效帧", "remote_addr", clientAddr.String(), "error", err)
+		return nil
+	}
+	gs.metrics.received(req.Type, len(payload))
+	// 未认证来源的响应总字节数不超过请求，防止反射放大
+	var budget *responseBudget
+	if gs.limiter != nil && !gs.authenticated(req, clientAddr) {
+		budget = gs.limiter.budget(len(payload))
+	}
+
+	var out [][]byte
+	for _, resp := range gs.processFrame(req, clientAddr, replyAddr, budget) {
+		b, err := gameproto.Encode(resp)
+		if err != nil {
+			slog.Error("编码响应帧失败", "remote_addr", clientAddr.String(), "error", err)
+			continue
+		}
+		gs.metrics.sent(resp.Type, len(b))
+		out = append(out, b)
+	}
+	return out
+}
+
+// processFrame 处理一个帧，响应沿用请求的序列号与会话ID；可靠帧交由可靠层去重与排序。
+// 启用票据校验时不属于已建立会话的帧被丢弃且不回复。响应按 budget 限制总字节数
+func (gs *GameServer) processFrame(req *gameproto.Frame, clientAddr, replyAddr *net.UDPAddr, budget *responseBudget) []*gameproto.Frame {
+	if req.Type == gameproto.TypeHello {
+		return budget.filter(gs.hello(req, clientAddr, replyAddr))
+	}
+	sess := gs.session(req, clientAddr, replyAddr)
+	if sess == nil {
+		return nil
+	}
+
+	if req.Reliable != nil {
+		return gs.processReliable(sess, req, clientAddr, budget)
+	}
+	full := gs.reassemble(sess, req)
+	if full == nil {
+		return nil
+	}
+	return budget.filter(gs.fragment(gs.respond(sess, full, clientAddr)))
+}
+
+// respond 执行处理函数并构造响应帧
+func (gs *GameServer) respond(sess *Session, req *gameproto.Frame, clientAddr *net.UDPAddr) *gameproto.Frame {
+	respType, payload := gs.dispatch(&MessageContext{Server: gs, ClientAddr: clientAddr, Request: req, Session: sess})
+	return &gameproto.Frame{
+		Type:      respType,
+		Seq:       req.Seq,
+		SessionID: req.SessionID,
+		Payload:   payload,
+	}
+}
+
+// textCommands 文本协议命令到消息类型的映射
+var textCommands = map[string]gameproto.MessageType{
+	"PING":   gameproto.TypePing,
+	"BATTLE": gameproto.TypeBattle,
+	"STATUS": gameproto.TypeStatus,
+	"ECHO":   gameproto.TypeEcho,
+}
+
+// parseTextMessage 文本协议按首个单词映射为消息类型，其余部分作为负载；未知命令返回类型0，由注册表回复错误
+func parseTextMessage(message string) *gameproto.Frame {
+	command, args, _ := strings.Cut(strings.TrimSpace(message), " ")
+	t, ok := textCommands[strings.ToUpper(command)]
+	if !ok {
+		return &gameproto.Frame{Payload: []byte(message)}
+	}
+	return &gameproto.Frame{Type: t, Payload: []byte(args)}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// proxyV2Signature PROXY protocol v2 固定12字节签名
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// errUntrustedProxyHeader 不可信的对端发来带PROXY协议头的数据报，可能是客户端在伪造源地址
+var errUntrustedProxyHeader = errors.New("不可信的来源携带PROXY协议头")
+
+const (
+	proxyV2HeaderLen = 16 // 签名(12) + 版本/命令(1) + 地址族/协议(1) + 长度(2)
+	// proxyV2MaxHeaderLen 负载均衡附加的最长协议头（IPv6地址块36字节，不含TLV），接收缓冲区需为其预留空间
+	proxyV2MaxHeaderLen = proxyV2HeaderLen + 36
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	proxyV2FamilyInet  = 0x1
+	proxyV2FamilyInet6 = 0x2
+)
+
+// parseProxyHeader 解析数据报开头的PROXY protocol v2头。
+// 返回客户端原始地址与去掉头部后的负载；数据报不以签名开头时 hasHeader 为 false，负载原样返回。
+// LOCAL 命令（如负载均衡自身的探测）或非IP地址族返回 nil 地址，由调用方回退到对端地址。
+func parseProxyHeader(datagram []byte) (src *net.UDPAddr, payload []byte, hasHeader bool, err error) {
+	if !bytes.HasPrefix(datagram, proxyV2Signature) {
+		return nil, datagram, false, nil
+	}
+	if len(datagram) < proxyV2HeaderLen {
+		return nil, nil, true, fmt.Errorf("PROXY协议头长度不足: %d", len(datagram))
+	}
+
+	verCmd := datagram[12]
+	if verCmd>>4 != 0x2 {
+		return nil, nil, true, fmt.Errorf("不支持的PROXY协议版本: %d", verCmd>>4)
+	}
+
+	addrLen := int(binary.BigEndian.Uint16(datagram[14:16]))
+	if len(datagram) < proxyV2HeaderLen+addrLen {
+		return nil, nil, true, fmt.Errorf("PROXY协议地址块被截断: 需要 %d 字节，实际 %d", addrLen, len(datagram)-proxyV2HeaderLen)
+	}
+	addrBlock := datagram[proxyV2HeaderLen : proxyV2HeaderLen+addrLen]
+	payload = datagram[proxyV2HeaderLen+addrLen:]
+
+	switch verCmd & 0x0F {
+	case proxyV2CmdLocal:
+		return nil, payload, true, nil
+	case proxyV2CmdProxy:
+	default:
+		return nil, nil, true, fmt.Errorf("未知的PROXY协议命令: %d", verCmd&0x0F)
+	}
+
+	// 高4位为地址族，低4位为传输协议；TLV 附加在地址之后，按长度整体跳过
+	switch datagram[13] >> 4 {
+	case proxyV2FamilyInet:
+		if len(addrBlock) < 12 {
+			return nil, nil, true, fmt.Errorf("PROXY协议IPv4地址块长度不足: %d", len(addrBlock))
+		}
+		src = &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), addrBlock[0:4]...)),
+			Port: int(binary.BigEndian.Uint16(addrBlock[8:10])),
+		}
+	case proxyV2FamilyInet6:
+		if len(addrBlock) < 36 {
+			return nil, nil, true, fmt.Errorf("PROXY协议IPv6地址块长度不足: %d", len(addrBlock))
+		}
+		src = &net.UDPAddr{
+			IP:   net.IP(append([]byte(nil), addrBlock[0:16]...)),
+			Port: int(binary.BigEndian.Uint16(addrBlock[32:34])),
+		}
+	}
+
+	return src, payload, true, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// proxyHeader 构造PROXY protocol v2头，addrBlock 为地址块（可附带TLV）
+func proxyHeader(verCmd, famProto byte, addrBlock []byte) []byte {
+	h := append([]byte(nil), proxyV2Signature...)
+	h = append(h, verCmd, famProto)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addrBlock)))
+	return append(h, addrBlock...)
+}
+
+func inetBlock(src, dst string, srcPort, dstPort uint16) []byte {
+	var b []byte
+	b = append(b, net.ParseIP(src).To4()...)
+	b = append(b, net.ParseIP(dst).To4()...)
+	b = binary.BigEndian.AppendUint16(b, srcPort)
+	return binary.BigEndian.AppendUint16(b, dstPort)
+}
+
+func inet6Block(src, dst string, srcPort, dstPort uint16) []byte {
+	var b []byte
+	b = append(b, net.ParseIP(src).To16()...)
+	b = append(b, net.ParseIP(dst).To16()...)
+	b = binary.BigEndian.AppendUint16(b, srcPort)
+	return binary.BigEndian.AppendUint16(b, dstPort)
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	payload := []byte("PING")
+	v4 := proxyHeader(0x21, 0x12, inetBlock("203.0.113.7", "10.0.0.1", 40000, 8080))
+	v6 := proxyHeader(0x21, 0x22, inet6Block("2001:db8::7", "2001:db8::1", 40001, 8080))
+	tlv := proxyHeader(0x21, 0x12, append(inetBlock("203.0.113.8", "10.0.0.1", 40002, 8080), 0x04, 0x00, 0x01, 0xFF))
+
+	tests := []struct {
+		name        string
+		datagram    []byte
+		wantSrc     string // 空表示 nil
+		wantPayload []byte
+		wantHeader  bool
+		wantErr     bool
+	}{
+		{name: "无协议头", datagram: payload, wantPayload: payload},
+		{name: "空数据报", datagram: []byte{}, wantPayload: []byte{}},
+		{name: "IPv4", datagram: append(v4, payload...), wantSrc: "203.0.113.7:40000", wantPayload: payload, wantHeader: true},
+		{name: "IPv6", datagram: append(v6, payload...), wantSrc: "[2001:db8::7]:40001", wantPayload: payload, wantHeader: true},
+		{name: "TLV随地址块跳过", datagram: append(tlv, payload...), wantSrc: "203.0.113.8:40002", wantPayload: payload, wantHeader: true},
+		{name: "只有协议头", datagram: v4, wantSrc: "203.0.113.7:40000", wantPayload: []byte{}, wantHeader: true},
+		{name: "LOCAL命令", datagram: append(proxyHeader(0x20, 0x00, nil), payload...), wantPayload: payload, wantHeader: true},
+		{name: "非IP地址族", datagram: append(proxyHeader(0x21, 0x31, make([]byte, 216)), payload...), wantPayload: payload, wantHeader: true},
+		{name: "只有签名", datagram: proxyV2Signature, wantHeader: true, wantErr: true},
+		{name: "固定头被截断", datagram: v4[:15], wantHeader: true, wantErr: true},
+		{name: "地址块被截断", datagram: v4[:len(v4)-1], wantHeader: true, wantErr: true},
+		{name: "声明长度超过数据报", datagram: append(proxyHeader(0x21, 0x12, nil)[:14], 0x00, 0x0C, 1, 2, 3, 4), wantHeader: true, wantErr: true},
+		{name: "版本1", datagram: append(proxyHeader(0x11, 0x12, inetBlock("1.2.3.4", "5.6.7.8", 1, 2)), payload...), wantHeader: true, wantErr: true},
+		{name: "未知命令", datagram: append(proxyHeader(0x2F, 0x12, inetBlock("1.2.3.4", "5.6.7.8", 1, 2)), payload...), wantHeader: true, wantErr: true},
+		{name: "IPv4地址块过短", datagram: append(proxyHeader(0x21, 0x12, make([]byte, 11)), payload...), wantHeader: true, wantErr: true},
+		{name: "IPv6地址块过短", datagram: append(proxyHeader(0x21, 0x22, make([]byte, 35)), payload...), wantHeader: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, got, hasHeader, err := parseProxyHeader(tt.datagram)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if hasHeader != tt.wantHeader {
+				t.Errorf("hasHeader = %v, want %v", hasHeader, tt.wantHeader)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantSrc == "" {
+				if src != nil {
+					t.Errorf("src = %v, want nil", src)
+				}
+			} else if src == nil || src.String() != tt.wantSrc {
+				t.Errorf("src = %v, want %s", src, tt.wantSrc)
+			}
+			if !bytes.Equal(got, tt.wantPayload) {
+				t.Errorf("payload = %q, want %q", got, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestResolveClientTrustedProxies(t *testing.T) {
+	proxy := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 50000}
+	client := &net.UDPAddr{IP: net.ParseIP("198.51.100.9"), Port: 50001}
+	spoofed := append(proxyHeader(0x21, 0x12, inetBlock("203.0.113.7", "10.0.0.1", 40000, 8080)), "PING"...)
+
+	gs := &GameServer{ProxyProtocol: true, TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}}
+
+	tests := []struct {
+		name     string
+		gs       *GameServer
+		datagram []byte
+		remote   *net.UDPAddr
+		wantAddr string
+		wantErr  error
+	}{
+		{name: "可信来源解析协议头", gs: gs, datagram: spoofed, remote: proxy, wantAddr: "203.0.113.7:40000"},
+		{name: "不可信来源携带协议头", gs: gs, datagram: spoofed, remote: client, wantErr: errUntrustedProxyHeader},
+		{name: "不可信来源普通数据报", gs: gs, datagram: []byte("PING"), remote: client, wantAddr: client.String()},
+		{name: "可信来源不带协议头", gs: gs, datagram: []byte("PING"), remote: proxy, wantAddr: proxy.String()},
+		{name: "未开启PROXY协议时协议头视为负载", gs: &GameServer{}, datagram: spoofed, remote: client, wantAddr: client.String()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, addr, err := tt.gs.resolveClient(tt.datagram, tt.remote)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if addr.String() != tt.wantAddr {
+				t.Errorf("addr = %s, want %s", addr, tt.wantAddr)
+			}
+			if len(payload) == 0 {
+				t.Errorf("payload 为空")
+			}
+		})
+	}
+}
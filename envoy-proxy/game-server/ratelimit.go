@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gameproto"
+)
+
+const (
+	// maxRateLimitSources 同时跟踪的来源地址上限，表满时新来源的数据报被丢弃，防止伪造源地址耗尽内存
+	maxRateLimitSources = 100000
+	// rateLimitIdleTimeout 令牌桶空闲超过该时长后回收
+	rateLimitIdleTimeout = 2 * time.Minute
+
+	defaultRateLimitBlockDuration = 5 * time.Minute
+	// defaultRateLimitExempt 默认豁免本机来源，本机自检不受限流影响
+	defaultRateLimitExempt = "127.0.0.0/8,::1/128"
+)
+
+// dropReason 数据报被丢弃的原因，作为指标标签
+type dropReason int
+
+const (
+	dropRateLimited dropReason = iota
+	dropBlocked
+	dropAmplification
+	numDropReasons
+)
+
+var dropReasonNames = [numDropReasons]string{"rate_limited", "blocked", "amplification"}
+
+// RateLimitOptions 按来源地址的限流与防放大配置
+type RateLimitOptions struct {
+	// PacketsPerSecond 每个来源IP每秒允许的数据报数，0 表示不限流；Burst 令牌桶容量
+	PacketsPerSecond float64
+	Burst            int
+	// BlockAfter 来源连续被限流丢弃达到该次数后封禁 BlockDuration，0 表示不自动封禁
+	BlockAfter    int
+	BlockDuration time.Duration
+	// Blocklist 始终丢弃的来源；Exempt 不限流的来源（如控制平面健康检查所在网段）
+	Blocklist []netip.Prefix
+	Exempt    []netip.Prefix
+	// LimitUnauthenticated 为 true 时，回复不属于已认证会话的来源的字节数不超过其请求的字节数，
+	// 避免伪造源地址的小请求换来大响应（反射放大）
+	LimitUnauthenticated bool
+	// Direct 声明数据报的源地址即客户端地址：客户端直连游戏服务器，
+	// 或经Envoy转发且控制平面以 SOURCE_ADDRESS_MODE=transparent 保留了客户端源地址
+	Direct bool
+}
+
+// Enabled 是否启用了任一限制
+func (o RateLimitOptions) Enabled() bool {
+	return o.PerSource() || o.LimitUnauthenticated
+}
+
+// PerSource 是否启用了按来源地址的限流或黑名单
+func (o RateLimitOptions) PerSource() bool {
+	return o.PacketsPerSecond > 0 || len(o.Blocklist) > 0
+}
+
+// validate 按来源的限制依赖真实客户端地址：经Envoy转发时所有数据报的源地址都是Envoy，
+// 按来源限流会把全部玩家当作一个来源一起限流，因此只在解析 PROXY 协议头或源地址即客户端地址时允许启用
+func (o RateLimitOptions) validate(proxyProtocol bool) error {
+	if o.PerSource() && !proxyProtocol && !o.Direct {
+		return errors.New("RATE_LIMIT_PPS 与 BLOCKLIST 按客户端地址生效：经Envoy转发时需在控制平面设置 SOURCE_ADDRESS_MODE=transparent " +
+			"并设置 RATE_LIMIT_DIRECT=true，客户端直连时设置 RATE_LIMIT_DIRECT=true，前方是附加 PROXY 协议头的负载均衡时设置 PROXY_PROTOCOL=true")
+	}
+	return nil
+}
+
+// sourceBucket 单个来源IP的令牌桶
+type sourceBucket struct {
+	tokens       float64
+	last         time.Time
+	drops        int // 连续被限流丢弃的次数，放行一次后清零
+	blockedUntil time.Time
+}
+
+// sourceLimiter 按来源IP限流。经Envoy转发且未透传源地址时所有数据报都来自Envoy，
+// 因此按来源的限制只在真实客户端地址可用时启用，见 RateLimitOptions.validate
+type sourceLimiter struct {
+	opts RateLimitOptions
+
+	mu      sync.Mutex
+	sources map[netip.Addr]*sourceBucket
+
+	dropped     [numDropReasons]atomic.Uint64
+	autoBlocked atomic.Uint64
+}
+
+func newSourceLimiter(opts RateLimitOptions) *sourceLimiter {
+	if opts.Burst <= 0 {
+		opts.Burst = max(1, int(2*opts.PacketsPerSecond))
+	}
+	if opts.BlockDuration <= 0 {
+		opts.BlockDuration = defaultRateLimitBlockDuration
+	}
+	return &sourceLimiter{
+		opts:    opts,
+		sources: make(map[netip.Addr]*sourceBucket),
+	}
+}
+
+// allow 判断是否处理来自 addr 的数据报，丢弃时返回原因
+func (l *sourceLimiter) allow(addr *net.UDPAddr, now time.Time) (dropReason, bool) {
+	ip := addr.AddrPort().Addr().Unmap()
+	if containsAddr(l.opts.Blocklist, ip) {
+		return l.drop(dropBlocked)
+	}
+	if l.opts.PacketsPerSecond <= 0 || containsAddr(l.opts.Exempt, ip) {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.sources[ip]
+	if !ok {
+		if len(l.sources) >= maxRateLimitSources {
+			return l.drop(dropRateLimited)
+		}
+		b = &sourceBucket{tokens: float64(l.opts.Burst), last: now}
+		l.sources[ip] = b
+	}
+	if now.Before(b.blockedUntil) {
+		return l.drop(dropBlocked)
+	}
+
+	b.tokens = min(float64(l.opts.Burst), b.tokens+now.Sub(b.last).Seconds()*l.opts.PacketsPerSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.drops = 0
+		return 0, true
+	}
+
+	b.drops++
+	if l.opts.BlockAfter > 0 && b.drops >= l.opts.BlockAfter {
+		b.blockedUntil = now.Add(l.opts.BlockDuration)
+		b.drops = 0
+		l.autoBlocked.Add(1)
+		slog.Warn("来源持续超过限流阈值，暂时封禁", "remote_addr", ip.String(), "duration", l.opts.BlockDuration)
+	}
+	return l.drop(dropRateLimited)
+}
+
+func (l *sourceLimiter) drop(reason dropReason) (dropReason, bool) {
+	l.dropped[reason].Add(1)
+	return reason, false
+}
+
+// Run 定期回收空闲且未被封禁的令牌桶，直到 ctx 结束
+func (l *sourceLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateLimitIdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for ip, b := range l.sources {
+				if now.Sub(b.last) > rateLimitIdleTimeout && now.After(b.blockedUntil) {
+					delete(l.sources, ip)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// blocked 当前处于自动封禁中的来源数
+func (l *sourceLimiter) blocked(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, b := range l.sources {
+		if now.Before(b.blockedUntil) {
+			n++
+		}
+	}
+	return n
+}
+
+// stats 限流统计
+func (l *sourceLimiter) stats() map[string]interface{} {
+	dropped := make(map[string]uint64, numDropReasons)
+	for i := range l.dropped {
+		dropped[dropReasonNames[i]] = l.dropped[i].Load()
+	}
+	l.mu.Lock()
+	sources := len(l.sources)
+	l.mu.Unlock()
+	return map[string]interface{}{
+		"packets_per_second": l.opts.PacketsPerSecond,
+		"burst":              l.opts.Burst,
+		"sources":            sources,
+		"blocked":            l.blocked(time.Now()),
+		"auto_blocked":       l.autoBlocked.Load(),
+		"dropped":            dropped,
+	}
+}
+
+// limitTextResponse 对未认证来源执行响应不大于请求的规则，文本响应截断到请求长度（健康检查只比对 PONG 前缀）
+func (l *sourceLimiter) limitTextResponse(resp string, requestLen int) string {
+	if l.opts.LimitUnauthenticated && len(resp) > requestLen {
+		return resp[:requestLen]
+	}
+	return resp
+}
+
+// responseBudget 回复未认证来源时剩余可发送的字节数，初始为请求的字节数。帧无法截断，超出预算的帧整帧丢弃；
+// 可靠帧必须在交给可靠通道之前扣除，否则重传协程会把被丢弃的响应重新发出。nil 表示不受限
+type responseBudget struct {
+	remaining int
+	limiter   *sourceLimiter
+}
+
+// budget 返回请求的响应预算，未启用防放大时返回 nil
+func (l *sourceLimiter) budget(requestLen int) *responseBudget {
+	if !l.opts.LimitUnauthenticated {
+		return nil
+	}
+	return &responseBudget{remaining: requestLen, limiter: l}
+}
+
+// take 从预算中扣除 size 字节，预算不足时计入丢弃数并返回 false
+func (b *responseBudget) take(size int) bool {
+	if b == nil {
+		return true
+	}
+	if size > b.remaining {
+		b.limiter.dropped[dropAmplification].Add(1)
+		return false
+	}
+	b.remaining -= size
+	return true
+}
+
+// filter 按顺序保留预算内的帧
+func (b *responseBudget) filter(frames []*gameproto.Frame) []*gameproto.Frame {
+	if b == nil {
+		return frames
+	}
+	kept := frames[:0]
+	for _, f := range frames {
+		if b.take(f.Size()) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// parsePrefixes 解析逗号分隔的CIDR或单个IP
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("无效的地址 %q: %v", item, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的网段 %q: %v", item, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitOptionsFromEnv 从环境变量读取限流配置，ticketsEnabled 决定 AMPLIFICATION_LIMIT 的默认值
+func rateLimitOptionsFromEnv(ticketsEnabled bool) (RateLimitOptions, error) {
+	opts := RateLimitOptions{LimitUnauthenticated: ticketsEnabled}
+	if v, err := strconv.ParseFloat(os.Getenv("RATE_LIMIT_PPS"), 64); err == nil && v > 0 {
+		opts.PacketsPerSecond = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST")); err == nil && n > 0 {
+		opts.Burst = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("RATE_LIMIT_BLOCK_AFTER")); err == nil && n > 0 {
+		opts.BlockAfter = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("RATE_LIMIT_BLOCK_DURATION")); err == nil && d > 0 {
+		opts.BlockDuration = d
+	}
+	if v, err := strconv.ParseBool(os.Getenv("AMPLIFICATION_LIMIT")); err == nil {
+		opts.LimitUnauthenticated = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("RATE_LIMIT_DIRECT")); err == nil {
+		opts.Direct = v
+	}
+
+	var err error
+	if opts.Blocklist, err = parsePrefixes(os.Getenv("BLOCKLIST")); err != nil {
+		return opts, fmt.Errorf("BLOCKLIST: %v", err)
+	}
+	exempt, ok := os.LookupEnv("RATE_LIMIT_EXEMPT")
+	if !ok {
+		exempt = defaultRateLimitExempt
+	}
+	if opts.Exempt, err = parsePrefixes(exempt); err != nil {
+		return opts, fmt.Errorf("RATE_LIMIT_EXEMPT: %v", err)
+	}
+	return opts, nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"gameproto"
+)
+
+func udpAddr(s string) *net.UDPAddr {
+	return net.UDPAddrFromAddrPort(netip.MustParseAddrPort(s))
+}
+
+func TestSourceLimiterTokenBucket(t *testing.T) {
+	type step struct {
+		after  time.Duration // 距上一步的间隔
+		addr   string
+		want   bool
+		reason dropReason
+	}
+	tests := []struct {
+		name  string
+		opts  RateLimitOptions
+		steps []step
+	}{
+		{
+			name: "桶满时允许突发",
+			opts: RateLimitOptions{PacketsPerSecond: 10, Burst: 3},
+			steps: []step{
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", false, dropRateLimited},
+			},
+		},
+		{
+			name: "按速率补充令牌",
+			opts: RateLimitOptions{PacketsPerSecond: 10, Burst: 1},
+			steps: []step{
+				{0, "1.1.1.1:1", true, 0},
+				{50 * time.Millisecond, "1.1.1.1:1", false, dropRateLimited},
+				{50 * time.Millisecond, "1.1.1.1:1", true, 0},
+			},
+		},
+		{
+			name: "令牌不超过桶容量",
+			opts: RateLimitOptions{PacketsPerSecond: 10, Burst: 2},
+			steps: []step{
+				{time.Hour, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", false, dropRateLimited},
+			},
+		},
+		{
+			name: "默认桶容量为两秒的量",
+			opts: RateLimitOptions{PacketsPerSecond: 1},
+			steps: []step{
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", false, dropRateLimited},
+			},
+		},
+		{
+			name: "按IP而非端口计数",
+			opts: RateLimitOptions{PacketsPerSecond: 1, Burst: 1},
+			steps: []step{
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:2", false, dropRateLimited},
+				{0, "2.2.2.2:1", true, 0},
+			},
+		},
+		{
+			name: "IPv4映射地址与IPv4共用令牌桶",
+			opts: RateLimitOptions{PacketsPerSecond: 1, Burst: 1},
+			steps: []step{
+				{0, "1.1.1.1:1", true, 0},
+				{0, "[::ffff:1.1.1.1]:1", false, dropRateLimited},
+			},
+		},
+		{
+			name: "豁免来源不限流",
+			opts: RateLimitOptions{PacketsPerSecond: 1, Burst: 1, Exempt: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+			steps: []step{
+				{0, "10.1.2.3:1", true, 0},
+				{0, "10.1.2.3:1", true, 0},
+			},
+		},
+		{
+			name: "黑名单优先于豁免",
+			opts: RateLimitOptions{
+				Blocklist: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+				Exempt:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			},
+			steps: []step{
+				{0, "10.1.2.3:1", false, dropBlocked},
+				{0, "10.2.0.1:1", true, 0},
+			},
+		},
+		{
+			name: "只配置黑名单时不限流",
+			opts: RateLimitOptions{Blocklist: []netip.Prefix{netip.MustParsePrefix("9.9.9.9/32")}},
+			steps: []step{
+				{0, "1.1.1.1:1", true, 0},
+				{0, "1.1.1.1:1", true, 0},
+				{0, "9.9.9.9:1", false, dropBlocked},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newSourceLimiter(tt.opts)
+			now := time.Unix(1_800_000_000, 0)
+			var wantDropped [numDropReasons]uint64
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				reason, ok := l.allow(udpAddr(s.addr), now)
+				if ok != s.want || !ok && reason != s.reason {
+					t.Fatalf("step %d (%s): allow = (%s, %v), want (%s, %v)", i, s.addr, dropReasonNames[reason], ok, dropReasonNames[s.reason], s.want)
+				}
+				if !ok {
+					wantDropped[s.reason]++
+				}
+			}
+			for r := range wantDropped {
+				if got := l.dropped[r].Load(); got != wantDropped[r] {
+					t.Errorf("dropped[%s] = %d, want %d", dropReasonNames[r], got, wantDropped[r])
+				}
+			}
+		})
+	}
+}
+
+func TestSourceLimiterAutoBlock(t *testing.T) {
+	l := newSourceLimiter(RateLimitOptions{PacketsPerSecond: 1, Burst: 1, BlockAfter: 3, BlockDuration: time.Minute})
+	addr := udpAddr("1.1.1.1:1")
+	now := time.Unix(1_800_000_000, 0)
+
+	if _, ok := l.allow(addr, now); !ok {
+		t.Fatal("首个数据报被丢弃")
+	}
+	// 连续两次超限后放行一次，计数清零
+	for i := 0; i < 2; i++ {
+		if reason, ok := l.allow(addr, now); ok || reason != dropRateLimited {
+			t.Fatalf("第 %d 次超限: (%s, %v)", i+1, dropReasonNames[reason], ok)
+		}
+	}
+	now = now.Add(time.Second)
+	if _, ok := l.allow(addr, now); !ok {
+		t.Fatal("补充令牌后仍被丢弃")
+	}
+	if l.autoBlocked.Load() != 0 {
+		t.Fatal("未连续超限却被封禁")
+	}
+
+	for i := 0; i < 3; i++ {
+		l.allow(addr, now)
+	}
+	if l.autoBlocked.Load() != 1 || l.blocked(now) != 1 {
+		t.Fatalf("连续超限 3 次后 autoBlocked = %d, blocked = %d", l.autoBlocked.Load(), l.blocked(now))
+	}
+
+	// 封禁期间即使有令牌也丢弃，其他来源不受影响
+	now = now.Add(30 * time.Second)
+	if reason, ok := l.allow(addr, now); ok || reason != dropBlocked {
+		t.Fatalf("封禁期间: (%s, %v), want blocked", dropReasonNames[reason], ok)
+	}
+	if _, ok := l.allow(udpAddr("2.2.2.2:1"), now); !ok {
+		t.Fatal("其他来源被封禁")
+	}
+
+	now = now.Add(31 * time.Second)
+	if _, ok := l.allow(addr, now); !ok {
+		t.Fatal("封禁到期后仍被丢弃")
+	}
+	if l.blocked(now) != 0 {
+		t.Errorf("封禁到期后 blocked = %d", l.blocked(now))
+	}
+}
+
+func TestSourceLimiterMaxSources(t *testing.T) {
+	l := newSourceLimiter(RateLimitOptions{PacketsPerSecond: 1})
+	now := time.Unix(1_800_000_000, 0)
+	for i := 0; i < maxRateLimitSources; i++ {
+		ip := netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+		if _, ok := l.allow(net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, 1)), now); !ok {
+			t.Fatalf("第 %d 个来源被丢弃", i)
+		}
+	}
+	// 表满时丢弃新来源，已跟踪的来源照常处理
+	if reason, ok := l.allow(udpAddr("192.0.2.1:1"), now); ok || reason != dropRateLimited {
+		t.Fatalf("表满后新来源: (%s, %v)", dropReasonNames[reason], ok)
+	}
+	if _, ok := l.allow(udpAddr("10.0.0.0:1"), now); !ok {
+		t.Fatal("表满后已跟踪的来源被丢弃")
+	}
+}
+
+func TestRateLimitOptionsValidate(t *testing.T) {
+	blocklist := []netip.Prefix{netip.MustParsePrefix("9.9.9.9/32")}
+	tests := []struct {
+		name          string
+		opts          RateLimitOptions
+		proxyProtocol bool
+		wantErr       bool
+	}{
+		{"未启用", RateLimitOptions{}, false, false},
+		{"只防放大", RateLimitOptions{LimitUnauthenticated: true}, false, false},
+		{"经Envoy按来源限流", RateLimitOptions{PacketsPerSecond: 100}, false, true},
+		{"经Envoy使用黑名单", RateLimitOptions{Blocklist: blocklist}, false, true},
+		{"PROXY协议", RateLimitOptions{PacketsPerSecond: 100, Blocklist: blocklist}, true, false},
+		{"客户端直连", RateLimitOptions{PacketsPerSecond: 100, Direct: true}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.validate(tt.proxyProtocol); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimitOptionsFromEnv(t *testing.T) {
+	for _, k := range []string{"RATE_LIMIT_PPS", "RATE_LIMIT_BURST", "RATE_LIMIT_BLOCK_AFTER", "RATE_LIMIT_BLOCK_DURATION",
+		"AMPLIFICATION_LIMIT", "RATE_LIMIT_DIRECT", "BLOCKLIST"} {
+		t.Setenv(k, "")
+	}
+	t.Setenv("RATE_LIMIT_PPS", "50")
+	t.Setenv("RATE_LIMIT_BURST", "-1") // 无效值被忽略
+	t.Setenv("RATE_LIMIT_DIRECT", "true")
+	t.Setenv("BLOCKLIST", "192.0.2.1, 198.51.100.0/24")
+	t.Setenv("RATE_LIMIT_EXEMPT", "")
+
+	opts, err := rateLimitOptionsFromEnv(true)
+	if err != nil {
+		t.Fatalf("rateLimitOptionsFromEnv: %v", err)
+	}
+	if opts.PacketsPerSecond != 50 || opts.Burst != 0 || !opts.Direct || !opts.LimitUnauthenticated || len(opts.Exempt) != 0 {
+		t.Errorf("opts = %+v", opts)
+	}
+	if len(opts.Blocklist) != 2 || opts.Blocklist[0] != netip.MustParsePrefix("192.0.2.1/32") {
+		t.Errorf("Blocklist = %v", opts.Blocklist)
+	}
+
+	t.Setenv("BLOCKLIST", "not-an-ip")
+	if _, err := rateLimitOptionsFromEnv(false); err == nil {
+		t.Error("无效的 BLOCKLIST 未报错")
+	}
+}
+
+// TestAmplificationLimitReliable 超出预算的可靠响应不能进入可靠通道，否则重传协程会把它发给伪造的源地址
+func TestAmplificationLimitReliable(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     string
+		wantTypes   []gameproto.MessageType
+		wantPending int
+		wantDropped uint64
+	}{
+		{"小请求只回复确认", "x", []gameproto.MessageType{gameproto.TypeAck}, 0, 1},
+		{"请求足够大时回复响应", strings.Repeat("x", 200), []gameproto.MessageType{gameproto.TypeBattleResponse}, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, err := NewGameServer("battle-1", 0, 7001, "127.0.0.1:8500")
+			if err != nil {
+				t.Fatalf("NewGameServer: %v", err)
+			}
+			gs.limiter = newSourceLimiter(RateLimitOptions{LimitUnauthenticated: true})
+			client := udpAddr("203.0.113.7:40000")
+
+			req, _ := gameproto.Encode(&gameproto.Frame{Type: gameproto.TypeBattle, Seq: 1, SessionID: 9,
+				Reliable: &gameproto.ReliableHeader{Channel: gameproto.ChannelBattle, Seq: 1}, Payload: []byte(tt.payload)})
+			out := gs.handleDatagram(req, client, client)
+
+			total := 0
+			var types []gameproto.MessageType
+			for _, b := range out {
+				f, err := gameproto.Decode(b)
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				types = append(types, f.Type)
+				total += len(b)
+			}
+			if len(types) != len(tt.wantTypes) || len(types) > 0 && types[0] != tt.wantTypes[0] {
+				t.Fatalf("responses = %v, want %v", types, tt.wantTypes)
+			}
+			if total > len(req) {
+				t.Errorf("响应 %d 字节超过请求 %d 字节", total, len(req))
+			}
+			if got := gs.reliablePending(); got != tt.wantPending {
+				t.Errorf("reliablePending = %d, want %d", got, tt.wantPending)
+			}
+			if got := gs.limiter.dropped[dropAmplification].Load(); got != tt.wantDropped {
+				t.Errorf("dropped amplification = %d, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestAmplificationLimitText(t *testing.T) {
+	gs, err := NewGameServer("battle-1", 0, 7001, "127.0.0.1:8500")
+	if err != nil {
+		t.Fatalf("NewGameServer: %v", err)
+	}
+	gs.limiter = newSourceLimiter(RateLimitOptions{LimitUnauthenticated: true})
+	out := gs.handleDatagram([]byte("PING"), udpAddr("203.0.113.7:40000"), udpAddr("203.0.113.7:40000"))
+	if len(out) != 1 || string(out[0]) != "PONG" {
+		t.Errorf("out = %q, want [PONG]", out)
+	}
+}
+
+// TestRateLimitBeforeEnqueue 发送方即客户端时在入队前限流：同一处理队列上，洪泛来源只能占用令牌桶容量内的位置，
+// 其他来源的数据报仍能入队；经可信代理转发的数据报留给处理协程按协议头中的客户端地址限流
+func TestRateLimitBeforeEnqueue(t *testing.T) {
+	tests := []struct {
+		name        string
+		proxy       bool
+		wantQueued  int
+		wantVictim  bool
+		wantLimited uint64 // 入队前被限流的数据报数
+	}{
+		{"直连", false, 5, true, 996},
+		{"可信代理转发", true, 8, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, err := NewGameServer("battle-1", 0, 7001, "127.0.0.1:8500")
+			if err != nil {
+				t.Fatalf("NewGameServer: %v", err)
+			}
+			gs.limiter = newSourceLimiter(RateLimitOptions{PacketsPerSecond: 1, Burst: 4})
+			if tt.proxy {
+				gs.ProxyProtocol = true
+				gs.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}
+			}
+			// 单个处理协程且不启动消费，队列中的数据报数即占用的位置
+			gs.workers = newWorkerPool(1, 8, 64, 1, &gs.io, gs.processPacket)
+
+			submit := func(addr string) {
+				gs.enqueue(&packet{addr: udpAddr(addr), buf: gs.workers.getBuffer(), n: 4})
+			}
+			for i := 0; i < 1000; i++ {
+				submit("203.0.113.7:40000")
+			}
+			submit("198.51.100.9:40001")
+
+			q := gs.workers.queues[0]
+			queued := len(q)
+			victim := false
+			for len(q) > 0 {
+				p := <-q
+				victim = victim || p.addr.String() == "198.51.100.9:40001"
+				if p.limited == tt.proxy {
+					t.Errorf("%s: limited = %v", p.addr, p.limited)
+				}
+			}
+			if queued != tt.wantQueued || victim != tt.wantVictim {
+				t.Errorf("queued = %d, victim = %v, want %d, %v", queued, victim, tt.wantQueued, tt.wantVictim)
+			}
+			if got := gs.limiter.dropped[dropRateLimited].Load(); got != tt.wantLimited {
+				t.Errorf("rate limited = %d, want %d", got, tt.wantLimited)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"common"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+const (
+	registrationCheckInterval = 10 * time.Second // 确认Consul中仍有本服务注册的间隔
+	registrationRetryInterval = 2 * time.Second  // 注册失败或Consul不可达时的重试间隔
+)
+
+// registrationStatus 后台注册循环维护的注册状态，在 /ready 中输出
+type registrationStatus struct {
+	mu             sync.Mutex
+	registered     bool
+	registrations  uint64 // 成功注册的次数，大于1说明发生过重新注册
+	failures       uint64 // 连续失败次数
+	lastCheck      time.Time
+	lastRegistered time.Time
+	lastError      string
+}
+
+func (s *registrationStatus) isRegistered() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registered
+}
+
+func (s *registrationStatus) registrationCount() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.registrations
+}
+
+// markRegistered 记录一次成功的注册或确认，registeredNow 为 true 表示本次执行了注册
+func (s *registrationStatus) markRegistered(registeredNow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.registered = true
+	s.failures = 0
+	s.lastError = ""
+	s.lastCheck = now
+	if registeredNow {
+		s.registrations++
+		s.lastRegistered = now
+	}
+}
+
+// markUnregistered 记录注册丢失或失败，返回连续失败次数
+func (s *registrationStatus) markUnregistered(err error) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registered = false
+	s.failures++
+	s.lastCheck = time.Now()
+	if err != nil {
+		s.lastError = err.Error()
+	}
+	return s.failures
+}
+
+func (s *registrationStatus) details() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := map[string]interface{}{
+		"registered":    s.registered,
+		"registrations": s.registrations,
+		"failures":      s.failures,
+	}
+	if !s.lastCheck.IsZero() {
+		d["last_check"] = s.lastCheck.Format(time.RFC3339)
+	}
+	if !s.lastRegistered.IsZero() {
+		d["last_registered"] = s.lastRegistered.Format(time.RFC3339)
+	}
+	if s.lastError != "" {
+		d["last_error"] = s.lastError
+	}
+	return d
+}
+
+// Status 注册状态
+func (cr *ConsulRegistry) Status() map[string]interface{} {
+	return cr.status.details()
+}
+
+// Maintain 在后台保持服务注册直到 ctx 结束：未注册时按 registrationRetryInterval 重试，
+// 注册后定期向Consul agent确认服务仍存在，丢失（如Consul重启后丢失agent注册）时重新注册。
+// paused 返回 true 时（如优雅关闭期间）不再重新注册，避免覆盖维护模式或撤销注销。
+// 首次注册因令牌或TLS配置错误被拒绝时返回该错误，重试无法恢复；其余情况在 ctx 结束时返回 nil
+func (cr *ConsulRegistry) Maintain(ctx context.Context, serverID string, register func(context.Context) error, paused func() bool) error {
+	for {
+		wait, err := cr.maintainOnce(ctx, serverID, register, paused)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// maintainOnce 执行一次确认或注册，返回距下一次执行的等待时间
+func (cr *ConsulRegistry) maintainOnce(ctx context.Context, serverID string, register func(context.Context) error, paused func() bool) (time.Duration, error) {
+	if cr.status.isRegistered() {
+		found, err := cr.serviceRegistered(serverID)
+		switch {
+		case err != nil:
+			// Consul不可达时无法确认注册是否还在，恢复后重新注册（注册是幂等的）
+			if n := cr.status.markUnregistered(err); n&(n-1) == 0 {
+				slog.Warn("确认Consul注册失败", "failures", n, "error", err)
+			}
+			return registrationRetryInterval, nil
+		case found:
+			cr.status.markRegistered(false)
+			return registrationCheckInterval, nil
+		}
+		cr.status.markUnregistered(errors.New("Consul中的服务注册已丢失"))
+		slog.Warn("Consul中的服务注册已丢失，重新注册")
+	}
+
+	if paused() {
+		return registrationCheckInterval, nil
+	}
+	if err := register(ctx); err != nil {
+		if common.IsConsulConfigError(err) && cr.status.registrationCount() == 0 {
+			return 0, err
+		}
+		if n := cr.status.markUnregistered(err); n&(n-1) == 0 {
+			slog.Warn("注册到Consul失败，稍后重试", "failures", n, "retry_in", registrationRetryInterval, "error", err)
+		}
+		return registrationRetryInterval, nil
+	}
+	cr.status.markRegistered(true)
+	return registrationCheckInterval, nil
+}
+
+// serviceRegistered 查询本地Consul agent中是否存在该服务
+func (cr *ConsulRegistry) serviceRegistered(serverID string) (bool, error) {
+	_, _, err := cr.Client.Agent().Service(serverID, nil)
+	if err == nil {
+		return true, nil
+	}
+	var statusErr consulapi.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return false, nil
+	}
+	return false, common.ConsulAccessError(err)
+}
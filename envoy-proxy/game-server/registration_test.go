@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"common"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// fakeAgentService 模拟Consul agent的服务查询接口，按 status 回复
+func fakeAgentService(t *testing.T, serverID string, status int) *ConsulRegistry {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/agent/service/"+serverID {
+			t.Errorf("请求 %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		switch status {
+		case http.StatusOK:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ID":"` + serverID + `","Service":"game-server"}`))
+		case http.StatusForbidden:
+			http.Error(w, "Permission denied", status)
+		default:
+			http.Error(w, http.StatusText(status), status)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cr, err := NewConsulRegistry(srv.URL)
+	if err != nil {
+		t.Fatalf("NewConsulRegistry: %v", err)
+	}
+	return cr
+}
+
+func TestMaintainOnce(t *testing.T) {
+	errDenied := common.ConsulAccessError(consulapi.StatusError{Code: http.StatusForbidden, Body: "Permission denied"})
+	errUnavailable := errors.New("dial tcp 127.0.0.1:8500: connect: connection refused")
+	tests := []struct {
+		name          string
+		registrations uint64 // 之前成功注册的次数，大于0表示已注册
+		agentStatus   int    // agent查询服务的响应状态
+		paused        bool
+		registerErr   error
+		wantWait      time.Duration
+		wantErr       bool
+		wantRegister  bool // 是否调用了 register
+		wantState     bool // 之后的注册状态
+		wantCount     uint64
+	}{
+		{
+			name:         "首次注册成功",
+			wantWait:     registrationCheckInterval,
+			wantRegister: true, wantState: true, wantCount: 1,
+		},
+		{
+			name:         "首次注册因ACL被拒绝时返回错误",
+			registerErr:  errDenied,
+			wantErr:      true,
+			wantRegister: true,
+		},
+		{
+			name:         "首次注册时Consul不可达则重试",
+			registerErr:  errUnavailable,
+			wantWait:     registrationRetryInterval,
+			wantRegister: true,
+		},
+		{
+			name:          "注册仍在时只确认不重新注册",
+			registrations: 1, agentStatus: http.StatusOK,
+			wantWait:  registrationCheckInterval,
+			wantState: true, wantCount: 1,
+		},
+		{
+			name:          "agent丢失服务后重新注册",
+			registrations: 1, agentStatus: http.StatusNotFound,
+			wantWait:     registrationCheckInterval,
+			wantRegister: true, wantState: true, wantCount: 2,
+		},
+		{
+			name:          "排空期间丢失服务不重新注册",
+			registrations: 1, agentStatus: http.StatusNotFound, paused: true,
+			wantWait:  registrationCheckInterval,
+			wantCount: 1,
+		},
+		{
+			name:          "重新注册被拒绝时不退出，稍后重试",
+			registrations: 1, agentStatus: http.StatusNotFound, registerErr: errDenied,
+			wantWait:     registrationRetryInterval,
+			wantRegister: true, wantCount: 1,
+		},
+		{
+			name:          "确认注册时Consul出错则下次重新注册",
+			registrations: 1, agentStatus: http.StatusInternalServerError,
+			wantWait:  registrationRetryInterval,
+			wantCount: 1,
+		},
+		{
+			name:          "确认注册时令牌失效不退出",
+			registrations: 1, agentStatus: http.StatusForbidden,
+			wantWait:  registrationRetryInterval,
+			wantCount: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := fakeAgentService(t, "battle-1", tt.agentStatus)
+			for i := uint64(0); i < tt.registrations; i++ {
+				cr.status.markRegistered(true)
+			}
+			registered := false
+			register := func(context.Context) error {
+				registered = true
+				return tt.registerErr
+			}
+
+			wait, err := cr.maintainOnce(context.Background(), "battle-1", register, func() bool { return tt.paused })
+			if (err != nil) != tt.wantErr || wait != tt.wantWait {
+				t.Fatalf("maintainOnce = %v, %v, want %v, wantErr %v", wait, err, tt.wantWait, tt.wantErr)
+			}
+			if err != nil && !common.IsConsulConfigError(err) {
+				t.Errorf("返回的错误不是配置错误: %v", err)
+			}
+			if registered != tt.wantRegister {
+				t.Errorf("register 调用 = %v, want %v", registered, tt.wantRegister)
+			}
+			if got := cr.status.isRegistered(); got != tt.wantState {
+				t.Errorf("registered = %v, want %v", got, tt.wantState)
+			}
+			if got := cr.status.registrationCount(); got != tt.wantCount {
+				t.Errorf("registrations = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+// TestMaintainRecoversAfterConsulOutage 确认失败后的下一轮直接重新注册，恢复后重新进入定期确认
+func TestMaintainRecoversAfterConsulOutage(t *testing.T) {
+	cr := fakeAgentService(t, "battle-1", http.StatusInternalServerError)
+	cr.status.markRegistered(true)
+	calls := 0
+	register := func(context.Context) error { calls++; return nil }
+	never := func() bool { return false }
+
+	steps := []struct {
+		wantWait  time.Duration
+		wantCalls int
+	}{
+		{registrationRetryInterval, 0}, // 确认失败
+		{registrationCheckInterval, 1}, // 未注册状态下直接重新注册
+	}
+	for i, step := range steps {
+		wait, err := cr.maintainOnce(context.Background(), "battle-1", register, never)
+		if err != nil || wait != step.wantWait || calls != step.wantCalls {
+			t.Fatalf("step %d: wait = %v, err = %v, calls = %d, want %v, %d", i, wait, err, calls, step.wantWait, step.wantCalls)
+		}
+	}
+	if d := cr.Status(); d["registrations"] != uint64(2) || d["failures"] != uint64(0) || d["last_error"] != nil {
+		t.Errorf("status = %v", d)
+	}
+}
+
+// TestMaintainStopsOnConfigError 首次注册的配置错误由 Maintain 返回，调用方据此退出
+func TestMaintainStopsOnConfigError(t *testing.T) {
+	cr := fakeAgentService(t, "battle-1", http.StatusOK)
+	tlsErr := common.ConsulAccessError(errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cr.Maintain(context.Background(), "battle-1", func(context.Context) error { return tlsErr }, func() bool { return false })
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, tlsErr) {
+			t.Errorf("Maintain = %v, want %v", err, tlsErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("首次注册的配置错误未使 Maintain 返回")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"gameproto"
+)
+
+const retransmitInterval = 50 * time.Millisecond // 重传扫描间隔，需明显小于可靠通道的首次重传超时
+
+// reliableCounters 可靠层累计计数，会话回收后仍保留
+type reliableCounters struct {
+	delivered   atomic.Uint64
+	duplicates  atomic.Uint64
+	retransmits atomic.Uint64
+	lost        atomic.Uint64
+}
+
+// processReliable 可靠帧经会话通道去重、排序后交给处理函数，响应同样以可靠帧在同一通道回复。
+// 重复或乱序到达的帧不会执行处理函数，只回复确认；纯确认帧不回复。
+// 超出 budget 的响应在进入通道前丢弃，不会被重传
+func (gs *GameServer) processReliable(sess *Session, req *gameproto.Frame, clientAddr *net.UDPAddr, budget *responseBudget) []*gameproto.Frame {
+	var deliver []*gameproto.Frame
+	var duplicate bool
+	sess.withChannel(req.Reliable.Channel, func(ch *gameproto.Channel) {
+		deliver, duplicate = ch.Receive(req)
+	})
+	if duplicate {
+		gs.reliable.duplicates.Add(1)
+	}
+
+	var out []*gameproto.Frame
+	for _, f := range deliver {
+		gs.reliable.delivered.Add(1)
+		full := gs.reassemble(sess, f)
+		if full == nil {
+			continue
+		}
+
+		// 处理函数可能读写会话状态，必须在会话锁外执行
+		responses := gs.fragment(gs.respond(sess, full, clientAddr))
+
+		var err error
+		sess.withChannel(req.Reliable.Channel, func(ch *gameproto.Channel) {
+			for _, resp := range responses {
+				if !budget.take(resp.Size() + gameproto.ReliableHeaderSize) {
+					continue
+				}
+				if err = ch.Send(resp, time.Now()); err != nil {
+					return
+				}
+				// 重传协程会刷新待确认帧的确认字段，返回副本供调用方在锁外编码
+				out = append(out, cloneReliable(resp))
+			}
+		})
+		if err != nil {
+			slog.Warn("无法发送可靠响应", "remote_addr", sess.Key, "channel", req.Reliable.Channel, "error", err)
+		}
+	}
+
+	if len(out) == 0 && req.Type != gameproto.TypeAck {
+		sess.withChannel(req.Reliable.Channel, func(ch *gameproto.Channel) {
+			out = append(out, ch.AckFrame(req.SessionID))
+		})
+		out = budget.filter(out)
+	}
+	return out
+}
+
+func cloneReliable(f *gameproto.Frame) *gameproto.Frame {
+	c := *f
+	h := *f.Reliable
+	c.Reliable = &h
+	return &c
+}
+
+// runRetransmitter 定期重传所有会话中未被确认的可靠帧，直到 ctx 结束
+func (gs *GameServer) runRetransmitter(ctx context.Context) {
+	ticker := time.NewTicker(retransmitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, sess := range gs.Sessions.Sessions() {
+				gs.retransmit(sess, now)
+			}
+		}
+	}
+}
+
+func (gs *GameServer) retransmit(sess *Session, now time.Time) {
+	var resend [][]byte
+	sess.eachChannel(func(ch *gameproto.Channel) {
+		frames, dropped := ch.Due(now)
+		for _, f := range dropped {
+			gs.reliable.lost.Add(1)
+			slog.Warn("可靠帧重试耗尽，已放弃", "remote_addr", sess.Key, "channel", ch.ID(), "seq", f.Reliable.Seq)
+		}
+		// 在锁内编码，避免与处理协程并发修改确认字段
+		for _, f := range frames {
+			b, err := gameproto.Encode(f)
+			if err != nil {
+				slog.Error("编码重传帧失败", "remote_addr", sess.Key, "error", err)
+				continue
+			}
+			resend = append(resend, b)
+		}
+	})
+
+	for _, b := range resend {
+		if _, err := gs.Conn.WriteToUDP(b, sess.ReplyAddr); err != nil {
+			slog.Warn("重传可靠帧失败", "remote_addr", sess.Key, "error", err)
+			continue
+		}
+		gs.reliable.retransmits.Add(1)
+	}
+}
+
+// reliablePending 所有会话中等待确认的可靠帧数
+func (gs *GameServer) reliablePending() int {
+	pending := 0
+	for _, sess := range gs.Sessions.Sessions() {
+		sess.eachChannel(func(ch *gameproto.Channel) {
+			pending += ch.Pending()
+		})
+	}
+	return pending
+}
+
+// reliableStats 可靠层统计，用于 GetServerInfo
+func (gs *GameServer) reliableStats() map[string]interface{} {
+	pending := gs.reliablePending()
+	return map[string]interface{}{
+		"delivered":   gs.reliable.delivered.Load(),
+		"duplicates":  gs.reliable.duplicates.Load(),
+		"retransmits": gs.reliable.retransmits.Load(),
+		"lost":        gs.reliable.lost.Load(),
+		"pending":     pending,
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// listenReusePort 非Linux平台不支持多读取套接字，UDP_READERS 需保持为1
+func listenReusePort(addr string) (*net.UDPConn, error) {
+	return nil, errors.New("当前平台不支持 SO_REUSEPORT，请将 UDP_READERS 设为1")
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"gameproto"
+)
+
+const (
+	defaultSessionIdleTimeout = 60 * time.Second // 与Envoy udp_proxy的idle_timeout保持一致
+	// defaultMaxSessions 会话数上限。未启用票据校验时任何源地址或会话ID都会建立会话，
+	// 每个会话还可能持有分片重组缓冲，上限防止伪造来源耗尽内存
+	defaultMaxSessions = 10000
+)
+
+// Session 一个玩家会话，以客户端地址为键，首次收到帧时创建
+type Session struct {
+	Key       string
+	Addr      *net.UDPAddr
+	SessionID uint64 // 客户端在帧头中携带的会话ID
+	CreatedAt time.Time
+	// ReplyAddr 响应与重传的目标地址。经PROXY协议获取真实地址时为Envoy的对端地址，与 Addr 不同
+	ReplyAddr *net.UDPAddr
+
+	mu         sync.Mutex
+	lastSeen   time.Time
+	lastSeq    uint32
+	received   uint64
+	duplicates uint64 // 序列号不大于已收到最大值的帧（重复或乱序）
+	state      map[string]interface{}
+	channels   map[uint8]*gameproto.Channel // 可靠通道，按需创建
+	reassembly *gameproto.Reassembler       // 分片重组，按需创建
+}
+
+// observe 记录一次收包及其序列号
+func (s *Session) observe(seq uint32, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastSeen = now
+	s.received++
+	if s.received > 1 && seq <= s.lastSeq {
+		s.duplicates++
+		return
+	}
+	s.lastSeq = seq
+}
+
+// LastSeen 最近一次收包时间
+func (s *Session) LastSeen() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeen
+}
+
+// LastSeq 已收到的最大序列号
+func (s *Session) LastSeq() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
+}
+
+// Duplicates 重复或乱序到达的帧数
+func (s *Session) Duplicates() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.duplicates
+}
+
+// withChannel 在会话锁内访问可靠通道，不存在时创建
+func (s *Session) withChannel(id uint8, fn func(ch *gameproto.Channel)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.channels == nil {
+		s.channels = make(map[uint8]*gameproto.Channel)
+	}
+	ch, ok := s.channels[id]
+	if !ok {
+		ch = gameproto.NewChannel(id, gameproto.DefaultReliableOptions)
+		s.channels[id] = ch
+	}
+	fn(ch)
+}
+
+// withReassembler 在会话锁内访问分片重组器，不存在时创建
+func (s *Session) withReassembler(fn func(r *gameproto.Reassembler), maxSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reassembly == nil {
+		s.reassembly = gameproto.NewReassembler(maxSize, fragmentTimeout)
+	}
+	fn(s.reassembly)
+}
+
+// eachChannel 在会话锁内遍历已创建的可靠通道
+func (s *Session) eachChannel(fn func(ch *gameproto.Channel)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.channels {
+		fn(ch)
+	}
+}
+
+// Get 读取会话级状态，供消息处理函数在多次请求之间保存数据
+func (s *Session) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.state[key]
+	return v, ok
+}
+
+// Set 写入会话级状态
+func (s *Session) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		s.state = make(map[string]interface{})
+	}
+	s.state[key] = value
+}
+
+// SessionManager 管理所有玩家会话，定期回收空闲会话
+type SessionManager struct {
+	idleTimeout time.Duration
+	maxSessions int
+
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	created  uint64
+	expired  uint64
+	rejected uint64 // 达到会话数上限而拒绝创建的会话
+}
+
+// NewSessionManager 创建会话管理器，idleTimeout 与 maxSessions 为0时使用默认值
+func NewSessionManager(idleTimeout time.Duration, maxSessions int) *SessionManager {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultSessionIdleTimeout
+	}
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxSessions
+	}
+	return &SessionManager{
+		idleTimeout: idleTimeout,
+		maxSessions: maxSessions,
+		sessions:    make(map[string]*Session),
+	}
+}
+
+// Touch 查找或创建客户端地址对应的会话，并记录本次收到的序列号。
+// 同一地址上的会话ID变化（如客户端重启）视为新会话，替换原会话。
+// 会话数已达上限时不创建新地址的会话，返回 nil
+func (sm *SessionManager) Touch(addr, replyAddr *net.UDPAddr, sessionID uint64, seq uint32) (sess *Session, created bool) {
+	key := addr.String()
+	now := time.Now()
+
+	sm.mu.RLock()
+	sess, ok := sm.sessions[key]
+	sm.mu.RUnlock()
+
+	if !ok || sess.SessionID != sessionID {
+		sm.mu.Lock()
+		sess, ok = sm.sessions[key]
+		if !ok && len(sm.sessions) >= sm.maxSessions {
+			sm.rejected++
+			sm.mu.Unlock()
+			return nil, false
+		}
+		if !ok || sess.SessionID != sessionID {
+			sess = &Session{
+				Key:       key,
+				Addr:      addr,
+				SessionID: sessionID,
+				CreatedAt: now,
+				ReplyAddr: replyAddr,
+			}
+			sm.sessions[key] = sess
+			sm.created++
+			created = true
+		}
+		sm.mu.Unlock()
+	}
+
+	sess.observe(seq, now)
+	return sess, created
+}
+
+// Find 查找客户端地址上会话ID一致的会话，不存在时返回 nil
+func (sm *SessionManager) Find(addr *net.UDPAddr, sessionID uint64) *Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if sess, ok := sm.sessions[addr.String()]; ok && sess.SessionID == sessionID {
+		return sess
+	}
+	return nil
+}
+
+// Remove 移除会话
+func (sm *SessionManager) Remove(key string) {
+	sm.mu.Lock()
+	delete(sm.sessions, key)
+	sm.mu.Unlock()
+}
+
+// Count 当前活跃会话数
+func (sm *SessionManager) Count() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.sessions)
+}
+
+// Rejected 达到会话数上限而拒绝创建的会话数
+func (sm *SessionManager) Rejected() uint64 {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.rejected
+}
+
+// ActiveSince 在 t 之后仍有流量的会话数
+func (sm *SessionManager) ActiveSince(t time.Time) int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	n := 0
+	for _, s := range sm.sessions {
+		if s.LastSeen().After(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// Sessions 返回当前所有会话的快照
+func (sm *SessionManager) Sessions() []*Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	out := make([]*Session, 0, len(sm.sessions))
+	for _, s := range sm.sessions {
+		out = append(out, s)
+	}
+	return out
+}
+
+// Stats 会话统计，用于 GetServerInfo 与健康检查接口
+func (sm *SessionManager) Stats() map[string]interface{} {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return map[string]interface{}{
+		"active":       len(sm.sessions),
+		"created":      sm.created,
+		"expired":      sm.expired,
+		"rejected":     sm.rejected,
+		"max_sessions": sm.maxSessions,
+		"idle_timeout": sm.idleTimeout.String(),
+	}
+}
+
+// Run 定期回收空闲会话，直到 ctx 结束
+func (sm *SessionManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(sm.idleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sm.expire(now)
+		}
+	}
+}
+
+func (sm *SessionManager) expire(now time.Time) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for key, s := range sm.sessions {
+		if now.Sub(s.LastSeen()) > sm.idleTimeout {
+			delete(sm.sessions, key)
+			sm.expired++
+			slog.Info("会话空闲超时，已回收", "remote_addr", key, "session", s.SessionID)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gameproto"
+)
+
+func TestSessionManagerTouch(t *testing.T) {
+	type touch struct {
+		addr        string
+		sessionID   uint64
+		seq         uint32
+		wantCreated bool
+		wantNil     bool // 达到上限被拒绝
+	}
+	tests := []struct {
+		name           string
+		maxSessions    int
+		touches        []touch
+		wantActive     int
+		wantCreated    uint64
+		wantRejected   uint64
+		wantDuplicates map[string]uint64 // 地址 -> 当前会话的重复帧数
+	}{
+		{
+			name: "首个帧创建会话",
+			touches: []touch{
+				{"1.1.1.1:1000", 7, 1, true, false},
+				{"1.1.1.1:1000", 7, 2, false, false},
+			},
+			wantActive: 1, wantCreated: 1,
+			wantDuplicates: map[string]uint64{"1.1.1.1:1000": 0},
+		},
+		{
+			name: "同一IP不同端口是不同会话",
+			touches: []touch{
+				{"1.1.1.1:1000", 7, 1, true, false},
+				{"1.1.1.1:1001", 7, 1, true, false},
+			},
+			wantActive: 2, wantCreated: 2,
+		},
+		{
+			name: "重复与乱序的帧",
+			touches: []touch{
+				{"1.1.1.1:1000", 7, 5, true, false},
+				{"1.1.1.1:1000", 7, 5, false, false},
+				{"1.1.1.1:1000", 7, 3, false, false},
+				{"1.1.1.1:1000", 7, 6, false, false},
+			},
+			wantActive: 1, wantCreated: 1,
+			wantDuplicates: map[string]uint64{"1.1.1.1:1000": 2},
+		},
+		{
+			name: "同一地址会话ID变化视为新会话",
+			touches: []touch{
+				{"1.1.1.1:1000", 7, 9, true, false},
+				{"1.1.1.1:1000", 7, 9, false, false},
+				{"1.1.1.1:1000", 8, 1, true, false},
+			},
+			wantActive: 1, wantCreated: 2,
+			// 新会话的序列号从头开始，不因旧会话的序列号被判为重复
+			wantDuplicates: map[string]uint64{"1.1.1.1:1000": 0},
+		},
+		{
+			name:        "达到上限拒绝新地址",
+			maxSessions: 2,
+			touches: []touch{
+				{"1.1.1.1:1000", 1, 1, true, false},
+				{"2.2.2.2:1000", 2, 1, true, false},
+				{"3.3.3.3:1000", 3, 1, false, true},
+				{"3.3.3.3:1000", 3, 2, false, true},
+				{"1.1.1.1:1000", 1, 2, false, false},
+			},
+			wantActive: 2, wantCreated: 2, wantRejected: 2,
+		},
+		{
+			name:        "达到上限时已有地址仍可更换会话ID",
+			maxSessions: 1,
+			touches: []touch{
+				{"1.1.1.1:1000", 1, 1, true, false},
+				{"1.1.1.1:1000", 2, 1, true, false},
+				{"2.2.2.2:1000", 3, 1, false, true},
+			},
+			wantActive: 1, wantCreated: 2, wantRejected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := NewSessionManager(time.Minute, tt.maxSessions)
+			for i, tc := range tt.touches {
+				addr := udpAddr(tc.addr)
+				sess, created := sm.Touch(addr, addr, tc.sessionID, tc.seq)
+				if (sess == nil) != tc.wantNil || created != tc.wantCreated {
+					t.Fatalf("touch %d: sess = %v, created = %v, want nil %v, created %v", i, sess, created, tc.wantNil, tc.wantCreated)
+				}
+				if sess != nil && (sess.SessionID != tc.sessionID || sess.Key != tc.addr) {
+					t.Fatalf("touch %d: session = %s/%d", i, sess.Key, sess.SessionID)
+				}
+			}
+			if got := sm.Count(); got != tt.wantActive {
+				t.Errorf("active = %d, want %d", got, tt.wantActive)
+			}
+			stats := sm.Stats()
+			if stats["created"] != tt.wantCreated || stats["rejected"] != tt.wantRejected {
+				t.Errorf("created = %v, rejected = %v, want %d, %d", stats["created"], stats["rejected"], tt.wantCreated, tt.wantRejected)
+			}
+			for addr, want := range tt.wantDuplicates {
+				sess := sm.sessions[addr]
+				if sess == nil || sess.Duplicates() != want {
+					t.Errorf("%s: duplicates = %v, want %d", addr, sess, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionManagerFind(t *testing.T) {
+	sm := NewSessionManager(time.Minute, 0)
+	addr := udpAddr("1.1.1.1:1000")
+	sm.Touch(addr, addr, 7, 1)
+
+	tests := []struct {
+		name      string
+		addr      *net.UDPAddr
+		sessionID uint64
+		want      bool
+	}{
+		{"地址与会话ID一致", addr, 7, true},
+		{"会话ID不一致", addr, 8, false},
+		{"其他端口", udpAddr("1.1.1.1:1001"), 7, false},
+		{"IPv4映射的IPv6地址视为同一地址", udpAddr("[::ffff:1.1.1.1]:1000"), 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sm.Find(tt.addr, tt.sessionID) != nil; got != tt.want {
+				t.Errorf("Find = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// Find 不创建会话，也不更新活跃时间
+	if sm.Count() != 1 {
+		t.Errorf("active = %d, want 1", sm.Count())
+	}
+}
+
+func TestSessionManagerExpire(t *testing.T) {
+	const idle = time.Minute
+	tests := []struct {
+		name       string
+		after      time.Duration // 最后一次收包后经过的时间
+		wantActive int
+	}{
+		{"未超时", idle - time.Second, 1},
+		{"恰好到达超时", idle, 1},
+		{"已超时", idle + time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := NewSessionManager(idle, 1)
+			addr := udpAddr("1.1.1.1:1000")
+			sess, _ := sm.Touch(addr, addr, 7, 1)
+
+			sm.expire(sess.LastSeen().Add(tt.after))
+			if got := sm.Count(); got != tt.wantActive {
+				t.Fatalf("active = %d, want %d", got, tt.wantActive)
+			}
+			if tt.wantActive == 0 {
+				if sm.Stats()["expired"] != uint64(1) {
+					t.Errorf("expired = %v, want 1", sm.Stats()["expired"])
+				}
+				// 回收后释放了上限名额
+				if sess, created := sm.Touch(udpAddr("2.2.2.2:1000"), addr, 8, 1); sess == nil || !created {
+					t.Error("回收后无法创建新会话")
+				}
+			}
+		})
+	}
+}
+
+func TestSessionManagerActiveSince(t *testing.T) {
+	sm := NewSessionManager(time.Minute, 0)
+	for _, a := range []string{"1.1.1.1:1000", "2.2.2.2:1000"} {
+		addr := udpAddr(a)
+		sm.Touch(addr, addr, 1, 1)
+	}
+	if got := sm.ActiveSince(time.Now().Add(-time.Second)); got != 2 {
+		t.Errorf("ActiveSince(1s前) = %d, want 2", got)
+	}
+	if got := sm.ActiveSince(time.Now().Add(time.Second)); got != 0 {
+		t.Errorf("ActiveSince(1s后) = %d, want 0", got)
+	}
+}
+
+// TestSessionLimitDropsFrames 会话数达到上限后，新地址的帧不建立会话也不回复
+func TestSessionLimitDropsFrames(t *testing.T) {
+	gs, err := NewGameServer("battle-1", 0, 7001, "127.0.0.1:8500")
+	if err != nil {
+		t.Fatalf("NewGameServer: %v", err)
+	}
+	gs.Sessions = NewSessionManager(time.Minute, 1)
+	first, second := udpAddr("10.0.0.1:5000"), udpAddr("10.0.0.2:5000")
+
+	hello := &gameproto.Frame{Type: gameproto.TypeHello, Seq: 1, SessionID: 7}
+	if replies := gs.hello(hello, first, first); len(replies) != 1 {
+		t.Fatalf("首个会话未得到 WELCOME")
+	}
+	if replies := gs.hello(hello, second, second); replies != nil {
+		t.Errorf("超过上限的 HELLO 得到回复 %+v", replies)
+	}
+	battle := &gameproto.Frame{Type: gameproto.TypeBattle, Seq: 2, SessionID: 9}
+	if sess := gs.session(battle, second, second); sess != nil {
+		t.Error("超过上限的帧建立了会话")
+	}
+	if got := gs.Sessions.Rejected(); got != 2 {
+		t.Errorf("rejected = %d, want 2", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gameproto"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	shutdownPollInterval   = 500 * time.Millisecond
+	// shutdownSessionIdle 会话在该时长内没有流量即视为已结束，不再等待其空闲超时
+	shutdownSessionIdle = 5 * time.Second
+)
+
+// Shutdown 优雅关闭：标记未就绪、设置Consul维护模式、通知所有会话、等待会话结束（最长到 ctx 截止），
+// 输出最终统计，然后注销并关闭UDP套接字。ctx 截止后不再等待会话，直接完成后续步骤
+func (gs *GameServer) Shutdown(ctx context.Context) {
+	if !gs.draining.CompareAndSwap(false, true) {
+		return
+	}
+	slog.Info("开始优雅关闭，/ready 返回503")
+
+	// 进入Consul维护模式后控制平面把该战斗服移出目录，端点标记为 DRAINING，Envoy不再为其建立新会话；
+	// 集群与监听器保留到 Stop 注销服务，已有会话在此之前继续转发
+	if gs.Registry != nil {
+		if err := gs.Registry.EnableMaintenance(gs.ServerID, "游戏服务器正在关闭"); err != nil {
+			slog.Warn("进入维护模式失败", "error", err)
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultShutdownTimeout)
+	}
+	notified := gs.notifyShutdown(deadline)
+	slog.Info("已通知会话服务器即将关闭", "sessions", notified)
+
+	gs.waitSessions(ctx)
+	gs.flushStats()
+	gs.Stop()
+}
+
+// Draining 是否处于优雅关闭过程中
+func (gs *GameServer) Draining() bool {
+	return gs.draining.Load()
+}
+
+// notifyShutdown 经可靠通道向所有会话发送 SHUTDOWN 帧，客户端未确认时由重传协程继续重发
+func (gs *GameServer) notifyShutdown(deadline time.Time) int {
+	payload := fmt.Appendf(nil, "SHUTDOWN from server %s: 服务器将于 %s 前关闭，请尽快结束战斗",
+		gs.ServerID, deadline.Format("2006-01-02 15:04:05"))
+
+	notified := 0
+	for _, sess := range gs.Sessions.Sessions() {
+		var b []byte
+		var err error
+		sess.withChannel(gameproto.ChannelBattle, func(ch *gameproto.Channel) {
+			f := &gameproto.Frame{Type: gameproto.TypeShutdown, SessionID: sess.SessionID, Payload: payload}
+			if err = ch.Send(f, time.Now()); err == nil {
+				b, err = gameproto.Encode(f)
+			}
+		})
+		if err != nil {
+			slog.Warn("通知会话失败", "remote_addr", sess.Key, "error", err)
+			continue
+		}
+		if _, err := gs.Conn.WriteToUDP(b, sess.ReplyAddr); err != nil {
+			slog.Warn("通知会话失败", "remote_addr", sess.Key, "error", err)
+			continue
+		}
+		notified++
+	}
+	return notified
+}
+
+// waitSessions 等待所有会话结束且可靠帧全部被确认，ctx 结束时放弃等待
+func (gs *GameServer) waitSessions(ctx context.Context) {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		active := gs.Sessions.ActiveSince(time.Now().Add(-shutdownSessionIdle))
+		pending := gs.reliablePending()
+		if active == 0 && pending == 0 {
+			slog.Info("所有会话已结束")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Warn("等待会话结束超时", "active_sessions", active, "reliable_pending", pending)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushStats 关闭前输出最终统计，避免最后一个抓取周期内的数据丢失
+func (gs *GameServer) flushStats() {
+	info, err := json.Marshal(gs.GetServerInfo())
+	if err != nil {
+		slog.Error("序列化最终统计失败", "error", err)
+		return
+	}
+	slog.Info("最终统计", "stats", json.RawMessage(info))
+}
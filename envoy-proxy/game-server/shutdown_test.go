@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gameproto"
+)
+
+// consulCalls 模拟Consul agent，按顺序记录服务维护与注销请求
+type consulCalls struct {
+	mu    sync.Mutex
+	calls []string
+	times []time.Time
+}
+
+func (c *consulCalls) server(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call string
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/service/maintenance/"):
+			call = "maintenance:" + r.URL.Query().Get("enable")
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+			call = "deregister"
+		default:
+			return
+		}
+		c.mu.Lock()
+		c.calls = append(c.calls, call)
+		c.times = append(c.times, time.Now())
+		c.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func (c *consulCalls) snapshot() ([]string, []time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...), append([]time.Time(nil), c.times...)
+}
+
+// TestShutdownOrder 关闭顺序：先进入维护模式（控制平面将端点标记为排空），再通知会话并等待，
+// 排空期间服务保持注册，等待结束后才注销，控制平面随之移除集群与监听器
+func TestShutdownOrder(t *testing.T) {
+	var consul consulCalls
+	gs, err := NewGameServer("battle-1", 0, 7001, consul.server(t))
+	if err != nil {
+		t.Fatalf("NewGameServer: %v", err)
+	}
+	gs.Readers = 1
+	gs.Workers = 1
+	if err := gs.startUDP(); err != nil {
+		t.Fatalf("startUDP: %v", err)
+	}
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer client.Close()
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+	gs.Sessions.Touch(clientAddr, clientAddr, 7, 1)
+
+	const drain = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		gs.Shutdown(ctx)
+		close(done)
+	}()
+
+	// 会话收到 SHUTDOWN 时服务已进入维护模式，但尚未注销
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("未收到 SHUTDOWN: %v", err)
+	}
+	f, err := gameproto.Decode(buf[:n])
+	if err != nil || f.Type != gameproto.TypeShutdown || f.SessionID != 7 {
+		t.Fatalf("frame = %+v, err = %v", f, err)
+	}
+	if calls, _ := consul.snapshot(); strings.Join(calls, ",") != "maintenance:true" {
+		t.Fatalf("通知会话时 Consul 调用 = %v, want [maintenance:true]", calls)
+	}
+	if !gs.Draining() {
+		t.Error("关闭过程中 Draining() = false")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown 未在期限后返回")
+	}
+	calls, times := consul.snapshot()
+	if strings.Join(calls, ",") != "maintenance:true,deregister" {
+		t.Fatalf("Consul 调用 = %v, want [maintenance:true deregister]", calls)
+	}
+	// 会话仍活跃，注销发生在排空期限之后
+	if waited := times[1].Sub(start); waited < drain {
+		t.Errorf("排空 %v 后即注销，want >= %v", waited, drain)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultUDPReaders  = 1
+	defaultWorkerQueue = 1024
+)
+
+// packet 读取协程交给处理协程的一个数据报。data 来自缓冲池，处理完成后归还
+type packet struct {
+	sock *udpSocket   // 收到该数据报的套接字，响应从同一套接字发出
+	addr *net.UDPAddr // 数据报的直接发送方
+	buf  *[]byte
+	n    int
+	// limited 入队前已按发送方地址限流，处理协程不再重复扣减令牌
+	limited bool
+}
+
+func (p *packet) data() []byte {
+	return (*p.buf)[:p.n]
+}
+
+// workerPool 固定数量的处理协程，每个协程一个有界队列。
+// 同一发送方地址的数据报总是进入同一队列，保证单个会话内按到达顺序处理；
+// 队列满时丢弃新数据报而不阻塞读取协程，避免一个慢处理函数拖住所有玩家。
+// 处理协程连续处理队列中已有的数据报，攒够 batchSize 个响应或队列为空时统一发送
+type workerPool struct {
+	queues    []chan *packet
+	handle    func(p *packet) [][]byte
+	batchSize int
+	io        *ioStats
+	bufs      sync.Pool
+	wg        sync.WaitGroup
+
+	enqueued  atomic.Uint64
+	dropped   atomic.Uint64
+	processed atomic.Uint64
+	busy      atomic.Int64 // 正在执行处理函数的协程数
+}
+
+func newWorkerPool(workers, queueSize, bufSize, batchSize int, io *ioStats, handle func(p *packet) [][]byte) *workerPool {
+	wp := &workerPool{
+		queues:    make([]chan *packet, workers),
+		handle:    handle,
+		batchSize: batchSize,
+		io:        io,
+	}
+	for i := range wp.queues {
+		wp.queues[i] = make(chan *packet, queueSize)
+	}
+	wp.bufs.New = func() interface{} {
+		b := make([]byte, bufSize)
+		return &b
+	}
+	return wp
+}
+
+func (wp *workerPool) start() {
+	for _, q := range wp.queues {
+		wp.wg.Add(1)
+		go wp.run(q)
+	}
+}
+
+func (wp *workerPool) run(q chan *packet) {
+	defer wp.wg.Done()
+
+	out := newOutbox(wp.io)
+	for p := range q {
+		wp.process(p, out)
+		for out.len() < wp.batchSize && wp.processQueued(q, out) {
+		}
+		out.flush()
+	}
+}
+
+// processQueued 不阻塞地处理队列中的下一个数据报，队列为空或已关闭时返回 false
+func (wp *workerPool) processQueued(q chan *packet, out *outbox) bool {
+	select {
+	case p, ok := <-q:
+		if !ok {
+			return false
+		}
+		wp.process(p, out)
+		return true
+	default:
+		return false
+	}
+}
+
+func (wp *workerPool) process(p *packet, out *outbox) {
+	wp.busy.Add(1)
+	for _, response := range wp.handle(p) {
+		if len(response) > 0 {
+			out.add(p.sock, p.addr, response)
+		}
+	}
+	wp.busy.Add(-1)
+	wp.processed.Add(1)
+	wp.bufs.Put(p.buf)
+}
+
+// getBuffer 从缓冲池取出一个读取缓冲区
+func (wp *workerPool) getBuffer() *[]byte {
+	return wp.bufs.Get().(*[]byte)
+}
+
+// submit 按发送方地址选择队列，队列已满时丢弃并返回 false
+func (wp *workerPool) submit(p *packet) bool {
+	q := wp.queues[addrHash(p.addr)%uint32(len(wp.queues))]
+	select {
+	case q <- p:
+		wp.enqueued.Add(1)
+		return true
+	default:
+		wp.dropped.Add(1)
+		wp.bufs.Put(p.buf)
+		return false
+	}
+}
+
+// stop 关闭所有队列并等待已入队的数据报处理完毕，调用前读取协程必须已经退出
+func (wp *workerPool) stop() {
+	for _, q := range wp.queues {
+		close(q)
+	}
+	wp.wg.Wait()
+}
+
+// stats 队列深度与丢弃计数，用于观察处理能力是否跟得上读取
+func (wp *workerPool) stats() map[string]interface{} {
+	depth := make([]int, len(wp.queues))
+	total := 0
+	for i, q := range wp.queues {
+		depth[i] = len(q)
+		total += depth[i]
+	}
+	return map[string]interface{}{
+		"workers":        len(wp.queues),
+		"queue_capacity": cap(wp.queues[0]),
+		"queue_depth":    depth,
+		"queued":         total,
+		"busy":           wp.busy.Load(),
+		"enqueued":       wp.enqueued.Load(),
+		"processed":      wp.processed.Load(),
+		"dropped":        wp.dropped.Load(),
+	}
+}
+
+func addrHash(addr *net.UDPAddr) uint32 {
+	h := fnv.New32a()
+	h.Write(addr.IP)
+	h.Write([]byte{byte(addr.Port >> 8), byte(addr.Port)})
+	return h.Sum32()
+}
+
+// listenUDP 监听UDP端口，n>1 时通过 SO_REUSEPORT 创建多个套接字，由内核在套接字间分发数据报
+func listenUDP(addr string, n int) ([]*net.UDPConn, error) {
+	if n <= 1 {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("解析UDP地址失败: %v", err)
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+
+	conns := make([]*net.UDPConn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := listenReusePort(addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// readLoop 单个套接字的读取循环，只负责读取与入队，处理在工作协程中进行。
+// 支持批量读取时使用 recvmmsg，内核不支持时回退为逐个读取
+func (gs *GameServer) readLoop(sock *udpSocket) {
+	defer gs.readers.Done()
+
+	if sock.batch != nil && !gs.readBatchLoop(sock) {
+		return
+	}
+
+	for {
+		gs.liveness.heartbeat()
+		gs.signalTTL()
+
+		// 设置读取超时，没有流量时也能周期性更新心跳
+		sock.conn.SetReadDeadline(time.Now().Add(udpReadHeartbeat))
+
+		buf := gs.workers.getBuffer()
+		n, remoteAddr, err := sock.conn.ReadFromUDP(*buf)
+		if err != nil {
+			gs.workers.bufs.Put(buf)
+			if gs.readFailed(err) {
+				return
+			}
+			continue
+		}
+		gs.liveness.markRead()
+		gs.io.reads.Add(1)
+		gs.io.readPackets.Add(1)
+
+		p := &packet{sock: sock, addr: remoteAddr, buf: buf, n: n}
+		if gs.truncated(p) {
+			gs.workers.bufs.Put(buf)
+			continue
+		}
+		gs.enqueue(p)
+	}
+}
+
+// readFailed 处理读取错误，连接已关闭时返回 true 表示读取循环应退出
+func (gs *GameServer) readFailed(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		slog.Info("UDP连接已关闭，读取循环退出")
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	gs.liveness.markError()
+	slog.Error("读取UDP数据失败", "error", err)
+	return false
+}
+
+// enqueue 将数据报交给处理协程，队列满时丢弃并按2的幂次记录日志，避免日志风暴。
+// 发送方即客户端（直连或透明模式）时先限流再入队，超限来源的数据报不占用队列，不会挤掉同一队列上其他玩家的数据报
+func (gs *GameServer) enqueue(p *packet) {
+	if gs.limiter != nil && !gs.viaTrustedProxy(p.addr) {
+		p.limited = true
+		if !gs.allowSource(p.addr, time.Now()) {
+			gs.workers.bufs.Put(p.buf)
+			return
+		}
+	}
+	if !gs.workers.submit(p) {
+		if dropped := gs.workers.dropped.Load(); dropped&(dropped-1) == 0 {
+			slog.Warn("处理队列已满，丢弃数据报", "remote_addr", p.addr.String(), "dropped", dropped)
+		}
+	}
+}
+
+// processPacket 在工作协程中处理一个数据报，返回需要回复给 p.addr 的数据报
+func (gs *GameServer) processPacket(p *packet) [][]byte {
+	// clientAddr 为真实客户端地址，p.addr 为数据报的直接发送方（经代理时为Envoy）
+	payload, clientAddr, err := gs.resolveClient(p.data(), p.addr)
+	if err != nil {
+		// 按2的幂次记录日志，避免伪造协议头刷屏
+		if n := gs.io.badProxyHeaders.Add(1); n&(n-1) == 0 {
+			slog.Warn("丢弃数据报：PROXY协议头无效", "remote_addr", p.addr.String(), "dropped", n, "error", err)
+		}
+		return nil
+	}
+
+	// 经可信代理转发的数据报解析协议头后才知道客户端地址，在这里按真实客户端地址限流
+	if gs.limiter != nil && !p.limited && !gs.allowSource(clientAddr, time.Now()) {
+		return nil
+	}
+
+	// 处理消息：二进制帧走帧协议，其余按文本协议处理（兼容健康检查与nc调试）
+	return gs.handleDatagram(payload, clientAddr, p.addr)
+}
+
+// viaTrustedProxy 数据报是否来自可信代理，此时真实客户端地址在PROXY协议头中
+func (gs *GameServer) viaTrustedProxy(addr *net.UDPAddr) bool {
+	return gs.ProxyProtocol && containsAddr(gs.TrustedProxies, addr.AddrPort().Addr().Unmap())
+}
+
+// allowSource 按客户端地址限流，封禁与超限的数据报不处理也不回复
+func (gs *GameServer) allowSource(addr *net.UDPAddr, now time.Time) bool {
+	reason, ok := gs.limiter.allow(addr, now)
+	if !ok {
+		if n := gs.limiter.dropped[reason].Load(); n&(n-1) == 0 {
+			slog.Warn("丢弃数据报：超过来源限制", "remote_addr", addr.String(), "reason", dropReasonNames[reason], "dropped", n)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestServer 只启动UDP读取与处理协程，不连接Consul
+func startTestServer(tb testing.TB, readers, workers, batchSize int) (*GameServer, *net.UDPAddr) {
+	tb.Helper()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// 多个 SO_REUSEPORT 套接字需要监听同一个确定的端口
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatalf("分配端口失败: %v", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	gs, err := NewGameServer("bench-server", port, port, "127.0.0.1:8500")
+	if err != nil {
+		tb.Fatalf("NewGameServer: %v", err)
+	}
+	gs.Readers, gs.Workers, gs.BatchSize = readers, workers, batchSize
+	if err := gs.startUDP(); err != nil {
+		tb.Fatalf("startUDP: %v", err)
+	}
+	tb.Cleanup(func() {
+		gs.cancel()
+		gs.stopUDP()
+	})
+	return gs, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+// pingLoad clients 个客户端各自保持 window 个未完成的 PING，直到共收到 total 个响应。
+// 队列满被丢弃的请求在读取超时后补发，返回补发次数
+func pingLoad(tb testing.TB, addr *net.UDPAddr, clients, window, total int) int64 {
+	tb.Helper()
+	var remaining, resent atomic.Int64
+	remaining.Store(int64(total))
+	msg := []byte("PING")
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, clients)
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.DialUDP("udp", nil, addr)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			defer conn.Close()
+
+			buf := make([]byte, 1500)
+			for i := 0; i < window; i++ {
+				conn.Write(msg)
+			}
+			for remaining.Load() > 0 {
+				conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+				if _, err := conn.Read(buf); err != nil {
+					var netErr net.Error
+					if !errors.As(err, &netErr) || !netErr.Timeout() {
+						errCh <- err
+						return
+					}
+					for i := 0; i < window; i++ {
+						conn.Write(msg)
+					}
+					resent.Add(int64(window))
+					continue
+				}
+				if remaining.Add(-1) <= 0 {
+					return
+				}
+				conn.Write(msg)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		tb.Fatalf("压测客户端失败: %v", err)
+	}
+	return resent.Load()
+}
+
+func TestMetricsExportWorkerAndIOCounters(t *testing.T) {
+	gs, addr := startTestServer(t, 1, 2, defaultBatchSize)
+	pingLoad(t, addr, 4, 4, 200)
+
+	rec := httptest.NewRecorder()
+	gs.MetricsHandler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	values := make(map[string]float64)
+	for _, line := range strings.Split(body, "\n") {
+		var name string
+		var v float64
+		if _, err := fmt.Sscanf(line, "%s %g", &name, &v); err == nil && !strings.HasPrefix(name, "#") {
+			values[name] = v
+		}
+	}
+
+	// 客户端补发时请求数会多于 200，系统调用次数取决于批量大小，只要求非零
+	for name, want := range map[string]float64{
+		"game_udp_read_syscalls_total":  1,
+		"game_udp_read_packets_total":   200,
+		"game_udp_write_syscalls_total": 1,
+		"game_udp_write_packets_total":  200,
+		"game_worker_enqueued_total":    200,
+		"game_worker_processed_total":   200,
+	} {
+		if values[name] < want {
+			t.Errorf("%s = %v, want >= %v", name, values[name], want)
+		}
+	}
+	if values["game_udp_read_packets_total"] < values["game_udp_read_syscalls_total"] {
+		t.Errorf("读取的数据报数少于系统调用次数")
+	}
+	if values["game_workers"] != 2 || values["game_worker_queue_capacity"] != 2*defaultWorkerQueue {
+		t.Errorf("game_workers = %v, game_worker_queue_capacity = %v", values["game_workers"], values["game_worker_queue_capacity"])
+	}
+	if values["game_udp_batch_size"] != defaultBatchSize {
+		t.Errorf("game_udp_batch_size = %v", values["game_udp_batch_size"])
+	}
+}
+
+// BenchmarkServerPing 经完整的读取、处理与发送路径压测文本 PING，比较逐个收发与批量收发。
+// 每个 op 是一次请求-响应，pkts/s 为每秒完成的请求数，read_batch/write_batch 为平均每次系统调用收发的数据报数
+func BenchmarkServerPing(b *testing.B) {
+	for _, bc := range []struct {
+		readers, batch int
+	}{
+		{1, 1}, {1, defaultBatchSize}, {4, 1}, {4, defaultBatchSize},
+	} {
+		b.Run(fmt.Sprintf("readers=%d/batch=%d", bc.readers, bc.batch), func(b *testing.B) {
+			gs, addr := startTestServer(b, bc.readers, 4, bc.batch)
+
+			b.ResetTimer()
+			start := time.Now()
+			resent := pingLoad(b, addr, 32, 8, b.N)
+			elapsed := time.Since(start)
+			b.StopTimer()
+
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "pkts/s")
+			b.ReportMetric(float64(gs.io.readPackets.Load())/float64(max(gs.io.reads.Load(), 1)), "read_batch")
+			b.ReportMetric(float64(gs.io.writePackets.Load())/float64(max(gs.io.writes.Load(), 1)), "write_batch")
+			b.ReportMetric(float64(resent), "resent")
+		})
+	}
+}
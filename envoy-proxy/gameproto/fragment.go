@@ -0,0 +1,197 @@
+package gameproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 分片负载布局（大端序），每个分片是一个 TypeFragment 帧，沿用原消息的序列号与会话ID:
+//
+//	+----------+----------+----------+----------+
+//	| 原类型   | 分片序号 | 分片总数 | 分片数据 |
+//	| 1B       | 2B       | 2B       | N字节    |
+//	+----------+----------+----------+----------+
+const (
+	// FragmentHeaderSize 分片负载中的头部长度
+	FragmentHeaderSize = 5
+
+	// DefaultMTU 以太网默认MTU
+	DefaultMTU = 1500
+	// MinMTU 支持的最小路径MTU（IPv4要求所有主机都能接收的数据报长度），
+	// Fragment 不会按更小的数据报拆分，Reassembler 据此推算合法消息的最大分片数
+	MinMTU = 576
+
+	// ipUDPOverhead IPv6头(40B)与UDP头(8B)，按IPv6计算，IPv4路径上留有余量
+	ipUDPOverhead = 48
+
+	// maxPartialMessages 同时重组中的消息上限，防止伪造分片耗尽内存
+	maxPartialMessages = 64
+
+	// minFragmentData MinMTU 下每个分片至少携带的数据长度（最后一个分片除外）
+	minFragmentData = MinMTU - ipUDPOverhead - Overhead - ReliableHeaderSize - FragmentHeaderSize
+)
+
+var (
+	ErrFragment         = errors.New("gameproto: 无效的分片")
+	ErrDatagramTooSmall = errors.New("gameproto: 数据报长度上限不足以容纳分片")
+	ErrTooManyPartial   = errors.New("gameproto: 重组中的消息过多")
+)
+
+// MaxDatagramForMTU 给定路径MTU下不会触发IP分片的最大UDP数据报长度
+func MaxDatagramForMTU(mtu int) int {
+	return mtu - ipUDPOverhead
+}
+
+// Fragment 编码后超过 maxDatagram 的帧拆分为多个 TypeFragment 帧，否则原样返回。
+// 长度计算预留了可靠层头部，分片可以直接经 Channel 可靠发送
+func Fragment(f *Frame, maxDatagram int) ([]*Frame, error) {
+	if Overhead+ReliableHeaderSize+len(f.Payload) <= maxDatagram {
+		return []*Frame{f}, nil
+	}
+
+	if maxDatagram < MaxDatagramForMTU(MinMTU) {
+		return nil, fmt.Errorf("%w: %d", ErrDatagramTooSmall, maxDatagram)
+	}
+	chunk := maxDatagram - Overhead - ReliableHeaderSize - FragmentHeaderSize
+	count := (len(f.Payload) + chunk - 1) / chunk
+	if count > 1<<16-1 {
+		return nil, ErrPayloadTooLarge
+	}
+
+	frames := make([]*Frame, 0, count)
+	for i := 0; i < count; i++ {
+		data := f.Payload[i*chunk : min((i+1)*chunk, len(f.Payload))]
+
+		payload := make([]byte, 0, FragmentHeaderSize+len(data))
+		payload = append(payload, uint8(f.Type))
+		payload = binary.BigEndian.AppendUint16(payload, uint16(i))
+		payload = binary.BigEndian.AppendUint16(payload, uint16(count))
+		payload = append(payload, data...)
+
+		frames = append(frames, &Frame{
+			Type:      TypeFragment,
+			Seq:       f.Seq,
+			SessionID: f.SessionID,
+			Payload:   payload,
+		})
+	}
+	return frames, nil
+}
+
+type fragmentKey struct {
+	sessionID uint64
+	seq       uint32
+}
+
+type partialMessage struct {
+	typ    MessageType
+	chunks [][]byte
+	// seen 已收到的分片序号，空分片的数据也是空切片，不能用 chunks[i] 是否为 nil 判断重复
+	seen     []bool
+	received int
+	size     int
+	started  time.Time
+}
+
+// Reassembler 将 TypeFragment 帧重组为原消息。分片可乱序到达，超时未收齐的消息被丢弃。
+// Reassembler 不是并发安全的，由调用方加锁
+type Reassembler struct {
+	maxSize int
+	// maxFragments 重组 maxSize 长度的消息最多需要的分片数，分片总数由对端声明，超过时不分配直接拒绝
+	maxFragments int
+	timeout      time.Duration
+	partial      map[fragmentKey]*partialMessage
+	lastSweep    time.Time
+	expired      uint64
+}
+
+// NewReassembler 创建重组器，maxSize 为重组后消息负载的最大长度
+func NewReassembler(maxSize int, timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		maxSize:      maxSize,
+		maxFragments: (maxSize + minFragmentData - 1) / minFragmentData,
+		timeout:      timeout,
+		partial:      make(map[fragmentKey]*partialMessage),
+	}
+}
+
+// Add 加入一个分片，消息收齐时返回重组后的帧，否则返回 nil
+func (r *Reassembler) Add(f *Frame, now time.Time) (*Frame, error) {
+	r.sweep(now)
+
+	if len(f.Payload) < FragmentHeaderSize {
+		return nil, fmt.Errorf("%w: 长度 %d", ErrFragment, len(f.Payload))
+	}
+	typ := MessageType(f.Payload[0])
+	index := int(binary.BigEndian.Uint16(f.Payload[1:3]))
+	count := int(binary.BigEndian.Uint16(f.Payload[3:5]))
+	data := f.Payload[FragmentHeaderSize:]
+	if count == 0 || index >= count || typ == TypeFragment {
+		return nil, fmt.Errorf("%w: 类型 %s 序号 %d/%d", ErrFragment, typ, index, count)
+	}
+	if count > r.maxFragments {
+		return nil, fmt.Errorf("%w: 分片总数 %d 超过 %d", ErrPayloadTooLarge, count, r.maxFragments)
+	}
+
+	key := fragmentKey{sessionID: f.SessionID, seq: f.Seq}
+	p, ok := r.partial[key]
+	if !ok {
+		if len(r.partial) >= maxPartialMessages {
+			return nil, ErrTooManyPartial
+		}
+		p = &partialMessage{typ: typ, chunks: make([][]byte, count), seen: make([]bool, count), started: now}
+		r.partial[key] = p
+	}
+	if p.typ != typ || len(p.chunks) != count {
+		delete(r.partial, key)
+		return nil, fmt.Errorf("%w: 与先前分片的类型或总数不一致", ErrFragment)
+	}
+	if p.seen[index] {
+		return nil, nil // 重复分片
+	}
+
+	p.size += len(data)
+	if p.size > r.maxSize {
+		delete(r.partial, key)
+		return nil, fmt.Errorf("%w: 重组后长度超过 %d", ErrPayloadTooLarge, r.maxSize)
+	}
+	p.chunks[index] = append(make([]byte, 0, len(data)), data...) // 负载引用读取缓冲区，需拷贝
+	p.seen[index] = true
+	p.received++
+	if p.received < count {
+		return nil, nil
+	}
+
+	delete(r.partial, key)
+	payload := make([]byte, 0, p.size)
+	for _, c := range p.chunks {
+		payload = append(payload, c...)
+	}
+	return &Frame{Type: p.typ, Seq: f.Seq, SessionID: f.SessionID, Payload: payload}, nil
+}
+
+// Pending 重组中的消息数
+func (r *Reassembler) Pending() int {
+	return len(r.partial)
+}
+
+// Expired 因超时被丢弃的消息数
+func (r *Reassembler) Expired() uint64 {
+	return r.expired
+}
+
+// sweep 每个超时周期最多清理一次过期消息
+func (r *Reassembler) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.timeout {
+		return
+	}
+	r.lastSweep = now
+	for key, p := range r.partial {
+		if now.Sub(p.started) > r.timeout {
+			delete(r.partial, key)
+			r.expired++
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package gameproto
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func testPayload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func fragmentPayload(typ MessageType, index, count uint16, data []byte) []byte {
+	return append([]byte{byte(typ), byte(index >> 8), byte(index), byte(count >> 8), byte(count)}, data...)
+}
+
+func TestFragmentSmallFrameUnchanged(t *testing.T) {
+	maxDatagram := MaxDatagramForMTU(DefaultMTU)
+	f := &Frame{Type: TypeBattleResponse, Seq: 1, Payload: testPayload(maxDatagram - Overhead - ReliableHeaderSize)}
+	frames, err := Fragment(f, maxDatagram)
+	if err != nil {
+		t.Fatalf("Fragment: %v", err)
+	}
+	if len(frames) != 1 || frames[0] != f {
+		t.Fatalf("恰好放得下的帧被拆分为 %d 个分片", len(frames))
+	}
+}
+
+func TestFragmentReassemble(t *testing.T) {
+	tests := []struct {
+		name        string
+		payloadLen  int
+		maxDatagram int
+		wantCount   int
+	}{
+		{"超出一个字节", 1453, 1452, 2},
+		{"恰好整数个分片", 3 * (600 - Overhead - ReliableHeaderSize - FragmentHeaderSize), 600, 3},
+		{"最后一个分片只有一个字节", 2*(600-Overhead-ReliableHeaderSize-FragmentHeaderSize) + 1, 600, 3},
+		{"默认MTU下的大消息", 60000, MaxDatagramForMTU(DefaultMTU), 43},
+		{"最小路径MTU", 10 * minFragmentData, MaxDatagramForMTU(MinMTU), 10},
+		{"最小路径MTU下的最大消息", MaxPayloadSize, MaxDatagramForMTU(MinMTU), (MaxPayloadSize + minFragmentData - 1) / minFragmentData},
+	}
+
+	orders := map[string]func([]*Frame) []*Frame{
+		"顺序": func(fs []*Frame) []*Frame { return fs },
+		"逆序": func(fs []*Frame) []*Frame {
+			out := make([]*Frame, len(fs))
+			for i, f := range fs {
+				out[len(fs)-1-i] = f
+			}
+			return out
+		},
+		"乱序": func(fs []*Frame) []*Frame {
+			out := append([]*Frame(nil), fs...)
+			rand.New(rand.NewSource(1)).Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+			return out
+		},
+	}
+
+	for _, tt := range tests {
+		for orderName, order := range orders {
+			t.Run(tt.name+"/"+orderName, func(t *testing.T) {
+				orig := &Frame{Type: TypeBattleResponse, Seq: 9, SessionID: 77, Payload: testPayload(tt.payloadLen)}
+				frames, err := Fragment(orig, tt.maxDatagram)
+				if err != nil {
+					t.Fatalf("Fragment: %v", err)
+				}
+				if len(frames) != tt.wantCount {
+					t.Fatalf("分片数 = %d, want %d", len(frames), tt.wantCount)
+				}
+				for i, f := range frames {
+					// 分片经 Channel 可靠发送时附加可靠层头部，仍不能超过数据报上限
+					if size := f.Size() + ReliableHeaderSize; size > tt.maxDatagram {
+						t.Fatalf("分片 %d 长度 %d 超过 %d", i, size, tt.maxDatagram)
+					}
+					if f.Type != TypeFragment || f.Seq != orig.Seq || f.SessionID != orig.SessionID {
+						t.Fatalf("分片 %d = %+v", i, f)
+					}
+				}
+
+				r := NewReassembler(MaxPayloadSize, time.Second)
+				now := time.Now()
+				var got *Frame
+				for i, f := range order(frames) {
+					// 分片经编解码后再重组，与实际收包路径一致
+					decoded, err := Decode(mustEncode(t, f))
+					if err != nil {
+						t.Fatalf("Decode: %v", err)
+					}
+					msg, err := r.Add(decoded, now)
+					if err != nil {
+						t.Fatalf("Add: %v", err)
+					}
+					if (msg != nil) != (i == len(frames)-1) {
+						t.Fatalf("第 %d 个分片后 msg = %v", i, msg)
+					}
+					got = msg
+				}
+				if !framesEqual(got, orig) {
+					t.Errorf("重组结果与原消息不同: type %s len %d", got.Type, len(got.Payload))
+				}
+				if r.Pending() != 0 {
+					t.Errorf("Pending = %d, want 0", r.Pending())
+				}
+			})
+		}
+	}
+}
+
+func TestFragmentErrors(t *testing.T) {
+	f := &Frame{Type: TypeBattle, Payload: testPayload(1000)}
+	if _, err := Fragment(f, MaxDatagramForMTU(MinMTU)-1); !errors.Is(err, ErrDatagramTooSmall) {
+		t.Errorf("err = %v, want %v", err, ErrDatagramTooSmall)
+	}
+}
+
+func TestReassemblerRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{"分片头部不完整", []byte{byte(TypeBattle), 0, 0, 0}, ErrFragment},
+		{"总数为0", fragmentPayload(TypeBattle, 0, 0, []byte("a")), ErrFragment},
+		{"序号超出总数", fragmentPayload(TypeBattle, 2, 2, []byte("a")), ErrFragment},
+		{"嵌套分片", fragmentPayload(TypeFragment, 0, 2, []byte("a")), ErrFragment},
+		{"单个分片超过上限", fragmentPayload(TypeBattle, 0, 1, testPayload(65)), ErrPayloadTooLarge},
+		{"分片总数超过上限", fragmentPayload(TypeBattle, 0, 2, []byte("a")), ErrPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(64, time.Second)
+			msg, err := r.Add(&Frame{Type: TypeFragment, Payload: tt.payload}, time.Now())
+			if !errors.Is(err, tt.want) || msg != nil {
+				t.Fatalf("Add = (%v, %v), want error %v", msg, err, tt.want)
+			}
+			if r.Pending() != 0 {
+				t.Errorf("出错后仍有 %d 条重组中的消息", r.Pending())
+			}
+		})
+	}
+}
+
+func TestReassemblerInconsistentFragments(t *testing.T) {
+	tests := []struct {
+		name   string
+		second []byte
+	}{
+		{"类型不一致", fragmentPayload(TypeStatus, 1, 3, []byte("b"))},
+		{"总数不一致", fragmentPayload(TypeBattle, 1, 4, []byte("b"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(4096, time.Second)
+			now := time.Now()
+			if _, err := r.Add(&Frame{Type: TypeFragment, Seq: 1, Payload: fragmentPayload(TypeBattle, 0, 3, []byte("a"))}, now); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			if _, err := r.Add(&Frame{Type: TypeFragment, Seq: 1, Payload: tt.second}, now); !errors.Is(err, ErrFragment) {
+				t.Fatalf("err = %v, want %v", err, ErrFragment)
+			}
+			if r.Pending() != 0 {
+				t.Errorf("不一致的消息没有被丢弃")
+			}
+		})
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	now := time.Now()
+
+	t.Run("重组后超过上限", func(t *testing.T) {
+		r := NewReassembler(600, time.Second)
+		if _, err := r.Add(&Frame{Type: TypeFragment, Payload: fragmentPayload(TypeBattle, 0, 2, testPayload(400))}, now); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if _, err := r.Add(&Frame{Type: TypeFragment, Payload: fragmentPayload(TypeBattle, 1, 2, testPayload(400))}, now); !errors.Is(err, ErrPayloadTooLarge) {
+			t.Fatalf("err = %v, want %v", err, ErrPayloadTooLarge)
+		}
+		if r.Pending() != 0 {
+			t.Errorf("超过上限的消息没有被丢弃")
+		}
+	})
+
+	t.Run("重组中的消息过多", func(t *testing.T) {
+		r := NewReassembler(1024, time.Second)
+		for i := 0; i < maxPartialMessages; i++ {
+			if _, err := r.Add(&Frame{Type: TypeFragment, Seq: uint32(i), Payload: fragmentPayload(TypeBattle, 0, 2, []byte("a"))}, now); err != nil {
+				t.Fatalf("Add %d: %v", i, err)
+			}
+		}
+		_, err := r.Add(&Frame{Type: TypeFragment, Seq: maxPartialMessages, Payload: fragmentPayload(TypeBattle, 0, 2, []byte("a"))}, now)
+		if !errors.Is(err, ErrTooManyPartial) {
+			t.Fatalf("err = %v, want %v", err, ErrTooManyPartial)
+		}
+		// 已在重组中的消息仍可收齐
+		msg, err := r.Add(&Frame{Type: TypeFragment, Seq: 0, Payload: fragmentPayload(TypeBattle, 1, 2, []byte("b"))}, now)
+		if err != nil || msg == nil || string(msg.Payload) != "ab" {
+			t.Fatalf("Add = (%v, %v), want ab", msg, err)
+		}
+	})
+
+	t.Run("超时丢弃", func(t *testing.T) {
+		r := NewReassembler(1024, time.Second)
+		if _, err := r.Add(&Frame{Type: TypeFragment, Seq: 1, Payload: fragmentPayload(TypeBattle, 0, 2, []byte("a"))}, now); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		later := now.Add(2 * time.Second)
+		msg, err := r.Add(&Frame{Type: TypeFragment, Seq: 1, Payload: fragmentPayload(TypeBattle, 1, 2, []byte("b"))}, later)
+		if err != nil || msg != nil {
+			t.Fatalf("超时后的分片不应组成消息: (%v, %v)", msg, err)
+		}
+		if r.Expired() != 1 || r.Pending() != 1 {
+			t.Errorf("Expired = %d, Pending = %d, want 1, 1", r.Expired(), r.Pending())
+		}
+	})
+}
+
+func TestReassemblerDuplicate(t *testing.T) {
+	r := NewReassembler(1024, time.Second)
+	now := time.Now()
+	first := &Frame{Type: TypeFragment, Seq: 1, Payload: fragmentPayload(TypeBattle, 0, 2, []byte("a"))}
+	for i := 0; i < 2; i++ {
+		if msg, err := r.Add(first, now); err != nil || msg != nil {
+			t.Fatalf("Add #%d = (%v, %v)", i, msg, err)
+		}
+	}
+	msg, err := r.Add(&Frame{Type: TypeFragment, Seq: 1, Payload: fragmentPayload(TypeBattle, 1, 2, []byte("b"))}, now)
+	if err != nil || msg == nil || !bytes.Equal(msg.Payload, []byte("ab")) {
+		t.Fatalf("Add = (%v, %v), want ab", msg, err)
+	}
+}
+
+func TestReassemblerRejectsHugeCountBeforeAllocating(t *testing.T) {
+	r := NewReassembler(MaxPayloadSize, time.Second)
+	f := &Frame{Type: TypeFragment, Payload: fragmentPayload(TypeBattle, 0, 1<<16-1, []byte("a"))}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := r.Add(f, time.Now()); !errors.Is(err, ErrPayloadTooLarge) {
+			t.Fatalf("err = %v, want %v", err, ErrPayloadTooLarge)
+		}
+	})
+	// 只允许错误信息本身的少量分配，不能按声明的总数分配分片表
+	if allocs > 5 {
+		t.Errorf("拒绝前分配了 %.0f 次", allocs)
+	}
+	if r.Pending() != 0 {
+		t.Errorf("Pending = %d, want 0", r.Pending())
+	}
+}
+
+func TestReassemblerEmptyChunkDuplicate(t *testing.T) {
+	r := NewReassembler(1024, time.Second)
+	now := time.Now()
+	empty := &Frame{Type: TypeFragment, Seq: 1, Payload: fragmentPayload(TypeBattle, 0, 2, nil)}
+
+	// 空分片重复到达不能被计为两个分片，否则消息在缺少分片1时就被交付
+	for i := 0; i < 2; i++ {
+		if msg, err := r.Add(empty, now); err != nil || msg != nil {
+			t.Fatalf("Add #%d = (%v, %v), want (nil, nil)", i, msg, err)
+		}
+	}
+	msg, err := r.Add(&Frame{Type: TypeFragment, Seq: 1, Payload: fragmentPayload(TypeBattle, 1, 2, []byte("b"))}, now)
+	if err != nil || msg == nil || !bytes.Equal(msg.Payload, []byte("b")) {
+		t.Fatalf("Add = (%v, %v), want b", msg, err)
+	}
+}
@@ -0,0 +1,191 @@
+// Package gameproto 定义游戏服务器与客户端之间的二进制帧格式。
+//
+// 帧布局（大端序）:
+//
+//	0      2        3      4         8            16          18         18+N
+//	+------+--------+------+---------+------------+-----------+----------+-------+
+//	| 魔数 | 版本号 | 类型 | 序列号  | 会话ID     | 负载长度N | 负载     | CRC32 |
+//	| 2B   | 1B     | 1B   | 4B      | 8B         | 2B        | N字节    | 4B    |
+//	+------+--------+------+---------+------------+-----------+----------+-------+
+//
+// 类型字段最高位为可靠标志，置位时固定头部之后紧跟13字节可靠层头部（见 ReliableHeader），
+// 负载长度字段不包含可靠层头部。CRC32(Castagnoli) 覆盖头部、可靠层头部与负载。一个UDP数据报恰好承载一帧。
+package gameproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	// Magic 帧魔数 "GB"
+	Magic uint16 = 0x4742
+	// Version 当前协议版本
+	Version uint8 = 1
+
+	// HeaderSize 固定头部长度
+	HeaderSize = 18
+	// TrailerSize 校验和长度
+	TrailerSize = 4
+	// Overhead 每帧除负载外的固定开销
+	Overhead = HeaderSize + TrailerSize
+	// MaxPayloadSize 负载长度字段可表示的最大值
+	MaxPayloadSize = 1<<16 - 1
+
+	// reliableFlag 类型字段中的可靠标志位，消息类型本身只使用低7位
+	reliableFlag uint8 = 0x80
+)
+
+var (
+	ErrShortFrame      = errors.New("gameproto: 帧长度不足")
+	ErrBadMagic        = errors.New("gameproto: 魔数不匹配")
+	ErrVersion         = errors.New("gameproto: 不支持的协议版本")
+	ErrLength          = errors.New("gameproto: 负载长度与数据报长度不一致")
+	ErrChecksum        = errors.New("gameproto: 校验和不匹配")
+	ErrPayloadTooLarge = errors.New("gameproto: 负载超过最大长度")
+	ErrTypeRange       = errors.New("gameproto: 消息类型超出范围")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// MessageType 消息类型
+type MessageType uint8
+
+const (
+	TypePing           MessageType = 0x01
+	TypePong           MessageType = 0x02
+	TypeBattle         MessageType = 0x03
+	TypeBattleResponse MessageType = 0x04
+	TypeStatus         MessageType = 0x05
+	TypeStatusResponse MessageType = 0x06
+	TypeEcho           MessageType = 0x07
+	TypeEchoResponse   MessageType = 0x08
+	TypeAck            MessageType = 0x09 // 纯确认帧，只携带可靠层头部
+	TypeFragment       MessageType = 0x0A // 大消息的一个分片，见 Fragment
+	TypeShutdown       MessageType = 0x0B // 服务器主动通知即将关闭，序列号为0
+	TypeHello          MessageType = 0x0C // 会话的首个帧，负载为匹配服务签发的票据，见 TicketKeys
+	TypeWelcome        MessageType = 0x0D // 票据校验通过，会话已建立
+	TypeError          MessageType = 0x7F
+)
+
+var typeNames = map[MessageType]string{
+	TypePing:           "PING",
+	TypePong:           "PONG",
+	TypeBattle:         "BATTLE",
+	TypeBattleResponse: "BATTLE_RESPONSE",
+	TypeStatus:         "STATUS",
+	TypeStatusResponse: "STATUS_RESPONSE",
+	TypeEcho:           "ECHO",
+	TypeEchoResponse:   "ECHO_RESPONSE",
+	TypeAck:            "ACK",
+	TypeFragment:       "FRAGMENT",
+	TypeShutdown:       "SHUTDOWN",
+	TypeHello:          "HELLO",
+	TypeWelcome:        "WELCOME",
+	TypeError:          "ERROR",
+}
+
+func (t MessageType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE_0x%02X", uint8(t))
+}
+
+// Frame 一个协议帧
+type Frame struct {
+	Type      MessageType
+	Seq       uint32
+	SessionID uint64
+	// Reliable 非空时为可靠帧，由 Channel 填充
+	Reliable *ReliableHeader
+	Payload  []byte
+}
+
+// Size 编码后的帧长度
+func (f *Frame) Size() int {
+	if f.Reliable != nil {
+		return Overhead + ReliableHeaderSize + len(f.Payload)
+	}
+	return Overhead + len(f.Payload)
+}
+
+// AppendFrame 将帧编码后追加到 dst，便于复用缓冲区
+func AppendFrame(dst []byte, f *Frame) ([]byte, error) {
+	if len(f.Payload) > MaxPayloadSize {
+		return dst, ErrPayloadTooLarge
+	}
+	if uint8(f.Type)&reliableFlag != 0 {
+		return dst, fmt.Errorf("%w: 0x%02X", ErrTypeRange, uint8(f.Type))
+	}
+
+	typ := uint8(f.Type)
+	if f.Reliable != nil {
+		typ |= reliableFlag
+	}
+
+	start := len(dst)
+	dst = binary.BigEndian.AppendUint16(dst, Magic)
+	dst = append(dst, Version, typ)
+	dst = binary.BigEndian.AppendUint32(dst, f.Seq)
+	dst = binary.BigEndian.AppendUint64(dst, f.SessionID)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(f.Payload)))
+	if f.Reliable != nil {
+		dst = f.Reliable.append(dst)
+	}
+	dst = append(dst, f.Payload...)
+	dst = binary.BigEndian.AppendUint32(dst, crc32.Checksum(dst[start:], crcTable))
+	return dst, nil
+}
+
+// Encode 编码帧
+func Encode(f *Frame) ([]byte, error) {
+	return AppendFrame(make([]byte, 0, f.Size()), f)
+}
+
+// IsFrame 判断数据报是否以帧魔数开头，用于与文本协议（如健康检查的 PING）区分
+func IsFrame(b []byte) bool {
+	return len(b) >= 2 && binary.BigEndian.Uint16(b) == Magic
+}
+
+// Decode 解码一个完整的数据报。返回帧的 Payload 引用 b 的底层数组，调用方复用 b 前需自行拷贝
+func Decode(b []byte) (*Frame, error) {
+	if len(b) < Overhead {
+		return nil, ErrShortFrame
+	}
+	if binary.BigEndian.Uint16(b) != Magic {
+		return nil, ErrBadMagic
+	}
+	if b[2] != Version {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, b[2])
+	}
+
+	reliable := b[3]&reliableFlag != 0
+	headerLen := HeaderSize
+	if reliable {
+		headerLen += ReliableHeaderSize
+	}
+
+	payloadLen := int(binary.BigEndian.Uint16(b[16:18]))
+	if len(b) != headerLen+payloadLen+TrailerSize {
+		return nil, fmt.Errorf("%w: 负载长度 %d，数据报长度 %d", ErrLength, payloadLen, len(b))
+	}
+
+	body := b[:headerLen+payloadLen]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(b[headerLen+payloadLen:]) {
+		return nil, ErrChecksum
+	}
+
+	f := &Frame{
+		Type:      MessageType(b[3] &^ reliableFlag),
+		Seq:       binary.BigEndian.Uint32(b[4:8]),
+		SessionID: binary.BigEndian.Uint64(b[8:16]),
+		Payload:   b[headerLen : headerLen+payloadLen],
+	}
+	if reliable {
+		f.Reliable = parseReliableHeader(b[HeaderSize:headerLen])
+	}
+	return f, nil
+}
@@ -0,0 +1,170 @@
+package gameproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func mustEncode(t testing.TB, f *Frame) []byte {
+	t.Helper()
+	b, err := Encode(f)
+	if err != nil {
+		t.Fatalf("Encode(%+v): %v", f, err)
+	}
+	return b
+}
+
+func framesEqual(a, b *Frame) bool {
+	if a.Type != b.Type || a.Seq != b.Seq || a.SessionID != b.SessionID || !bytes.Equal(a.Payload, b.Payload) {
+		return false
+	}
+	if (a.Reliable == nil) != (b.Reliable == nil) {
+		return false
+	}
+	return a.Reliable == nil || *a.Reliable == *b.Reliable
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *Frame
+	}{
+		{"空负载", &Frame{Type: TypePing, Seq: 1, SessionID: 42}},
+		{"普通负载", &Frame{Type: TypeBattle, Seq: 7, SessionID: 0xDEADBEEF, Payload: []byte(`{"attack":10}`)}},
+		{"字段最大值", &Frame{Type: TypeError, Seq: 1<<32 - 1, SessionID: 1<<64 - 1, Payload: []byte{0}}},
+		{"可靠帧", &Frame{Type: TypeBattleResponse, Seq: 3, SessionID: 9,
+			Reliable: &ReliableHeader{Channel: ChannelBattle, Seq: 5, Ack: 4, AckBits: 0x80000001}, Payload: []byte("ok")}},
+		{"纯确认帧", &Frame{Type: TypeAck, SessionID: 9, Reliable: &ReliableHeader{Channel: ChannelBattle, Ack: 12, AckBits: 0xFF}}},
+		{"最大负载", &Frame{Type: TypeEcho, Payload: bytes.Repeat([]byte{0xAB}, MaxPayloadSize)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := mustEncode(t, tt.frame)
+			if len(b) != tt.frame.Size() {
+				t.Fatalf("len = %d, Size() = %d", len(b), tt.frame.Size())
+			}
+			if !IsFrame(b) {
+				t.Fatal("IsFrame = false")
+			}
+			got, err := Decode(b)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !framesEqual(got, tt.frame) {
+				t.Errorf("Decode = %+v, want %+v", got, tt.frame)
+			}
+		})
+	}
+}
+
+func TestAppendFrameKeepsPrefix(t *testing.T) {
+	f := &Frame{Type: TypeStatus, Seq: 2, SessionID: 3, Payload: []byte("x")}
+	prefix := []byte("prefix")
+	b, err := AppendFrame(append([]byte(nil), prefix...), f)
+	if err != nil {
+		t.Fatalf("AppendFrame: %v", err)
+	}
+	if !bytes.HasPrefix(b, prefix) {
+		t.Fatalf("前缀被覆盖: %q", b)
+	}
+	// 校验和只覆盖本帧，不包含 dst 中已有的数据
+	if !bytes.Equal(b[len(prefix):], mustEncode(t, f)) {
+		t.Errorf("追加的帧与 Encode 结果不同")
+	}
+}
+
+func TestAppendFrameErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *Frame
+		want  error
+	}{
+		{"负载过长", &Frame{Type: TypeEcho, Payload: make([]byte, MaxPayloadSize+1)}, ErrPayloadTooLarge},
+		{"类型占用可靠标志位", &Frame{Type: MessageType(0x81)}, ErrTypeRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Encode(tt.frame); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := mustEncode(t, &Frame{Type: TypeBattle, Seq: 1, SessionID: 2, Payload: []byte("payload")})
+	reliable := mustEncode(t, &Frame{Type: TypeBattle, Seq: 1, SessionID: 2, Payload: []byte("payload"),
+		Reliable: &ReliableHeader{Channel: ChannelBattle, Seq: 1}})
+
+	modify := func(b []byte, fn func([]byte) []byte) []byte {
+		return fn(append([]byte(nil), b...))
+	}
+	// withCRC 修改帧内容后重新计算校验和，使错误只来自被修改的字段
+	withCRC := func(b []byte) []byte {
+		n := len(b) - TrailerSize
+		binary.BigEndian.PutUint32(b[n:], crc32.Checksum(b[:n], crcTable))
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		datagram []byte
+		want     error
+	}{
+		{"空数据报", nil, ErrShortFrame},
+		{"短于固定开销", valid[:Overhead-1], ErrShortFrame},
+		{"文本协议", []byte("PING health check request"), ErrBadMagic},
+		{"魔数错误", modify(valid, func(b []byte) []byte { b[0] = 'X'; return withCRC(b) }), ErrBadMagic},
+		{"版本错误", modify(valid, func(b []byte) []byte { b[2] = Version + 1; return withCRC(b) }), ErrVersion},
+		{"多出尾部字节", append(append([]byte(nil), valid...), 0), ErrLength},
+		{"缺少尾部字节", valid[:len(valid)-1], ErrLength},
+		{"负载长度字段偏大", modify(valid, func(b []byte) []byte { b[17]++; return withCRC(b) }), ErrLength},
+		{"负载长度字段偏小", modify(valid, func(b []byte) []byte { b[17]--; return withCRC(b) }), ErrLength},
+		{"可靠标志缺少可靠层头部", modify(valid, func(b []byte) []byte { b[3] |= reliableFlag; return withCRC(b) }), ErrLength},
+		{"可靠帧清除可靠标志", modify(reliable, func(b []byte) []byte { b[3] &^= reliableFlag; return withCRC(b) }), ErrLength},
+		{"头部被篡改", modify(valid, func(b []byte) []byte { b[5] ^= 1; return b }), ErrChecksum},
+		{"负载被篡改", modify(valid, func(b []byte) []byte { b[HeaderSize] ^= 1; return b }), ErrChecksum},
+		{"可靠层头部被篡改", modify(reliable, func(b []byte) []byte { b[HeaderSize+1] ^= 1; return b }), ErrChecksum},
+		{"校验和被篡改", modify(valid, func(b []byte) []byte { b[len(b)-1] ^= 1; return b }), ErrChecksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Decode(tt.datagram)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if f != nil {
+				t.Errorf("出错时返回了帧 %+v", f)
+			}
+		})
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("PING"))
+	f.Add(mustEncode(f, &Frame{Type: TypePing, Seq: 1, SessionID: 1}))
+	f.Add(mustEncode(f, &Frame{Type: TypeBattle, Seq: 2, SessionID: 3, Payload: []byte("battle")}))
+	f.Add(mustEncode(f, &Frame{Type: TypeAck, Reliable: &ReliableHeader{Channel: ChannelBattle, Ack: 1}}))
+	f.Add(mustEncode(f, &Frame{Type: TypeFragment, Seq: 4, Payload: []byte{byte(TypeBattle), 0, 0, 0, 2, 'a'}}))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		fr, err := Decode(b)
+		if err != nil {
+			return
+		}
+		// 能解码的数据报重新编码后必须逐字节相同
+		out, err := Encode(fr)
+		if err != nil {
+			t.Fatalf("Encode(Decode(b)): %v", err)
+		}
+		if !bytes.Equal(out, b) {
+			t.Fatalf("重新编码结果不同:\n got %x\nwant %x", out, b)
+		}
+	})
+}
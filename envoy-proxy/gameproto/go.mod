@@ -0,0 +1,3 @@
+module gameproto
+
+go 1.21
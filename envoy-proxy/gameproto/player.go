@@ -0,0 +1,115 @@
+package gameproto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 玩家凭证由登录服务在玩家登录后签发，客户端请求目录服务时以 "Authorization: Bearer <凭证>" 携带，
+// 目录服务校验通过后才为该玩家签发会话票据。凭证为下列字节的 base64url（无填充）编码（大端序）:
+//
+//	+------+-----------+--------+----------+----------+----------+--------+--------+
+//	| 版本 | 密钥ID长度 | 密钥ID | 签发时间 | 过期时间 | 玩家ID长度 | 玩家ID | 签名   |
+//	| 1B   | 1B        | N字节  | 8B       | 8B       | 1B       | M字节  | 32B    |
+//	+------+-----------+--------+----------+----------+----------+--------+--------+
+//
+// 签名为 HMAC-SHA256，覆盖 playerTokenDomain 与签名之前的全部字节，即使误用了与票据相同的密钥，
+// 会话票据也不能当作玩家凭证使用。密钥沿用 TicketKeys 的格式与轮换方式，但应与票据密钥分开配置
+const playerTokenVersion uint8 = 1
+
+var playerTokenDomain = []byte("gameproto player token\x00")
+
+var (
+	ErrPlayerTokenFormat      = errors.New("gameproto: 玩家凭证格式无效")
+	ErrPlayerTokenKey         = errors.New("gameproto: 玩家凭证密钥未知")
+	ErrPlayerTokenSignature   = errors.New("gameproto: 玩家凭证签名无效")
+	ErrPlayerTokenExpired     = errors.New("gameproto: 玩家凭证已过期")
+	ErrPlayerTokenNotYetValid = errors.New("gameproto: 玩家凭证签发时间晚于当前时间")
+)
+
+// PlayerToken 玩家凭证内容
+type PlayerToken struct {
+	KeyID     string
+	PlayerID  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// IssuePlayerToken 用当前签发密钥为玩家签发凭证，返回可直接放入 Authorization 头的字符串
+func (k *TicketKeys) IssuePlayerToken(playerID string, ttl time.Duration, now time.Time) (string, error) {
+	if playerID == "" || len(playerID) > 255 {
+		return "", fmt.Errorf("%w: 玩家ID为空或过长", ErrPlayerTokenFormat)
+	}
+
+	b := make([]byte, 0, 2+len(k.active)+16+1+len(playerID)+ticketMACSize)
+	b = append(b, playerTokenVersion, uint8(len(k.active)))
+	b = append(b, k.active...)
+	b = binary.BigEndian.AppendUint64(b, uint64(now.Unix()))
+	b = binary.BigEndian.AppendUint64(b, uint64(now.Add(ttl).Unix()))
+	b = append(b, uint8(len(playerID)))
+	b = append(b, playerID...)
+
+	b = append(b, playerTokenMAC(k.keys[k.active], b)...)
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// VerifyPlayerToken 校验玩家凭证的签名与有效期
+func (k *TicketKeys) VerifyPlayerToken(s string, now time.Time) (*PlayerToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrPlayerTokenFormat
+	}
+	p, signed, err := decodePlayerToken(b)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := k.keys[p.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrPlayerTokenKey, p.KeyID)
+	}
+	if !hmac.Equal(playerTokenMAC(secret, b[:signed]), b[signed:]) {
+		return nil, ErrPlayerTokenSignature
+	}
+
+	if now.After(p.ExpiresAt) {
+		return nil, ErrPlayerTokenExpired
+	}
+	if p.IssuedAt.After(now.Add(TicketClockSkew)) {
+		return nil, ErrPlayerTokenNotYetValid
+	}
+	return p, nil
+}
+
+func playerTokenMAC(secret, signed []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(playerTokenDomain)
+	mac.Write(signed)
+	return mac.Sum(nil)
+}
+
+// decodePlayerToken 解析凭证内容但不校验签名，返回签名之前的字节数
+func decodePlayerToken(b []byte) (*PlayerToken, int, error) {
+	if len(b) < 2 || b[0] != playerTokenVersion {
+		return nil, 0, ErrPlayerTokenFormat
+	}
+	off := 2 + int(b[1])
+	if len(b) < off+17 {
+		return nil, 0, ErrPlayerTokenFormat
+	}
+	p := &PlayerToken{KeyID: string(b[2:off])}
+	p.IssuedAt = time.Unix(int64(binary.BigEndian.Uint64(b[off:])), 0)
+	p.ExpiresAt = time.Unix(int64(binary.BigEndian.Uint64(b[off+8:])), 0)
+	n := int(b[off+16])
+	off += 17
+	if n == 0 || len(b) != off+n+ticketMACSize {
+		return nil, 0, ErrPlayerTokenFormat
+	}
+	p.PlayerID = string(b[off : off+n])
+	return p, off + n, nil
+}
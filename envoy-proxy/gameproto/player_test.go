@@ -0,0 +1,96 @@
+package gameproto
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlayerTokenIssueVerify(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	current := mustTicketKeys(t, "p2:"+testSecret(2)+",p1:"+testSecret(1))
+	previous := mustTicketKeys(t, "p1:"+testSecret(1))
+	other := mustTicketKeys(t, "p2:"+testSecret(9))
+
+	issue := func(k *TicketKeys, playerID string, ttl time.Duration, at time.Time) string {
+		s, err := k.IssuePlayerToken(playerID, ttl, at)
+		if err != nil {
+			t.Fatalf("IssuePlayerToken: %v", err)
+		}
+		return s
+	}
+	tamper := func(s string, i int) string {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		b[i] ^= 1
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	valid := issue(current, "player-42", time.Hour, now)
+	raw, _ := base64.RawURLEncoding.DecodeString(valid)
+
+	// 用同一组密钥签发的会话票据不能当作玩家凭证
+	ticket, err := current.Issue("player-42", 42, time.Hour, now)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		keys  *TicketKeys
+		token string
+		now   time.Time
+		want  error
+	}{
+		{"有效", current, valid, now, nil},
+		{"有效期内", current, valid, now.Add(time.Hour), nil},
+		{"已过期", current, valid, now.Add(time.Hour + time.Second), ErrPlayerTokenExpired},
+		{"容忍时钟偏差", current, issue(current, "player-42", time.Hour, now.Add(TicketClockSkew)), now, nil},
+		{"签发时间晚于当前时间", current, issue(current, "player-42", time.Hour, now.Add(TicketClockSkew+time.Second)), now, ErrPlayerTokenNotYetValid},
+		{"轮换后旧密钥签发的凭证仍有效", current, issue(previous, "player-42", time.Hour, now), now, nil},
+		{"校验方尚未加入新密钥", previous, valid, now, ErrPlayerTokenKey},
+		{"同ID不同密钥", other, valid, now, ErrPlayerTokenSignature},
+		{"过期时间被篡改", current, tamper(valid, 2+len("p2")+15), now, ErrPlayerTokenSignature},
+		{"玩家ID被篡改", current, tamper(valid, len(raw)-ticketMACSize-1), now, ErrPlayerTokenSignature},
+		{"签名被篡改", current, tamper(valid, len(raw)-1), now, ErrPlayerTokenSignature},
+		{"版本错误", current, tamper(valid, 0), now, ErrPlayerTokenFormat},
+		{"被截断", current, base64.RawURLEncoding.EncodeToString(raw[:len(raw)-1]), now, ErrPlayerTokenFormat},
+		{"不是base64url", current, valid + "!", now, ErrPlayerTokenFormat},
+		{"为空", current, "", now, ErrPlayerTokenFormat},
+		{"会话票据", current, base64.RawURLEncoding.EncodeToString(ticket), now, ErrPlayerTokenFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.keys.VerifyPlayerToken(tt.token, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if err == nil && p.PlayerID != "player-42" {
+				t.Errorf("token = %+v", p)
+			}
+		})
+	}
+}
+
+func TestIssuePlayerTokenInvalidID(t *testing.T) {
+	k := mustTicketKeys(t, "p1:"+testSecret(1))
+	for _, id := range []string{"", strings.Repeat("p", 256)} {
+		if _, err := k.IssuePlayerToken(id, time.Hour, time.Now()); !errors.Is(err, ErrPlayerTokenFormat) {
+			t.Errorf("IssuePlayerToken(len %d) err = %v, want %v", len(id), err, ErrPlayerTokenFormat)
+		}
+	}
+}
+
+// TestPlayerTokenTicketDomain 会话票据与玩家凭证的签名互不通用：即使布局恰好可解析，签名也不匹配
+func TestPlayerTokenTicketDomain(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	k := mustTicketKeys(t, "k1:"+testSecret(1))
+	token, err := k.IssuePlayerToken("player-42", time.Hour, now)
+	if err != nil {
+		t.Fatalf("IssuePlayerToken: %v", err)
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(token)
+	if _, err := k.Verify(b, now); err == nil {
+		t.Error("玩家凭证被当作会话票据接受")
+	}
+}
@@ -0,0 +1,277 @@
+package gameproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// 可靠层头部布局（大端序），位于固定头部与负载之间:
+//
+//	+------+------------+------------+------------+
+//	| 通道 | 通道序列号 | 确认序列号 | 确认位图   |
+//	| 1B   | 4B         | 4B         | 4B         |
+//	+------+------------+------------+------------+
+//
+// 确认序列号为本端在该通道上收到的最大序列号，确认位图第 i 位表示是否收到 确认序列号-1-i，
+// 发送端据此只重传真正缺失的帧。通道序列号从1开始，0 表示不携带数据（纯确认帧）。
+const (
+	// ReliableHeaderSize 可靠层头部长度
+	ReliableHeaderSize = 13
+
+	// ChannelBattle 战斗结果等需要恰好一次、按序送达的关键消息
+	ChannelBattle uint8 = 1
+
+	// ackBitsWindow 确认位图覆盖的序列号范围，也是接收侧乱序缓存的最大跨度，
+	// 保证缓存的帧一定能被确认
+	ackBitsWindow = 32
+)
+
+var (
+	ErrSendWindowFull = errors.New("gameproto: 可靠通道待确认帧已满")
+)
+
+// ReliableHeader 可靠层头部
+type ReliableHeader struct {
+	Channel uint8
+	Seq     uint32
+	Ack     uint32
+	AckBits uint32
+}
+
+func (h *ReliableHeader) append(dst []byte) []byte {
+	dst = append(dst, h.Channel)
+	dst = binary.BigEndian.AppendUint32(dst, h.Seq)
+	dst = binary.BigEndian.AppendUint32(dst, h.Ack)
+	return binary.BigEndian.AppendUint32(dst, h.AckBits)
+}
+
+func parseReliableHeader(b []byte) *ReliableHeader {
+	return &ReliableHeader{
+		Channel: b[0],
+		Seq:     binary.BigEndian.Uint32(b[1:5]),
+		Ack:     binary.BigEndian.Uint32(b[5:9]),
+		AckBits: binary.BigEndian.Uint32(b[9:13]),
+	}
+}
+
+// ReliableOptions 可靠通道参数
+type ReliableOptions struct {
+	RTO        time.Duration // 首次重传超时
+	MaxRTO     time.Duration // 指数退避上限
+	MaxRetries int           // 超过后放弃该帧
+	SendWindow int           // 最多同时等待确认的帧数
+}
+
+// DefaultReliableOptions 适合局域网到公网游戏场景的默认参数
+var DefaultReliableOptions = ReliableOptions{
+	RTO:        200 * time.Millisecond,
+	MaxRTO:     2 * time.Second,
+	MaxRetries: 8,
+	SendWindow: 64,
+}
+
+type pendingFrame struct {
+	frame   *Frame
+	sentAt  time.Time
+	rto     time.Duration
+	retries int
+}
+
+// Channel 可靠通道一端的状态：发送侧分配序列号并跟踪待确认帧，接收侧去重并按序交付。
+// 序列号按消息递增，不处理32位回绕。Channel 不是并发安全的，由调用方加锁。
+type Channel struct {
+	id   uint8
+	opts ReliableOptions
+
+	// 发送侧
+	nextSeq uint32
+	pending map[uint32]*pendingFrame
+
+	// 接收侧
+	delivered uint32 // 已按序交付的最大序列号
+	highest   uint32 // 收到的最大序列号
+	ackBits   uint32
+	buffered  map[uint32]*Frame
+
+	retransmits uint64
+	lost        uint64
+	duplicates  uint64
+}
+
+// NewChannel 创建可靠通道，opts 中的零值字段使用默认参数
+func NewChannel(id uint8, opts ReliableOptions) *Channel {
+	if opts.RTO <= 0 {
+		opts.RTO = DefaultReliableOptions.RTO
+	}
+	if opts.MaxRTO <= 0 {
+		opts.MaxRTO = DefaultReliableOptions.MaxRTO
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = DefaultReliableOptions.MaxRetries
+	}
+	if opts.SendWindow <= 0 {
+		opts.SendWindow = DefaultReliableOptions.SendWindow
+	}
+	return &Channel{
+		id:       id,
+		opts:     opts,
+		pending:  make(map[uint32]*pendingFrame),
+		buffered: make(map[uint32]*Frame),
+	}
+}
+
+// ID 通道号
+func (c *Channel) ID() uint8 {
+	return c.id
+}
+
+// Send 为帧分配通道序列号并附加当前确认信息，帧进入待确认队列直到对端确认。
+// 调用方负责首次发送，此后由 Due 返回需要重传的帧。
+func (c *Channel) Send(f *Frame, now time.Time) error {
+	if len(c.pending) >= c.opts.SendWindow {
+		return ErrSendWindowFull
+	}
+
+	c.nextSeq++
+	f.Reliable = &ReliableHeader{Channel: c.id, Seq: c.nextSeq}
+	c.stampAck(f.Reliable)
+	c.pending[c.nextSeq] = &pendingFrame{frame: f, sentAt: now, rto: c.opts.RTO}
+	return nil
+}
+
+// Receive 处理对端发来的可靠帧：先根据其确认信息清理待确认队列，
+// 再对数据帧去重，返回按序可交付的帧（可能为空，也可能连带交付此前缓存的乱序帧）。
+// 返回 duplicate 为 true 时该帧已收到过，调用方应重新回复确认。
+func (c *Channel) Receive(f *Frame) (deliver []*Frame, duplicate bool) {
+	h := f.Reliable
+	if h == nil {
+		return nil, false
+	}
+	c.ack(h)
+
+	seq := h.Seq
+	if seq == 0 {
+		return nil, false
+	}
+	if _, ok := c.buffered[seq]; ok || seq <= c.delivered {
+		c.duplicates++
+		return nil, true
+	}
+	if seq-c.delivered > ackBitsWindow {
+		// 超出接收窗口，不记录也不确认，等待对端重传
+		return nil, false
+	}
+	c.markReceived(seq)
+
+	if seq != c.delivered+1 {
+		f.Payload = append([]byte(nil), f.Payload...) // 负载引用读取缓冲区，缓存前拷贝
+		c.buffered[seq] = f
+		return nil, false
+	}
+
+	c.delivered = seq
+	deliver = append(deliver, f)
+	for {
+		next, ok := c.buffered[c.delivered+1]
+		if !ok {
+			break
+		}
+		delete(c.buffered, c.delivered+1)
+		c.delivered++
+		deliver = append(deliver, next)
+	}
+	return deliver, false
+}
+
+// AckFrame 生成携带当前确认信息的纯确认帧
+func (c *Channel) AckFrame(sessionID uint64) *Frame {
+	h := &ReliableHeader{Channel: c.id}
+	c.stampAck(h)
+	return &Frame{Type: TypeAck, SessionID: sessionID, Reliable: h}
+}
+
+// Due 返回到期需要重传的帧（已刷新确认信息）以及重试耗尽被放弃的帧
+func (c *Channel) Due(now time.Time) (resend, dropped []*Frame) {
+	for seq, p := range c.pending {
+		if now.Sub(p.sentAt) < p.rto {
+			continue
+		}
+		if p.retries >= c.opts.MaxRetries {
+			delete(c.pending, seq)
+			c.lost++
+			dropped = append(dropped, p.frame)
+			continue
+		}
+
+		p.retries++
+		p.sentAt = now
+		p.rto *= 2
+		if p.rto > c.opts.MaxRTO {
+			p.rto = c.opts.MaxRTO
+		}
+		c.stampAck(p.frame.Reliable)
+		c.retransmits++
+		resend = append(resend, p.frame)
+	}
+	return resend, dropped
+}
+
+// NextDue 最早一个待确认帧的重传时间，没有待确认帧时返回 false
+func (c *Channel) NextDue() (time.Time, bool) {
+	var next time.Time
+	for _, p := range c.pending {
+		if t := p.sentAt.Add(p.rto); next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Pending 等待确认的帧数
+func (c *Channel) Pending() int {
+	return len(c.pending)
+}
+
+// Stats 累计重传、放弃与重复接收的帧数
+func (c *Channel) Stats() (retransmits, lost, duplicates uint64) {
+	return c.retransmits, c.lost, c.duplicates
+}
+
+// ack 移除对端已确认的待确认帧。接收侧乱序缓存不超过 ackBitsWindow，
+// 因此早于位图范围的序列号必然已被按序交付，一并视为已确认
+func (c *Channel) ack(h *ReliableHeader) {
+	if h.Ack == 0 {
+		return
+	}
+	for seq := range c.pending {
+		switch {
+		case seq > h.Ack:
+		case seq == h.Ack, h.Ack-seq > ackBitsWindow, h.AckBits&(1<<(h.Ack-seq-1)) != 0:
+			delete(c.pending, seq)
+		}
+	}
+}
+
+// markReceived 更新确认序列号与确认位图
+func (c *Channel) markReceived(seq uint32) {
+	switch {
+	case c.highest == 0:
+		c.highest = seq
+	case seq > c.highest:
+		shift := seq - c.highest
+		if shift > ackBitsWindow {
+			c.ackBits = 0
+		} else {
+			c.ackBits = c.ackBits<<shift | 1<<(shift-1)
+		}
+		c.highest = seq
+	case seq < c.highest && c.highest-seq <= ackBitsWindow:
+		c.ackBits |= 1 << (c.highest - seq - 1)
+	}
+}
+
+func (c *Channel) stampAck(h *ReliableHeader) {
+	h.Ack = c.highest
+	h.AckBits = c.ackBits
+}
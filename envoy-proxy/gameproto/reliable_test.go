@@ -0,0 +1,321 @@
+package gameproto
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// reliableFrame 构造对端发来的可靠数据帧
+func reliableFrame(seq uint32, payload string) *Frame {
+	return &Frame{Type: TypeBattle, Reliable: &ReliableHeader{Channel: ChannelBattle, Seq: seq}, Payload: []byte(payload)}
+}
+
+func payloads(frames []*Frame) []string {
+	out := make([]string, len(frames))
+	for i, f := range frames {
+		out[i] = string(f.Payload)
+	}
+	return out
+}
+
+func seqs(frames []*Frame) []uint32 {
+	out := make([]uint32, len(frames))
+	for i, f := range frames {
+		out[i] = f.Reliable.Seq
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestChannelReceive(t *testing.T) {
+	type step struct {
+		seq       uint32
+		wantDeliv []string
+		wantDup   bool
+	}
+	tests := []struct {
+		name        string
+		steps       []step
+		wantAck     uint32
+		wantAckBits uint32
+	}{
+		{
+			name:    "按序到达",
+			steps:   []step{{1, []string{"1"}, false}, {2, []string{"2"}, false}, {3, []string{"3"}, false}},
+			wantAck: 3, wantAckBits: 0b11,
+		},
+		{
+			name:    "乱序到达时缓存并连带交付",
+			steps:   []step{{3, nil, false}, {2, nil, false}, {1, []string{"1", "2", "3"}, false}},
+			wantAck: 3, wantAckBits: 0b11,
+		},
+		{
+			name:    "已交付的帧重复到达",
+			steps:   []step{{1, []string{"1"}, false}, {1, nil, true}},
+			wantAck: 1,
+		},
+		{
+			name:    "已缓存的帧重复到达",
+			steps:   []step{{2, nil, false}, {2, nil, true}},
+			wantAck: 2,
+		},
+		{
+			name:    "缺失帧在确认位图中留空",
+			steps:   []step{{1, []string{"1"}, false}, {3, nil, false}, {5, nil, false}},
+			wantAck: 5, wantAckBits: 0b1010,
+		},
+		{
+			name:    "超出接收窗口的帧不记录",
+			steps:   []step{{ackBitsWindow + 1, nil, false}, {ackBitsWindow, nil, false}},
+			wantAck: ackBitsWindow,
+		},
+		{
+			name:  "纯确认帧不交付",
+			steps: []step{{0, nil, false}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChannel(ChannelBattle, ReliableOptions{})
+			for i, s := range tt.steps {
+				deliver, dup := c.Receive(reliableFrame(s.seq, strconv.FormatUint(uint64(s.seq), 10)))
+				if dup != s.wantDup {
+					t.Fatalf("step %d: duplicate = %v, want %v", i, dup, s.wantDup)
+				}
+				if s.wantDeliv != nil && !equalStrings(payloads(deliver), s.wantDeliv) || s.wantDeliv == nil && len(deliver) != 0 {
+					t.Fatalf("step %d: deliver = %v, want %v", i, payloads(deliver), s.wantDeliv)
+				}
+			}
+			ack := c.AckFrame(1)
+			if ack.Type != TypeAck || ack.Reliable.Seq != 0 {
+				t.Fatalf("AckFrame = %+v", ack)
+			}
+			if ack.Reliable.Ack != tt.wantAck || ack.Reliable.AckBits != tt.wantAckBits {
+				t.Errorf("ack = %d bits %b, want %d bits %b", ack.Reliable.Ack, ack.Reliable.AckBits, tt.wantAck, tt.wantAckBits)
+			}
+		})
+	}
+}
+
+func TestChannelReceiveCopiesBufferedPayload(t *testing.T) {
+	c := NewChannel(ChannelBattle, ReliableOptions{})
+	buf := []byte("two")
+	c.Receive(&Frame{Type: TypeBattle, Reliable: &ReliableHeader{Seq: 2}, Payload: buf})
+	copy(buf, "XXX") // 读取缓冲区被下一个数据报覆盖
+
+	deliver, _ := c.Receive(reliableFrame(1, "one"))
+	if got := payloads(deliver); !equalStrings(got, []string{"one", "two"}) {
+		t.Fatalf("deliver = %v, want [one two]", got)
+	}
+}
+
+func TestChannelSelectiveAck(t *testing.T) {
+	sender := NewChannel(ChannelBattle, ReliableOptions{RTO: 100 * time.Millisecond})
+	receiver := NewChannel(ChannelBattle, ReliableOptions{})
+	now := time.Now()
+
+	var sent []*Frame
+	for i := 1; i <= 5; i++ {
+		f := &Frame{Type: TypeBattle, Payload: []byte{byte(i)}}
+		if err := sender.Send(f, now); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		sent = append(sent, f)
+	}
+	// 2 与 4 丢失
+	for _, i := range []int{0, 2, 4} {
+		receiver.Receive(sent[i])
+	}
+	sender.Receive(receiver.AckFrame(0))
+
+	if sender.Pending() != 2 {
+		t.Fatalf("Pending = %d, want 2", sender.Pending())
+	}
+	resend, dropped := sender.Due(now.Add(100 * time.Millisecond))
+	if got := seqs(resend); len(got) != 2 || got[0] != 2 || got[1] != 4 || len(dropped) != 0 {
+		t.Fatalf("resend = %v, dropped = %d, want [2 4]", got, len(dropped))
+	}
+	// 重传帧携带最新的确认信息
+	for _, f := range resend {
+		if f.Reliable.Ack != 0 {
+			t.Errorf("发送端未收到数据帧，重传帧的确认序列号应为0: %d", f.Reliable.Ack)
+		}
+	}
+
+	for _, f := range resend {
+		receiver.Receive(f)
+	}
+	sender.Receive(receiver.AckFrame(0))
+	if sender.Pending() != 0 {
+		t.Errorf("全部确认后 Pending = %d", sender.Pending())
+	}
+}
+
+func TestChannelAckBeforeBitmapWindow(t *testing.T) {
+	sender := NewChannel(ChannelBattle, ReliableOptions{SendWindow: 64})
+	now := time.Now()
+	for i := 0; i < 40; i++ {
+		sender.Send(&Frame{Type: TypeBattle}, now)
+	}
+	// 确认40且位图为空：位图范围之前的序列号必然已按序交付
+	sender.Receive(&Frame{Type: TypeAck, Reliable: &ReliableHeader{Ack: 40}})
+	if sender.Pending() != ackBitsWindow {
+		t.Fatalf("Pending = %d, want %d", sender.Pending(), ackBitsWindow)
+	}
+}
+
+func TestChannelRetransmitBackoffAndLoss(t *testing.T) {
+	opts := ReliableOptions{RTO: 100 * time.Millisecond, MaxRTO: 300 * time.Millisecond, MaxRetries: 3, SendWindow: 1}
+	c := NewChannel(ChannelBattle, opts)
+	now := time.Now()
+	if err := c.Send(&Frame{Type: TypeBattle}, now); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := c.Send(&Frame{Type: TypeBattle}, now); !errors.Is(err, ErrSendWindowFull) {
+		t.Fatalf("err = %v, want %v", err, ErrSendWindowFull)
+	}
+
+	// 重传间隔 100ms、200ms、300ms（封顶），之后放弃
+	wantGaps := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for i, gap := range wantGaps {
+		if resend, _ := c.Due(now.Add(gap - time.Millisecond)); len(resend) != 0 {
+			t.Fatalf("第 %d 次重传提前发生", i+1)
+		}
+		if next, ok := c.NextDue(); !ok || !next.Equal(now.Add(gap)) {
+			t.Fatalf("NextDue = %v, want %v", next.Sub(now), gap)
+		}
+		now = now.Add(gap)
+		if resend, dropped := c.Due(now); len(resend) != 1 || len(dropped) != 0 {
+			t.Fatalf("第 %d 次重传: resend %d dropped %d", i+1, len(resend), len(dropped))
+		}
+	}
+	_, dropped := c.Due(now.Add(opts.MaxRTO))
+	if len(dropped) != 1 || c.Pending() != 0 {
+		t.Fatalf("重试耗尽后 dropped = %d, Pending = %d", len(dropped), c.Pending())
+	}
+	if _, ok := c.NextDue(); ok {
+		t.Error("没有待确认帧时 NextDue 应返回 false")
+	}
+	retransmits, lost, _ := c.Stats()
+	if retransmits != 3 || lost != 1 {
+		t.Errorf("Stats = retransmits %d lost %d, want 3 1", retransmits, lost)
+	}
+}
+
+// TestChannelLossyLink 数据帧与确认帧都经过丢包、重复与乱序的链路，每条消息恰好按序交付一次
+func TestChannelLossyLink(t *testing.T) {
+	const messages = 200
+	rng := rand.New(rand.NewSource(42))
+	opts := ReliableOptions{RTO: 20 * time.Millisecond, MaxRTO: 80 * time.Millisecond, MaxRetries: 100, SendWindow: 16}
+	a, b := NewChannel(ChannelBattle, opts), NewChannel(ChannelBattle, opts)
+	now := time.Unix(0, 0)
+
+	type inflight struct {
+		to    *Channel
+		frame []byte
+		at    time.Time
+	}
+	var link []inflight
+	transmit := func(to *Channel, f *Frame) {
+		if rng.Float64() < 0.3 {
+			return // 丢包
+		}
+		b, err := Encode(f)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		copies := 1
+		if rng.Float64() < 0.1 {
+			copies = 2 // 重复
+		}
+		for i := 0; i < copies; i++ {
+			link = append(link, inflight{to: to, frame: b, at: now.Add(time.Duration(rng.Intn(30)) * time.Millisecond)})
+		}
+	}
+	peer := map[*Channel]*Channel{a: b, b: a}
+	received := map[*Channel][]string{}
+
+	sent := 0
+	for step := 0; step < 100000; step++ {
+		if sent < messages && a.Pending() < opts.SendWindow {
+			f := &Frame{Type: TypeBattle, Payload: []byte{byte(sent), byte(sent >> 8)}}
+			if err := a.Send(f, now); err == nil {
+				transmit(b, f)
+				sent++
+			}
+		}
+
+		// 投递到期的数据报，顺序随延迟打乱
+		remaining := link[:0]
+		var arrived []inflight
+		for _, d := range link {
+			if d.at.After(now) {
+				remaining = append(remaining, d)
+			} else {
+				arrived = append(arrived, d)
+			}
+		}
+		link = remaining
+		for _, d := range arrived {
+			f, err := Decode(d.frame)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			deliver, _ := d.to.Receive(f)
+			for _, m := range deliver {
+				received[d.to] = append(received[d.to], string(m.Payload))
+			}
+			if f.Reliable.Seq != 0 {
+				transmit(peer[d.to], d.to.AckFrame(0))
+			}
+		}
+
+		for _, c := range []*Channel{a, b} {
+			resend, dropped := c.Due(now)
+			if len(dropped) != 0 {
+				t.Fatalf("帧在 MaxRetries 内未送达")
+			}
+			for _, f := range resend {
+				transmit(peer[c], f)
+			}
+		}
+
+		if sent == messages && a.Pending() == 0 {
+			break
+		}
+		now = now.Add(5 * time.Millisecond)
+	}
+
+	if sent != messages || a.Pending() != 0 {
+		t.Fatalf("传输未完成: sent %d pending %d", sent, a.Pending())
+	}
+	got := received[b]
+	if len(got) != messages {
+		t.Fatalf("交付 %d 条消息, want %d", len(got), messages)
+	}
+	for i, p := range got {
+		if p != string([]byte{byte(i), byte(i >> 8)}) {
+			t.Fatalf("第 %d 条消息乱序或重复", i)
+		}
+	}
+	if _, _, dups := b.Stats(); dups == 0 {
+		t.Error("链路没有产生重复帧，测试未覆盖去重")
+	}
+}
@@ -0,0 +1,159 @@
+package gameproto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 会话票据由匹配服务签发，客户端在 TypeHello 帧的负载中携带，游戏服务器校验通过后才建立会话。
+// 票据布局（大端序），签名为 HMAC-SHA256，覆盖签名之前的全部字节:
+//
+//	+------+-----------+--------+--------+----------+----------+------------+----------+--------+
+//	| 版本 | 密钥ID长度 | 密钥ID | 会话ID | 签发时间 | 过期时间 | 服务器ID长度 | 服务器ID | 签名   |
+//	| 1B   | 1B        | N字节  | 8B     | 8B       | 8B       | 1B         | M字节    | 32B    |
+//	+------+-----------+--------+--------+----------+----------+------------+----------+--------+
+//
+// 时间为Unix秒。密钥ID用于轮换：校验方同时持有新旧密钥，签发方切换到新密钥后再移除旧密钥
+const (
+	ticketVersion uint8 = 1
+	ticketMACSize       = sha256.Size
+
+	// TicketClockSkew 校验签发时间时容忍的时钟偏差
+	TicketClockSkew = 30 * time.Second
+)
+
+var (
+	ErrTicketFormat      = errors.New("gameproto: 票据格式无效")
+	ErrTicketKey         = errors.New("gameproto: 票据密钥未知")
+	ErrTicketSignature   = errors.New("gameproto: 票据签名无效")
+	ErrTicketExpired     = errors.New("gameproto: 票据已过期")
+	ErrTicketNotYetValid = errors.New("gameproto: 票据签发时间晚于当前时间")
+)
+
+// Ticket 票据内容
+type Ticket struct {
+	KeyID     string
+	ServerID  string // 票据只能用于该战斗服
+	SessionID uint64 // 票据只能用于该会话，客户端帧头中的会话ID必须与之一致
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TicketKeys 签发与校验票据的HMAC密钥集合，第一个密钥用于签发
+type TicketKeys struct {
+	active string
+	keys   map[string][]byte
+}
+
+// ParseTicketKeys 解析 "keyID:base64密钥,keyID:base64密钥" 格式的密钥列表，第一个为当前签发密钥
+func ParseTicketKeys(s string) (*TicketKeys, error) {
+	k := &TicketKeys{keys: make(map[string][]byte)}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("无效的票据密钥 %q，格式应为 keyID:base64密钥", item)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("票据密钥 %s 不是有效的base64: %v", id, err)
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("票据密钥 %s 长度不足32字节", id)
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("票据密钥 %s 重复", id)
+		}
+		if k.active == "" {
+			k.active = id
+		}
+		k.keys[id] = secret
+	}
+	if k.active == "" {
+		return nil, errors.New("未配置票据密钥")
+	}
+	return k, nil
+}
+
+// ActiveKeyID 当前签发密钥的ID
+func (k *TicketKeys) ActiveKeyID() string {
+	return k.active
+}
+
+// Issue 用当前签发密钥签发票据
+func (k *TicketKeys) Issue(serverID string, sessionID uint64, ttl time.Duration, now time.Time) ([]byte, error) {
+	if len(serverID) > 255 {
+		return nil, fmt.Errorf("%w: 服务器ID过长", ErrTicketFormat)
+	}
+
+	b := make([]byte, 0, 2+len(k.active)+24+1+len(serverID)+ticketMACSize)
+	b = append(b, ticketVersion, uint8(len(k.active)))
+	b = append(b, k.active...)
+	b = binary.BigEndian.AppendUint64(b, sessionID)
+	b = binary.BigEndian.AppendUint64(b, uint64(now.Unix()))
+	b = binary.BigEndian.AppendUint64(b, uint64(now.Add(ttl).Unix()))
+	b = append(b, uint8(len(serverID)))
+	b = append(b, serverID...)
+
+	mac := hmac.New(sha256.New, k.keys[k.active])
+	mac.Write(b)
+	return mac.Sum(b), nil
+}
+
+// Verify 校验票据签名与有效期，不检查服务器ID与会话ID，由调用方比对
+func (k *TicketKeys) Verify(b []byte, now time.Time) (*Ticket, error) {
+	t, signed, err := DecodeTicket(b)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := k.keys[t.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTicketKey, t.KeyID)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(b[:signed])
+	if !hmac.Equal(mac.Sum(nil), b[signed:]) {
+		return nil, ErrTicketSignature
+	}
+
+	if now.After(t.ExpiresAt) {
+		return nil, ErrTicketExpired
+	}
+	if t.IssuedAt.After(now.Add(TicketClockSkew)) {
+		return nil, ErrTicketNotYetValid
+	}
+	return t, nil
+}
+
+// DecodeTicket 解析票据内容但不校验签名，客户端据此得知票据绑定的会话ID。
+// 返回签名之前的字节数
+func DecodeTicket(b []byte) (*Ticket, int, error) {
+	if len(b) < 2 || b[0] != ticketVersion {
+		return nil, 0, ErrTicketFormat
+	}
+	off := 2 + int(b[1])
+	if len(b) < off+25 {
+		return nil, 0, ErrTicketFormat
+	}
+	t := &Ticket{KeyID: string(b[2:off])}
+	t.SessionID = binary.BigEndian.Uint64(b[off:])
+	t.IssuedAt = time.Unix(int64(binary.BigEndian.Uint64(b[off+8:])), 0)
+	t.ExpiresAt = time.Unix(int64(binary.BigEndian.Uint64(b[off+16:])), 0)
+	n := int(b[off+24])
+	off += 25
+	if len(b) != off+n+ticketMACSize {
+		return nil, 0, ErrTicketFormat
+	}
+	t.ServerID = string(b[off : off+n])
+	return t, off + n, nil
+}
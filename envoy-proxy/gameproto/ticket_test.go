@@ -0,0 +1,149 @@
+package gameproto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSecret(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func mustTicketKeys(t testing.TB, s string) *TicketKeys {
+	t.Helper()
+	k, err := ParseTicketKeys(s)
+	if err != nil {
+		t.Fatalf("ParseTicketKeys(%q): %v", s, err)
+	}
+	return k
+}
+
+func TestParseTicketKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		in         string
+		wantActive string
+		wantErr    bool
+	}{
+		{"单个密钥", "k1:" + testSecret(1), "k1", false},
+		{"第一个为签发密钥", "k2:" + testSecret(2) + ", k1:" + testSecret(1), "k2", false},
+		{"忽略空项", ",k1:" + testSecret(1) + ",,", "k1", false},
+		{"为空", "", "", true},
+		{"只有分隔符", " , ", "", true},
+		{"缺少冒号", "k1" + testSecret(1), "", true},
+		{"缺少ID", ":" + testSecret(1), "", true},
+		{"ID过长", strings.Repeat("k", 256) + ":" + testSecret(1), "", true},
+		{"不是base64", "k1:not base64!", "", true},
+		{"密钥过短", "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 31)), "", true},
+		{"ID重复", "k1:" + testSecret(1) + ",k1:" + testSecret(2), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseTicketKeys(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && k.ActiveKeyID() != tt.wantActive {
+				t.Errorf("ActiveKeyID = %q, want %q", k.ActiveKeyID(), tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestTicketIssueVerify(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	current := mustTicketKeys(t, "k2:"+testSecret(2)+",k1:"+testSecret(1))
+	previous := mustTicketKeys(t, "k1:"+testSecret(1))
+	other := mustTicketKeys(t, "k2:"+testSecret(9))
+
+	issue := func(k *TicketKeys, serverID string, ttl time.Duration, at time.Time) []byte {
+		b, err := k.Issue(serverID, 42, ttl, at)
+		if err != nil {
+			t.Fatalf("Issue: %v", err)
+		}
+		return b
+	}
+	tamper := func(b []byte, i int) []byte {
+		b = append([]byte(nil), b...)
+		b[i] ^= 1
+		return b
+	}
+	valid := issue(current, "battle-1", time.Minute, now)
+
+	tests := []struct {
+		name   string
+		keys   *TicketKeys
+		ticket []byte
+		now    time.Time
+		want   error
+	}{
+		{"有效", current, valid, now, nil},
+		{"有效期内", current, valid, now.Add(time.Minute), nil},
+		{"已过期", current, valid, now.Add(time.Minute + time.Second), ErrTicketExpired},
+		{"容忍时钟偏差", current, issue(current, "battle-1", time.Minute, now.Add(TicketClockSkew)), now, nil},
+		{"签发时间晚于当前时间", current, issue(current, "battle-1", time.Minute, now.Add(TicketClockSkew+time.Second)), now, ErrTicketNotYetValid},
+		{"轮换后旧密钥签发的票据仍有效", current, issue(previous, "battle-1", time.Minute, now), now, nil},
+		{"校验方尚未加入新密钥", previous, valid, now, ErrTicketKey},
+		{"同ID不同密钥", other, valid, now, ErrTicketSignature},
+		{"会话ID被篡改", current, tamper(valid, 2+len("k2")+7), now, ErrTicketSignature},
+		{"过期时间被篡改", current, tamper(valid, 2+len("k2")+23), now, ErrTicketSignature},
+		{"服务器ID被篡改", current, tamper(valid, len(valid)-ticketMACSize-1), now, ErrTicketSignature},
+		{"签名被篡改", current, tamper(valid, len(valid)-1), now, ErrTicketSignature},
+		{"版本错误", current, tamper(valid, 0), now, ErrTicketFormat},
+		{"被截断", current, valid[:len(valid)-1], now, ErrTicketFormat},
+		{"为空", current, nil, now, ErrTicketFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticket, err := tt.keys.Verify(tt.ticket, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if err == nil && (ticket.ServerID != "battle-1" || ticket.SessionID != 42) {
+				t.Errorf("ticket = %+v", ticket)
+			}
+		})
+	}
+}
+
+func TestTicketIssueServerIDTooLong(t *testing.T) {
+	k := mustTicketKeys(t, "k1:"+testSecret(1))
+	if _, err := k.Issue(strings.Repeat("s", 256), 1, time.Minute, time.Now()); !errors.Is(err, ErrTicketFormat) {
+		t.Fatalf("err = %v, want %v", err, ErrTicketFormat)
+	}
+}
+
+func TestDecodeTicket(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	k := mustTicketKeys(t, "key-2026:"+testSecret(1))
+	b, err := k.Issue("battle.node1:7001", 1<<64-1, 90*time.Second, now)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	ticket, signed, err := DecodeTicket(b)
+	if err != nil {
+		t.Fatalf("DecodeTicket: %v", err)
+	}
+	want := Ticket{KeyID: "key-2026", ServerID: "battle.node1:7001", SessionID: 1<<64 - 1, IssuedAt: now, ExpiresAt: now.Add(90 * time.Second)}
+	if *ticket != want {
+		t.Errorf("ticket = %+v, want %+v", *ticket, want)
+	}
+	if signed != len(b)-ticketMACSize {
+		t.Errorf("signed = %d, want %d", signed, len(b)-ticketMACSize)
+	}
+
+	// 任何长度的截断或追加都不能被解析
+	for n := 0; n < len(b); n++ {
+		if _, _, err := DecodeTicket(b[:n]); !errors.Is(err, ErrTicketFormat) {
+			t.Fatalf("DecodeTicket(b[:%d]) err = %v", n, err)
+		}
+	}
+	if _, _, err := DecodeTicket(append(b, 0)); !errors.Is(err, ErrTicketFormat) {
+		t.Errorf("追加字节后 err = %v", err)
+	}
+}
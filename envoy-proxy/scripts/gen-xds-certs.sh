@@ -0,0 +1,44 @@
+#!/bin/bash
+# 生成xDS mTLS所需的自签名证书，仅用于开发与测试环境
+#
+#   ca.crt                    签发控制平面与Envoy证书的CA
+#   control-plane.crt/.key    xDS服务端证书，SAN 为控制平面的服务名
+#   <node-id>.crt/.key        Envoy客户端证书，CN 与 DNS SAN 均为 node.id，控制平面据此绑定节点身份
+#
+# 用法: ./gen-xds-certs.sh [输出目录] [node-id ...]
+
+set -e
+
+OUT_DIR=${1:-"./certs"}
+shift || true
+NODE_IDS=${@:-"proxy-1"}
+SERVER_NAME=${XDS_SERVER_NAME:-"control-plane"}
+DAYS=${CERT_DAYS:-365}
+
+mkdir -p "$OUT_DIR"
+cd "$OUT_DIR"
+
+if [ ! -f ca.key ]; then
+    echo "生成CA..."
+    openssl req -x509 -newkey rsa:2048 -nodes -keyout ca.key -out ca.crt -days "$DAYS" \
+        -subj "/CN=xds-ca" 2>/dev/null
+fi
+
+# issue <名称> <SAN>
+issue() {
+    openssl req -newkey rsa:2048 -nodes -keyout "$1.key" -out "$1.csr" -subj "/CN=$1" 2>/dev/null
+    printf "subjectAltName=%s\nextendedKeyUsage=serverAuth,clientAuth\n" "$2" > "$1.ext"
+    openssl x509 -req -in "$1.csr" -CA ca.crt -CAkey ca.key -CAcreateserial -out "$1.crt" \
+        -days "$DAYS" -extfile "$1.ext" 2>/dev/null
+    rm -f "$1.csr" "$1.ext"
+}
+
+echo "生成控制平面证书: $SERVER_NAME"
+issue control-plane "DNS:$SERVER_NAME,DNS:localhost,IP:127.0.0.1"
+
+for node in $NODE_IDS; do
+    echo "生成Envoy客户端证书: $node"
+    issue "$node" "DNS:$node"
+done
+
+echo "证书已生成到 $(pwd)"